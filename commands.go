@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"ozon-comments-graphql/graph"
+	"ozon-comments-graphql/internal/config"
+	"ozon-comments-graphql/internal/loadtest"
+	"ozon-comments-graphql/internal/persisted"
+	"ozon-comments-graphql/internal/seed"
+	"ozon-comments-graphql/internal/transfer"
+	"syscall"
+)
+
+// commands are maintenance subcommands run as "server <command> [flags]"
+// instead of starting the HTTP server.
+var commands = map[string]func(args []string) error{
+	"manifest": runManifest,
+	"export":   runExport,
+	"import":   runImport,
+	"seed":     runSeed,
+	"loadtest": runLoadTest,
+}
+
+// runManifest builds the persisted query allow-list from client documents.
+func runManifest(args []string) error {
+	fs := flag.NewFlagSet("manifest", flag.ContinueOnError)
+	out := fs.String("out", "", "output file (default stdout)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: server manifest [-out file] <dir or .graphql file>...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+
+	schema := graph.NewExecutableSchema(graph.Config{Resolvers: &graph.Resolver{}}).Schema()
+	manifest, err := persisted.Generate(schema, fs.Args())
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		return manifest.Encode(os.Stdout)
+	}
+	if err := manifest.Write(*out); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "wrote %d operations to %s\n", len(manifest.Operations), *out)
+	return nil
+}
+
+// runExport streams all posts and comments as NDJSON to a file or stdout.
+// Storage is configured as for the server: flags, environment or -config.
+func runExport(args []string) error {
+	cfg, rest, err := config.LoadArgs("export", args, nil)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 1 {
+		return fmt.Errorf("usage: server export [flags] [file]")
+	}
+	if err := requirePersistentStorage("export", cfg); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	store, err := openStorage(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	var w io.Writer = os.Stdout
+	if len(rest) == 1 {
+		f, err := os.Create(rest[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	stats, err := transfer.Export(ctx, store, w)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d posts and %d comments\n", stats.Posts, stats.Comments)
+	return nil
+}
+
+// runImport loads an NDJSON export from a file or stdin.
+func runImport(args []string) error {
+	var dryRun bool
+	cfg, rest, err := config.LoadArgs("import", args, func(fs *flag.FlagSet) {
+		fs.BoolVar(&dryRun, "dry-run", false, "only validate the file")
+	})
+	if err != nil {
+		return err
+	}
+	if len(rest) > 1 {
+		return fmt.Errorf("usage: server import [-dry-run] [flags] [file]")
+	}
+	if !dryRun {
+		if err := requirePersistentStorage("import", cfg); err != nil {
+			return err
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	store, err := openStorage(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	var r io.Reader = os.Stdin
+	if len(rest) == 1 {
+		f, err := os.Open(rest[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	stats, err := transfer.Import(ctx, store, r, dryRun)
+	verb := "imported"
+	if dryRun {
+		verb = "validated"
+	}
+	fmt.Fprintf(os.Stderr, "%s %d posts and %d comments\n", verb, stats.Posts, stats.Comments)
+	return err
+}
+
+// runSeed fills storage with reproducible test data.
+func runSeed(args []string) error {
+	opts := seed.DefaultOptions()
+	cfg, rest, err := config.LoadArgs("seed", args, func(fs *flag.FlagSet) {
+		fs.Int64Var(&opts.Seed, "seed", opts.Seed, "random seed; the same seed produces the same data")
+		fs.IntVar(&opts.Posts, "posts", opts.Posts, "number of posts")
+		fs.IntVar(&opts.Comments, "comments", opts.Comments, "comments per post")
+		fs.IntVar(&opts.MaxDepth, "depth", opts.MaxDepth, "maximum reply depth")
+		fs.Float64Var(&opts.ReplyRatio, "reply-ratio", opts.ReplyRatio, "share of comments that are replies")
+	})
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("usage: server seed [flags]")
+	}
+	if err := requirePersistentStorage("seed", cfg); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	store, err := openStorage(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	stats, err := seed.Run(ctx, store, opts)
+	fmt.Fprintf(os.Stderr, "seeded %d posts and %d comments, max depth %d\n", stats.Posts, stats.Comments, stats.MaxDepth)
+	return err
+}
+
+// requirePersistentStorage rejects the in-memory store for commands that read
+// or write the server's data: each process has its own, so they would work on
+// an empty store that is thrown away on exit. A server running on in-memory
+// storage exports and imports through its /admin/data endpoint instead.
+func requirePersistentStorage(command string, cfg *config.Config) error {
+	if cfg.Storage.Type == config.StorageMemory {
+		return fmt.Errorf("%s: in-memory storage is private to each process, use -storage-type %s or the server's /admin/data endpoint", command, config.StoragePostgres)
+	}
+	return nil
+}
+
+// runLoadTest drives a running server and prints the report.
+func runLoadTest(args []string) error {
+	cfg := loadtest.DefaultConfig()
+	fs := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	fs.StringVar(&cfg.URL, "url", cfg.URL, "GraphQL endpoint of a running server")
+	fs.IntVar(&cfg.Posts, "posts", cfg.Posts, "posts to spread comments across")
+	fs.IntVar(&cfg.Subscribers, "subscribers", cfg.Subscribers, "commentAdded subscriptions per post")
+	fs.IntVar(&cfg.Writers, "writers", cfg.Writers, "concurrent createComment senders")
+	fs.DurationVar(&cfg.Duration, "duration", cfg.Duration, "how long to send mutations")
+	fs.DurationVar(&cfg.Settle, "settle", cfg.Settle, "pause after subscribing before the first mutation")
+	fs.DurationVar(&cfg.Drain, "drain", cfg.Drain, "time to wait for deliveries after the last mutation")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	report, err := loadtest.Run(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	report.Print(os.Stdout)
+	return nil
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDataCommandsRejectMemoryStorage(t *testing.T) {
+	for name, run := range map[string]func([]string) error{
+		"export": runExport,
+		"import": runImport,
+		"seed":   runSeed,
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := run([]string{"-storage-type", "memory"})
+			assert.ErrorContains(t, err, "in-memory storage")
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"ozon-comments-graphql/graph/model"
+	"ozon-comments-graphql/internal/storage"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const defaultActivityInterval = time.Second
+
+// activityState throttles the postActivity updates of one post. It exists
+// while the post has postActivity subscribers. timer is set while an update
+// is scheduled, so further changes until then are folded into it.
+type activityState struct {
+	last  time.Time
+	timer *time.Timer
+}
+
+// WithActivityInterval sets the minimum time between two postActivity
+// updates for the same post.
+func WithActivityInterval(d time.Duration) BrokerOption {
+	return func(b *Broker) {
+		b.activityInterval = d
+	}
+}
+
+// SubscribeActivity subscribes to the viewer and comment counts of a post.
+// The first update arrives within one activity interval.
+func (b *Broker) SubscribeActivity(postID string) chan *model.PostActivity {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan *model.PostActivity, b.bufferSize)
+	if b.closed {
+		close(ch)
+		return ch
+	}
+	b.activity.add(postID, ch, struct{}{})
+	if _, ok := b.activityStates[postID]; !ok {
+		b.activityStates[postID] = &activityState{}
+	}
+	b.scheduleActivity(postID)
+	return ch
+}
+
+func (b *Broker) UnsubscribeActivity(postID string, ch chan *model.PostActivity) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.activity.remove(postID, ch)
+	if _, ok := b.activity.subs[postID]; !ok {
+		b.dropActivity(postID)
+	}
+}
+
+// touchActivity notes that the activity of a post changed. It runs on every
+// publish, so the common cases, no postActivity subscribers or an update
+// already scheduled, only take the read lock.
+func (b *Broker) touchActivity(postID string) {
+	b.mu.RLock()
+	st, ok := b.activityStates[postID]
+	scheduled := ok && st.timer != nil
+	b.mu.RUnlock()
+	if !ok || scheduled {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.scheduleActivity(postID)
+}
+
+// scheduleActivity arranges an update for a post with postActivity
+// subscribers, no sooner than one interval after the previous one. The
+// caller holds b.mu.
+func (b *Broker) scheduleActivity(postID string) {
+	st, ok := b.activityStates[postID]
+	if !ok || st.timer != nil {
+		return
+	}
+	wait := time.Until(st.last.Add(b.activityInterval))
+	if wait < 0 {
+		wait = 0
+	}
+	st.timer = time.AfterFunc(wait, func() { b.emitActivity(postID) })
+}
+
+// dropActivity forgets the throttling state of a post. The caller holds b.mu.
+func (b *Broker) dropActivity(postID string) {
+	if st, ok := b.activityStates[postID]; ok && st.timer != nil {
+		st.timer.Stop()
+	}
+	delete(b.activityStates, postID)
+}
+
+func (b *Broker) emitActivity(postID string) {
+	b.mu.Lock()
+	st, ok := b.activityStates[postID]
+	if !ok {
+		b.mu.Unlock()
+		return
+	}
+	st.timer = nil
+	st.last = time.Now()
+	b.mu.Unlock()
+
+	ctx, span := tracer.Start(context.Background(), "broker.publish postActivity",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("post.id", postID)),
+	)
+	defer span.End()
+
+	// The count is read outside the lock; a comment published meanwhile
+	// schedules another update.
+	var comments int32
+	if b.store != nil {
+		p, err := b.store.GetPost(ctx, postID)
+		if err != nil {
+			if !errors.Is(err, storage.ErrNotFound) {
+				slog.WarnContext(ctx, "post activity lookup failed", "post_id", postID, "err", err)
+			}
+			return
+		}
+		comments = int32(p.CommentCount)
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	deliver(ctx, b, b.activity, postID, &model.PostActivity{
+		PostID:       postID,
+		Viewers:      int32(len(b.comments.subs[postID])),
+		CommentCount: comments,
+	}, nil)
+}
@@ -0,0 +1,148 @@
+package graph
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"ozon-comments-graphql/graph/model"
+	"ozon-comments-graphql/internal/attachment"
+	"ozon-comments-graphql/internal/models"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultMaxAttachmentSize = 10 << 20
+	defaultMaxAttachments    = 5
+	// maxCommentPixels bounds the images of one comment together, so that a
+	// comment full of large images is rejected before any is decoded.
+	maxCommentPixels = 2 * attachment.MaxPixels
+)
+
+var (
+	ErrAttachmentsDisabled = errors.New("attachments are disabled on this server")
+	ErrTooManyAttachments  = errors.New("too many attachments")
+)
+
+// readAttachments validates the uploads of one comment and makes the
+// thumbnails before anything is stored.
+func (r *Resolver) readAttachments(ctx context.Context, uploads []*graphql.Upload) ([]*attachment.File, error) {
+	if len(uploads) == 0 {
+		return nil, nil
+	}
+	if r.Blobs == nil {
+		return nil, ErrAttachmentsDisabled
+	}
+	maxCount, maxSize := r.MaxAttachments, r.MaxAttachmentSize
+	if maxCount <= 0 {
+		maxCount = defaultMaxAttachments
+	}
+	if maxSize <= 0 {
+		maxSize = defaultMaxAttachmentSize
+	}
+	if len(uploads) > maxCount {
+		return nil, fmt.Errorf("%w: at most %d per comment", ErrTooManyAttachments, maxCount)
+	}
+
+	files := make([]*attachment.File, len(uploads))
+	pixels := 0
+	for i, u := range uploads {
+		if u.Size > maxSize {
+			return nil, fmt.Errorf("%w: %q is over %d bytes", attachment.ErrTooLarge, u.Filename, maxSize)
+		}
+		f, err := attachment.Read(u.Filename, u.File, maxSize)
+		if err != nil {
+			return nil, err
+		}
+		files[i] = f
+		pixels += f.Pixels()
+	}
+	if pixels > maxCommentPixels {
+		return nil, fmt.Errorf("%w: images have %d pixels together, at most %d per comment",
+			attachment.ErrTooLarge, pixels, maxCommentPixels)
+	}
+
+	for _, f := range files {
+		if err := f.MakeThumbnail(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// putAttachments writes the files and their thumbnails to the blob store.
+// The returned attachments lack their comment ID. Nothing is left behind when
+// a write fails.
+func (r *Resolver) putAttachments(ctx context.Context, files []*attachment.File) ([]*models.Attachment, error) {
+	res := make([]*models.Attachment, 0, len(files))
+	now := time.Now()
+	for _, f := range files {
+		id := uuid.NewString()
+		a := &models.Attachment{
+			ID:          id,
+			Filename:    f.Filename,
+			ContentType: f.ContentType,
+			Size:        int64(len(f.Data)),
+			Key:         "attachments/" + id + f.Ext,
+			CreatedAt:   now,
+		}
+		if err := r.Blobs.Put(ctx, a.Key, bytes.NewReader(f.Data), f.ContentType); err != nil {
+			r.deleteAttachments(ctx, res)
+			return nil, err
+		}
+		res = append(res, a)
+
+		if f.Thumbnail == nil {
+			continue
+		}
+		key := "attachments/" + id + "_thumb" + f.Thumbnail.Ext
+		if err := r.Blobs.Put(ctx, key, bytes.NewReader(f.Thumbnail.Data), f.Thumbnail.ContentType); err != nil {
+			r.deleteAttachments(ctx, res)
+			return nil, err
+		}
+		width, height := f.Width, f.Height
+		a.ThumbnailKey, a.Width, a.Height = &key, &width, &height
+	}
+	return res, nil
+}
+
+// deleteAttachments removes the blobs of attachments that were not recorded
+// after all, or whose post was deleted. Failures only leave orphaned blobs,
+// so they are just logged.
+func (r *Resolver) deleteAttachments(ctx context.Context, attachments []*models.Attachment) {
+	for _, a := range attachments {
+		keys := []string{a.Key}
+		if a.ThumbnailKey != nil {
+			keys = append(keys, *a.ThumbnailKey)
+		}
+		for _, key := range keys {
+			if err := r.Blobs.Delete(ctx, key); err != nil {
+				slog.WarnContext(ctx, "orphaned attachment blob", "key", key, "err", err)
+			}
+		}
+	}
+}
+
+func (r *Resolver) toModelAttachment(a *models.Attachment) *model.Attachment {
+	res := &model.Attachment{
+		ID:          a.ID,
+		Filename:    a.Filename,
+		ContentType: a.ContentType,
+		Size:        int32(a.Size),
+		URL:         r.Blobs.URL(a.Key),
+		CreatedAt:   a.CreatedAt,
+	}
+	if a.ThumbnailKey != nil {
+		url := r.Blobs.URL(*a.ThumbnailKey)
+		res.ThumbnailURL = &url
+	}
+	if a.Width != nil && a.Height != nil {
+		width, height := int32(*a.Width), int32(*a.Height)
+		res.Width, res.Height = &width, &height
+	}
+	return res
+}
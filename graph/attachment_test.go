@@ -0,0 +1,262 @@
+package graph_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"ozon-comments-graphql/graph"
+	"ozon-comments-graphql/internal/attachment"
+	"ozon-comments-graphql/internal/blob"
+	"ozon-comments-graphql/internal/models"
+	"ozon-comments-graphql/internal/storage"
+
+	gql "github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func upload(filename string, data []byte) *gql.Upload {
+	return &gql.Upload{File: bytes.NewReader(data), Filename: filename, Size: int64(len(data))}
+}
+
+func testPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h))))
+	return buf.Bytes()
+}
+
+func newAttachmentResolver(t *testing.T) (*graph.Resolver, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	blobs, err := blob.NewFS(dir, "/attachments/")
+	require.NoError(t, err)
+	return &graph.Resolver{
+		Store:             storage.NewMemoryStorage(),
+		Broker:            graph.NewBroker(),
+		Blobs:             blobs,
+		MaxAttachments:    2,
+		MaxAttachmentSize: 1 << 20,
+	}, dir
+}
+
+func TestCommentAttachments(t *testing.T) {
+	r, _ := newAttachmentResolver(t)
+	ctx := context.Background()
+	post, _ := r.Mutation().CreatePost(ctx, "Test", "Content")
+
+	comment, err := r.Mutation().CreateComment(ctx, post.ID, nil, "See files", nil, []*gql.Upload{
+		upload("photo.png", testPNG(t, 512, 300)),
+		upload("notes.txt", []byte("some notes\n")),
+	})
+	require.NoError(t, err)
+
+	attachments, err := r.Comment().Attachments(ctx, comment)
+	require.NoError(t, err)
+	require.Len(t, attachments, 2)
+
+	img := attachments[0]
+	assert.Equal(t, "photo.png", img.Filename)
+	assert.Equal(t, "image/png", img.ContentType)
+	assert.True(t, strings.HasPrefix(img.URL, "/attachments/attachments/"), img.URL)
+	require.NotNil(t, img.ThumbnailURL)
+	assert.Contains(t, *img.ThumbnailURL, "_thumb.png")
+	require.NotNil(t, img.Width)
+	assert.Equal(t, int32(512), *img.Width)
+	assert.Equal(t, int32(300), *img.Height)
+
+	txt := attachments[1]
+	assert.Equal(t, "text/plain", txt.ContentType)
+	assert.Equal(t, int32(11), txt.Size)
+	assert.Nil(t, txt.ThumbnailURL)
+	assert.Nil(t, txt.Width)
+}
+
+func TestCommentAttachmentsRejected(t *testing.T) {
+	r, dir := newAttachmentResolver(t)
+	ctx := context.Background()
+	post, _ := r.Mutation().CreatePost(ctx, "Test", "Content")
+
+	_, err := r.Mutation().CreateComment(ctx, post.ID, nil, "Too many", nil, []*gql.Upload{
+		upload("a.txt", []byte("a")), upload("b.txt", []byte("b")), upload("c.txt", []byte("c")),
+	})
+	assert.True(t, errors.Is(err, graph.ErrTooManyAttachments), "got %v", err)
+
+	_, err = r.Mutation().CreateComment(ctx, post.ID, nil, "Script", nil, []*gql.Upload{
+		upload("page.html", []byte("<html><body>hi</body></html>")),
+	})
+	assert.True(t, errors.Is(err, attachment.ErrUnsupported), "got %v", err)
+
+	_, err = r.Mutation().CreateComment(ctx, "missing", nil, "No post", nil, []*gql.Upload{
+		upload("photo.png", testPNG(t, 10, 10)),
+	})
+	assert.True(t, errors.Is(err, storage.ErrNotFound), "got %v", err)
+
+	// Blobs of the failed comment are removed again.
+	entries, err := os.ReadDir(filepath.Join(dir, "attachments"))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	comments, err := r.Query().Comments(ctx, post.ID, nil, nil)
+	require.NoError(t, err)
+	assert.Empty(t, comments.Items)
+}
+
+func TestCommentAttachmentsPixelBudget(t *testing.T) {
+	r, _ := newAttachmentResolver(t)
+	r.MaxAttachments = 5
+	ctx := context.Background()
+	post, _ := r.Mutation().CreatePost(ctx, "Test", "Content")
+
+	// Three valid headers of 12 MP each: within the per-image limit, over
+	// the per-comment budget. The bodies are missing, so getting to decode
+	// them would fail with ErrInvalid instead.
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4000, 3000))))
+	header := buf.Bytes()[:33] // signature and IHDR
+	var uploads []*gql.Upload
+	for i := 0; i < 3; i++ {
+		uploads = append(uploads, upload("big.png", header))
+	}
+	_, err := r.Mutation().CreateComment(ctx, post.ID, nil, "Huge", nil, uploads)
+	assert.ErrorIs(t, err, attachment.ErrTooLarge)
+}
+
+func TestCommentAttachmentsDisabled(t *testing.T) {
+	r := &graph.Resolver{
+		Store:  storage.NewMemoryStorage(),
+		Broker: graph.NewBroker(),
+	}
+	ctx := context.Background()
+	post, _ := r.Mutation().CreatePost(ctx, "Test", "Content")
+
+	_, err := r.Mutation().CreateComment(ctx, post.ID, nil, "With file", nil, []*gql.Upload{
+		upload("a.txt", []byte("a")),
+	})
+	assert.True(t, errors.Is(err, graph.ErrAttachmentsDisabled))
+
+	comment, err := r.Mutation().CreateComment(ctx, post.ID, nil, "Plain", nil, nil)
+	require.NoError(t, err)
+	attachments, err := r.Comment().Attachments(ctx, comment)
+	require.NoError(t, err)
+	assert.Empty(t, attachments)
+}
+
+func TestHardDeletePostRemovesAttachmentBlobs(t *testing.T) {
+	r, dir := newAttachmentResolver(t)
+	ctx := context.Background()
+	files := func() []string {
+		entries, err := os.ReadDir(filepath.Join(dir, "attachments"))
+		require.NoError(t, err)
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		return names
+	}
+
+	kept, _ := r.Mutation().CreatePost(ctx, "Kept", "Content")
+	_, err := r.Mutation().CreateComment(ctx, kept.ID, nil, "Kept file", nil, []*gql.Upload{
+		upload("notes.txt", []byte("keep me\n")),
+	})
+	require.NoError(t, err)
+	keptFiles := files()
+	require.Len(t, keptFiles, 1)
+
+	post, _ := r.Mutation().CreatePost(ctx, "Test", "Content")
+	_, err = r.Mutation().CreateComment(ctx, post.ID, nil, "See files", nil, []*gql.Upload{
+		upload("photo.png", testPNG(t, 64, 64)),
+		upload("notes.txt", []byte("some notes\n")),
+	})
+	require.NoError(t, err)
+	require.Len(t, files(), 4, "two files and a thumbnail")
+
+	soft := true
+	_, err = r.Mutation().DeletePost(ctx, post.ID, &soft)
+	require.NoError(t, err)
+	assert.Len(t, files(), 4, "soft-deleted posts keep their files")
+
+	other, _ := r.Mutation().CreatePost(ctx, "Other", "Content")
+	_, err = r.Mutation().CreateComment(ctx, other.ID, nil, "More files", nil, []*gql.Upload{
+		upload("photo.png", testPNG(t, 64, 64)),
+	})
+	require.NoError(t, err)
+	_, err = r.Mutation().DeletePost(ctx, other.ID, nil)
+	require.NoError(t, err)
+	assert.Len(t, files(), 4)
+
+	assert.Subset(t, files(), keptFiles)
+}
+
+// countingStorage counts attachment lookups.
+type countingStorage struct {
+	storage.Storage
+	single, batched atomic.Int32
+}
+
+func (s *countingStorage) ListAttachments(ctx context.Context, commentID string) ([]*models.Attachment, error) {
+	s.single.Add(1)
+	return s.Storage.ListAttachments(ctx, commentID)
+}
+
+func (s *countingStorage) ListAttachmentsFor(ctx context.Context, commentIDs []string) (map[string][]*models.Attachment, error) {
+	s.batched.Add(1)
+	return s.Storage.ListAttachmentsFor(ctx, commentIDs)
+}
+
+func TestCommentAttachmentsAreBatched(t *testing.T) {
+	r, _ := newAttachmentResolver(t)
+	store := &countingStorage{Storage: r.Store}
+	r.Store = store
+	ctx := context.Background()
+
+	post, _ := r.Mutation().CreatePost(ctx, "Test", "Content")
+	for i := 0; i < 5; i++ {
+		_, err := r.Mutation().CreateComment(ctx, post.ID, nil, "File", nil, []*gql.Upload{
+			upload("notes.txt", []byte("some notes\n")),
+		})
+		require.NoError(t, err)
+	}
+
+	srv := handler.New(graph.NewExecutableSchema(graph.Config{Resolvers: r}))
+	srv.AddTransport(transport.POST{})
+	srv.Use(graph.Loaders(store))
+
+	body := `{"query":"query($id: ID!) { comments(postID: $id) { items { id attachments { filename } } } }","variables":{"id":"` + post.ID + `"}}`
+	req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	var resp struct {
+		Data struct {
+			Comments struct {
+				Items []struct {
+					Attachments []struct{ Filename string }
+				}
+			}
+		}
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp), rec.Body.String())
+	require.Len(t, resp.Data.Comments.Items, 5)
+	for _, c := range resp.Data.Comments.Items {
+		assert.Len(t, c.Attachments, 1)
+	}
+	assert.Equal(t, int32(1), store.batched.Load())
+	assert.Zero(t, store.single.Load())
+}
@@ -1,59 +1,400 @@
 package graph
 
 import (
+	"context"
+	"errors"
+	"log/slog"
 	"ozon-comments-graphql/graph/model"
+	"ozon-comments-graphql/internal/storage"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("ozon-comments-graphql/graph")
+
+// Broker fans events out to GraphQL subscriptions. Every subscription field
+// has its own topic; one mutex guards them all, so a post deletion can end
+// the subscriptions of several topics atomically.
+type Broker struct {
+	mu            sync.RWMutex
+	comments      *topic[*model.Comment, threadFilter]
+	postEvents    *topic[*model.PostEvent, struct{}]
+	newPosts      *topic[*model.Post, *model.PostFilter]
+	notifications *topic[*model.Notification, struct{}]
+	activity      *topic[*model.PostActivity, struct{}]
+	typing        *topic[[]*model.TypingIndicator, struct{}]
+	closed        bool
+	dropped       atomic.Uint64
+	bufferSize    int
+	// seen holds the IDs of recently relayed outbox events, so redeliveries
+	// within this process are not published twice.
+	seen *lru.Cache[string, struct{}]
+	// parents maps recently published comments to their parent IDs, so
+	// that subtree filters rarely need to ask comments for them.
+	parents *lru.Cache[string, *string]
+	store   storage.Storage
+
+	activityStates   map[string]*activityState
+	activityInterval time.Duration
+
+	// typists holds the live typing indicators per post and client. They
+	// are never stored anywhere else.
+	typists   map[string]map[string]*typing
+	typingTTL time.Duration
+}
+
+const (
+	seenEvents    = 4096
+	knownParents  = 65536
+	maxReplyDepth = 1000
 )
 
-type CommentBroker struct {
-	mu          sync.RWMutex
-	subscribers map[string]map[chan *model.Comment]struct{}
+// threadFilter narrows a commentAdded subscription to one thread: direct
+// replies to parentID, or with descendants set its whole subtree. A nil
+// parentID lets every comment on the post through.
+type threadFilter struct {
+	parentID    *string
+	descendants bool
 }
 
-func NewCommentBroker() *CommentBroker {
-	return &CommentBroker{
-		subscribers: make(map[string]map[chan *model.Comment]struct{}),
+type BrokerOption func(*Broker)
+
+// WithBufferSize sets how many events a subscriber may fall behind before
+// further events to it are dropped.
+func WithBufferSize(n int) BrokerOption {
+	return func(b *Broker) {
+		b.bufferSize = n
 	}
 }
 
-func (b *CommentBroker) Subscribe(postID string) chan *model.Comment {
-	b.mu.Lock()
-	defer b.mu.Unlock()
+// WithCommentStore lets subtree subscriptions look up the ancestors of
+// comments the broker has not seen published, e.g. ones older than the
+// process. Without it such comments are treated as outside every subtree.
+// postActivity reads comment counts from it too, and reports zero without.
+func WithCommentStore(s storage.Storage) BrokerOption {
+	return func(b *Broker) {
+		b.store = s
+	}
+}
+
+func NewBroker(opts ...BrokerOption) *Broker {
+	b := &Broker{
+		comments:      newTopic[*model.Comment, threadFilter]("commentAdded"),
+		postEvents:    newTopic[*model.PostEvent, struct{}]("postChanged"),
+		newPosts:      newTopic[*model.Post, *model.PostFilter]("postAdded"),
+		notifications: newTopic[*model.Notification, struct{}]("notificationAdded"),
+		activity:      newTopic[*model.PostActivity, struct{}]("postActivity"),
+		typing:        newTopic[[]*model.TypingIndicator, struct{}]("typingIndicators"),
+		bufferSize:    1,
 
-	ch := make(chan *model.Comment, 1)
+		activityStates:   make(map[string]*activityState),
+		activityInterval: defaultActivityInterval,
 
-	if _, ok := b.subscribers[postID]; !ok {
-		b.subscribers[postID] = make(map[chan *model.Comment]struct{})
+		typists:   make(map[string]map[string]*typing),
+		typingTTL: defaultTypingTTL,
+	}
+	b.seen, _ = lru.New[string, struct{}](seenEvents)
+	b.parents, _ = lru.New[string, *string](knownParents)
+	for _, opt := range opts {
+		opt(b)
 	}
-	b.subscribers[postID][ch] = struct{}{}
+	return b
+}
+
+func (b *Broker) Subscribe(postID string) chan *model.Comment {
+	return b.SubscribeThread(postID, nil, false)
+}
 
+// SubscribeThread subscribes to the comments on a post that reply to
+// parentID directly or, with descendants set, anywhere in its subtree. A nil
+// parentID means every comment on the post.
+func (b *Broker) SubscribeThread(postID string, parentID *string, descendants bool) chan *model.Comment {
+	ch := subscribe(b, b.comments, postID, threadFilter{parentID: parentID, descendants: descendants})
+	b.touchActivity(postID)
 	return ch
 }
 
-func (b *CommentBroker) Unsubscribe(postID string, ch chan *model.Comment) {
+func (b *Broker) Unsubscribe(postID string, ch chan *model.Comment) {
+	unsubscribe(b, b.comments, postID, ch)
+	b.touchActivity(postID)
+}
+
+func (b *Broker) Publish(ctx context.Context, comment *model.Comment) {
+	ctx, span := tracer.Start(ctx, "broker.publish commentAdded",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("post.id", comment.PostID), attribute.String("comment.id", comment.ID)),
+	)
+	defer span.End()
+
+	b.parents.Add(comment.ID, comment.ParentID)
+	var ancestors map[string]struct{}
+	if b.wantsAncestors(comment.PostID) {
+		ancestors = b.ancestors(ctx, comment)
+	}
+	b.touchActivity(comment.PostID)
+	b.commented(ctx, comment)
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	deliver(ctx, b, b.comments, comment.PostID, comment, func(f threadFilter) bool {
+		return f.matches(comment, ancestors)
+	})
+}
+
+func (f threadFilter) matches(comment *model.Comment, ancestors map[string]struct{}) bool {
+	if f.parentID == nil {
+		return true
+	}
+	if !f.descendants {
+		return comment.ParentID != nil && *comment.ParentID == *f.parentID
+	}
+	_, ok := ancestors[*f.parentID]
+	return ok
+}
+
+// wantsAncestors reports whether any subscriber to the post filters by
+// subtree, which is the only case worth walking up the reply chain for.
+func (b *Broker) wantsAncestors(postID string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, f := range b.comments.subs[postID] {
+		if f.descendants && f.parentID != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// ancestors returns the IDs of every comment above comment in its thread.
+// Parents of recently published comments are known; at the first unknown
+// one, the rest of the chain is fetched from the store in one call. The walk
+// stops early at a comment whose parent cannot be found.
+func (b *Broker) ancestors(ctx context.Context, comment *model.Comment) map[string]struct{} {
+	res := make(map[string]struct{})
+	for id := comment.ParentID; id != nil && len(res) < maxReplyDepth; {
+		if _, ok := res[*id]; ok {
+			break
+		}
+
+		parent, ok := b.parents.Get(*id)
+		if !ok {
+			b.fetchAncestry(ctx, *id, maxReplyDepth-len(res), res)
+			break
+		}
+		res[*id] = struct{}{}
+		id = parent
+	}
+	return res
+}
+
+// fetchAncestry adds id and up to limit-1 comments above it to res,
+// remembering their parents for later walks.
+func (b *Broker) fetchAncestry(ctx context.Context, id string, limit int, res map[string]struct{}) {
+	res[id] = struct{}{}
+	if b.store == nil {
+		return
+	}
+	chain, err := b.store.CommentAncestry(ctx, id, limit)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			slog.WarnContext(ctx, "comment ancestry lookup failed", "comment_id", id, "err", err)
+		}
+		return
+	}
+	for _, c := range chain {
+		res[c.ID] = struct{}{}
+		b.parents.Add(c.ID, c.ParentID)
+	}
+}
+
+func (b *Broker) SubscribePost(postID string) chan *model.PostEvent {
+	return subscribe(b, b.postEvents, postID, struct{}{})
+}
+
+func (b *Broker) UnsubscribePost(postID string, ch chan *model.PostEvent) {
+	unsubscribe(b, b.postEvents, postID, ch)
+}
+
+// PublishPost delivers a post event to its subscribers. A DELETED event also
+// ends every subscription on that post, since nothing more will arrive.
+func (b *Broker) PublishPost(ctx context.Context, event *model.PostEvent) {
+	ctx, span := tracer.Start(ctx, "broker.publish postChanged",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("post.id", event.Post.ID), attribute.String("event.type", string(event.Type))),
+	)
+	defer span.End()
+
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	if subs, ok := b.subscribers[postID]; ok {
-		delete(subs, ch)
-		close(ch)
-		if len(subs) == 0 {
-			delete(b.subscribers, postID)
-		}
+	postID := event.Post.ID
+	deliver(ctx, b, b.postEvents, postID, event, nil)
+
+	if event.Type != model.PostEventTypeDeleted {
+		return
 	}
+	b.comments.closeKey(postID)
+	b.postEvents.closeKey(postID)
+	b.activity.closeKey(postID)
+	b.dropActivity(postID)
+	b.typing.closeKey(postID)
+	b.dropTyping(postID)
+}
+
+// SubscribePostAdded subscribes to new posts matching filter; a nil filter
+// matches every post.
+func (b *Broker) SubscribePostAdded(filter *model.PostFilter) chan *model.Post {
+	return subscribe(b, b.newPosts, "", filter)
+}
+
+func (b *Broker) UnsubscribePostAdded(ch chan *model.Post) {
+	unsubscribe(b, b.newPosts, "", ch)
 }
 
-func (b *CommentBroker) Publish(comment *model.Comment) {
+func (b *Broker) PublishPostAdded(ctx context.Context, post *model.Post) {
+	ctx, span := tracer.Start(ctx, "broker.publish postAdded",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("post.id", post.ID)),
+	)
+	defer span.End()
+
 	b.mu.RLock()
 	defer b.mu.RUnlock()
 
-	postID := comment.PostID
-	if subs, ok := b.subscribers[postID]; ok {
-		for ch := range subs {
-			select {
-			case ch <- comment:
-			default:
-			}
+	deliver(ctx, b, b.newPosts, "", post, func(f *model.PostFilter) bool {
+		return postMatches(post, f)
+	})
+}
+
+// postMatches applies a PostFilter the way the posts query does.
+func postMatches(p *model.Post, f *model.PostFilter) bool {
+	if f == nil {
+		return true
+	}
+	if f.CommentsDisabled != nil && p.CommentsDisabled != *f.CommentsDisabled {
+		return false
+	}
+	if f.CreatedAfter != nil && !p.CreatedAt.After(*f.CreatedAfter) {
+		return false
+	}
+	if f.CreatedBefore != nil && !p.CreatedAt.Before(*f.CreatedBefore) {
+		return false
+	}
+	if f.TitleContains != nil && !strings.Contains(strings.ToLower(p.Title), strings.ToLower(*f.TitleContains)) {
+		return false
+	}
+	return true
+}
+
+// SubscribeNotifications returns a channel receiving notifications for user.
+func (b *Broker) SubscribeNotifications(user string) chan *model.Notification {
+	return subscribe(b, b.notifications, user, struct{}{})
+}
+
+func (b *Broker) UnsubscribeNotifications(user string, ch chan *model.Notification) {
+	unsubscribe(b, b.notifications, user, ch)
+}
+
+func (b *Broker) PublishNotification(ctx context.Context, n *model.Notification) {
+	ctx, span := tracer.Start(ctx, "broker.publish notificationAdded",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("comment.id", n.CommentID), attribute.String("notification.type", string(n.Type))),
+	)
+	defer span.End()
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	deliver(ctx, b, b.notifications, n.User, n, nil)
+}
+
+// SubscriberCounts returns the number of active commentAdded subscribers per post.
+func (b *Broker) SubscriberCounts() map[string]int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.comments.counts()
+}
+
+// TopicSubscribers returns the number of active subscribers per topic.
+func (b *Broker) TopicSubscribers() map[string]int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return map[string]int{
+		b.comments.name:      b.comments.size(),
+		b.postEvents.name:    b.postEvents.size(),
+		b.newPosts.name:      b.newPosts.size(),
+		b.notifications.name: b.notifications.size(),
+		b.activity.name:      b.activity.size(),
+		b.typing.name:        b.typing.size(),
+	}
+}
+
+// WatchedPosts returns how many posts have at least one subscriber to any of
+// the topics keyed by post.
+func (b *Broker) WatchedPosts() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	posts := make(map[string]struct{})
+	for _, keys := range [][]string{b.comments.keys(), b.postEvents.keys(), b.activity.keys(), b.typing.keys()} {
+		for _, key := range keys {
+			posts[key] = struct{}{}
 		}
 	}
+	return len(posts)
+}
+
+// DroppedMessages returns how many events were discarded because a
+// subscriber's buffer was full.
+func (b *Broker) DroppedMessages() uint64 {
+	return b.dropped.Load()
+}
+
+var ErrBrokerClosed = errors.New("broker closed")
+
+// Health reports whether the broker still accepts subscriptions.
+func (b *Broker) Health(_ context.Context) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return ErrBrokerClosed
+	}
+	return nil
+}
+
+// Close ends every active subscription and makes further subscriptions
+// complete immediately. It is called once on server shutdown.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+	b.closed = true
+
+	b.comments.closeAll()
+	b.postEvents.closeAll()
+	b.newPosts.closeAll()
+	b.notifications.closeAll()
+	b.activity.closeAll()
+	for postID := range b.activityStates {
+		b.dropActivity(postID)
+	}
+	b.typing.closeAll()
+	for postID := range b.typists {
+		b.dropTyping(postID)
+	}
 }
@@ -0,0 +1,106 @@
+package graph
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"ozon-comments-graphql/internal/models"
+	"ozon-comments-graphql/internal/storage"
+
+	"github.com/99designs/gqlgen/graphql"
+)
+
+const (
+	// attachmentBatchWait is how long the first attachment lookup of a batch
+	// waits for the other comments being resolved alongside it to join.
+	attachmentBatchWait = time.Millisecond
+	maxAttachmentBatch  = 100
+)
+
+type loadersKey struct{}
+
+// Loaders returns the handler extension that batches the attachment lookups
+// made while resolving one response, e.g. a page of comments, into a single
+// storage call. Without it every comment is looked up on its own.
+func Loaders(store storage.Storage) graphql.HandlerExtension {
+	return loadersExtension{store: store}
+}
+
+type loadersExtension struct {
+	store storage.Storage
+}
+
+func (loadersExtension) ExtensionName() string {
+	return "Loaders"
+}
+
+func (loadersExtension) Validate(graphql.ExecutableSchema) error {
+	return nil
+}
+
+// InterceptResponse starts a fresh loader for every response, so a
+// subscription never serves attachments from an earlier event.
+func (e loadersExtension) InterceptResponse(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	return next(context.WithValue(ctx, loadersKey{}, &attachmentLoader{store: e.store}))
+}
+
+// attachmentLoader collects comment IDs until the batch is full or
+// attachmentBatchWait has passed, then loads all of them at once.
+type attachmentLoader struct {
+	store storage.Storage
+
+	mu    sync.Mutex
+	batch *attachmentBatch
+}
+
+type attachmentBatch struct {
+	ids   []string
+	timer *time.Timer
+	done  chan struct{}
+	res   map[string][]*models.Attachment
+	err   error
+}
+
+func (l *attachmentLoader) Load(ctx context.Context, commentID string) ([]*models.Attachment, error) {
+	l.mu.Lock()
+	b := l.batch
+	if b == nil {
+		b = &attachmentBatch{done: make(chan struct{})}
+		b.timer = time.AfterFunc(attachmentBatchWait, func() { l.run(ctx, b) })
+		l.batch = b
+	}
+	b.ids = append(b.ids, commentID)
+	if len(b.ids) == maxAttachmentBatch && b.timer.Stop() {
+		go l.run(ctx, b)
+	}
+	l.mu.Unlock()
+
+	select {
+	case <-b.done:
+		return b.res[commentID], b.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *attachmentLoader) run(ctx context.Context, b *attachmentBatch) {
+	l.mu.Lock()
+	if l.batch == b {
+		l.batch = nil
+	}
+	ids := b.ids
+	l.mu.Unlock()
+
+	b.res, b.err = l.store.ListAttachmentsFor(ctx, ids)
+	close(b.done)
+}
+
+// listAttachments loads the attachments of a comment through the response's
+// loader if there is one.
+func (r *Resolver) listAttachments(ctx context.Context, commentID string) ([]*models.Attachment, error) {
+	if l, ok := ctx.Value(loadersKey{}).(*attachmentLoader); ok {
+		return l.Load(ctx, commentID)
+	}
+	return r.Store.ListAttachments(ctx, commentID)
+}
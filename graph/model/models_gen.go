@@ -3,15 +3,37 @@
 package model
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
 	"time"
 )
 
+type Attachment struct {
+	ID string `json:"id"`
+	// The uploaded file name, without any directories.
+	Filename string `json:"filename"`
+	// Detected from the file contents.
+	ContentType string `json:"contentType"`
+	// Size in bytes.
+	Size int32  `json:"size"`
+	URL  string `json:"url"`
+	// A preview at most 256 pixels on each side, for JPEG, PNG and GIF images.
+	ThumbnailURL *string   `json:"thumbnailURL,omitempty"`
+	Width        *int32    `json:"width,omitempty"`
+	Height       *int32    `json:"height,omitempty"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
 type Comment struct {
 	ID        string    `json:"id"`
 	PostID    string    `json:"postID"`
 	ParentID  *string   `json:"parentID,omitempty"`
 	Content   string    `json:"content"`
 	CreatedAt time.Time `json:"createdAt"`
+	// Username of the author; null for anonymous comments.
+	Author *string `json:"author,omitempty"`
 }
 
 type CommentPage struct {
@@ -22,12 +44,55 @@ type CommentPage struct {
 type Mutation struct {
 }
 
+type Notification struct {
+	ID        string           `json:"id"`
+	Type      NotificationType `json:"type"`
+	User      string           `json:"user"`
+	PostID    string           `json:"postID"`
+	CommentID string           `json:"commentID"`
+	// Author of the comment.
+	Actor     *string   `json:"actor,omitempty"`
+	Read      bool      `json:"read"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type NotificationPage struct {
+	Items      []*Notification `json:"items"`
+	NextCursor *string         `json:"nextCursor,omitempty"`
+}
+
 type Post struct {
-	ID               string    `json:"id"`
-	Title            string    `json:"title"`
-	Content          string    `json:"content"`
-	CommentsDisabled bool      `json:"commentsDisabled"`
-	CreatedAt        time.Time `json:"createdAt"`
+	ID               string     `json:"id"`
+	Title            string     `json:"title"`
+	Content          string     `json:"content"`
+	CommentsDisabled bool       `json:"commentsDisabled"`
+	CreatedAt        time.Time  `json:"createdAt"`
+	CommentCount     int32      `json:"commentCount"`
+	LastCommentAt    *time.Time `json:"lastCommentAt,omitempty"`
+}
+
+type PostActivity struct {
+	PostID string `json:"postID"`
+	// Open commentAdded subscriptions on the post, across all threads.
+	Viewers      int32 `json:"viewers"`
+	CommentCount int32 `json:"commentCount"`
+}
+
+type PostEvent struct {
+	Type PostEventType `json:"type"`
+	Post *Post         `json:"post"`
+}
+
+type PostFilter struct {
+	CommentsDisabled *bool      `json:"commentsDisabled,omitempty"`
+	CreatedAfter     *time.Time `json:"createdAfter,omitempty"`
+	CreatedBefore    *time.Time `json:"createdBefore,omitempty"`
+	TitleContains    *string    `json:"titleContains,omitempty"`
+}
+
+type PostPage struct {
+	Items      []*Post `json:"items"`
+	NextCursor *string `json:"nextCursor,omitempty"`
 }
 
 type Query struct {
@@ -35,3 +100,326 @@ type Query struct {
 
 type Subscription struct {
 }
+
+type TypingIndicator struct {
+	User string `json:"user"`
+	// The comment being replied to, null for a top-level comment.
+	ParentID *string `json:"parentID,omitempty"`
+}
+
+type Webhook struct {
+	ID        string         `json:"id"`
+	URL       string         `json:"url"`
+	Events    []WebhookEvent `json:"events"`
+	CreatedAt time.Time      `json:"createdAt"`
+}
+
+type WebhookDelivery struct {
+	ID        string `json:"id"`
+	WebhookID string `json:"webhookID"`
+	// ID of the event, also sent as X-Webhook-ID; the same across retries.
+	EventID string       `json:"eventID"`
+	Event   WebhookEvent `json:"event"`
+	// The signed JSON body.
+	Payload  string                `json:"payload"`
+	Status   WebhookDeliveryStatus `json:"status"`
+	Attempts int32                 `json:"attempts"`
+	// HTTP status of the last response, if there was one.
+	ResponseStatus *int32 `json:"responseStatus,omitempty"`
+	// Why the last attempt failed. Response bodies are never recorded.
+	LastError     *string    `json:"lastError,omitempty"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	NextAttemptAt *time.Time `json:"nextAttemptAt,omitempty"`
+	DeliveredAt   *time.Time `json:"deliveredAt,omitempty"`
+}
+
+type NotificationType string
+
+const (
+	// The comment @mentions the user.
+	NotificationTypeMention NotificationType = "MENTION"
+	// The comment replies to one of the user's comments.
+	NotificationTypeReply NotificationType = "REPLY"
+)
+
+var AllNotificationType = []NotificationType{
+	NotificationTypeMention,
+	NotificationTypeReply,
+}
+
+func (e NotificationType) IsValid() bool {
+	switch e {
+	case NotificationTypeMention, NotificationTypeReply:
+		return true
+	}
+	return false
+}
+
+func (e NotificationType) String() string {
+	return string(e)
+}
+
+func (e *NotificationType) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = NotificationType(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid NotificationType", str)
+	}
+	return nil
+}
+
+func (e NotificationType) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *NotificationType) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e NotificationType) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type PostEventType string
+
+const (
+	PostEventTypeUpdated PostEventType = "UPDATED"
+	PostEventTypeDeleted PostEventType = "DELETED"
+)
+
+var AllPostEventType = []PostEventType{
+	PostEventTypeUpdated,
+	PostEventTypeDeleted,
+}
+
+func (e PostEventType) IsValid() bool {
+	switch e {
+	case PostEventTypeUpdated, PostEventTypeDeleted:
+		return true
+	}
+	return false
+}
+
+func (e PostEventType) String() string {
+	return string(e)
+}
+
+func (e *PostEventType) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = PostEventType(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid PostEventType", str)
+	}
+	return nil
+}
+
+func (e PostEventType) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *PostEventType) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e PostEventType) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type PostOrderBy string
+
+const (
+	PostOrderByCreatedAtDesc PostOrderBy = "CREATED_AT_DESC"
+	PostOrderByCreatedAtAsc  PostOrderBy = "CREATED_AT_ASC"
+)
+
+var AllPostOrderBy = []PostOrderBy{
+	PostOrderByCreatedAtDesc,
+	PostOrderByCreatedAtAsc,
+}
+
+func (e PostOrderBy) IsValid() bool {
+	switch e {
+	case PostOrderByCreatedAtDesc, PostOrderByCreatedAtAsc:
+		return true
+	}
+	return false
+}
+
+func (e PostOrderBy) String() string {
+	return string(e)
+}
+
+func (e *PostOrderBy) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = PostOrderBy(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid PostOrderBy", str)
+	}
+	return nil
+}
+
+func (e PostOrderBy) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *PostOrderBy) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e PostOrderBy) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryStatusPending   WebhookDeliveryStatus = "PENDING"
+	WebhookDeliveryStatusDelivered WebhookDeliveryStatus = "DELIVERED"
+	// Ran out of attempts; the dead-letter list.
+	WebhookDeliveryStatusDead WebhookDeliveryStatus = "DEAD"
+)
+
+var AllWebhookDeliveryStatus = []WebhookDeliveryStatus{
+	WebhookDeliveryStatusPending,
+	WebhookDeliveryStatusDelivered,
+	WebhookDeliveryStatusDead,
+}
+
+func (e WebhookDeliveryStatus) IsValid() bool {
+	switch e {
+	case WebhookDeliveryStatusPending, WebhookDeliveryStatusDelivered, WebhookDeliveryStatusDead:
+		return true
+	}
+	return false
+}
+
+func (e WebhookDeliveryStatus) String() string {
+	return string(e)
+}
+
+func (e *WebhookDeliveryStatus) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = WebhookDeliveryStatus(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid WebhookDeliveryStatus", str)
+	}
+	return nil
+}
+
+func (e WebhookDeliveryStatus) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *WebhookDeliveryStatus) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e WebhookDeliveryStatus) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type WebhookEvent string
+
+const (
+	// A comment was created.
+	WebhookEventCommentCreated WebhookEvent = "COMMENT_CREATED"
+	// A comment was removed along with its post by a hard deletePost.
+	WebhookEventCommentDeleted WebhookEvent = "COMMENT_DELETED"
+	// Comments on a post were enabled or disabled.
+	WebhookEventPostToggled WebhookEvent = "POST_TOGGLED"
+	// The title or content of a post changed.
+	WebhookEventPostUpdated WebhookEvent = "POST_UPDATED"
+	// A post was deleted, softly or for good.
+	WebhookEventPostDeleted WebhookEvent = "POST_DELETED"
+)
+
+var AllWebhookEvent = []WebhookEvent{
+	WebhookEventCommentCreated,
+	WebhookEventCommentDeleted,
+	WebhookEventPostToggled,
+	WebhookEventPostUpdated,
+	WebhookEventPostDeleted,
+}
+
+func (e WebhookEvent) IsValid() bool {
+	switch e {
+	case WebhookEventCommentCreated, WebhookEventCommentDeleted, WebhookEventPostToggled, WebhookEventPostUpdated, WebhookEventPostDeleted:
+		return true
+	}
+	return false
+}
+
+func (e WebhookEvent) String() string {
+	return string(e)
+}
+
+func (e *WebhookEvent) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = WebhookEvent(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid WebhookEvent", str)
+	}
+	return nil
+}
+
+func (e WebhookEvent) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *WebhookEvent) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e WebhookEvent) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,290 @@
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"ozon-comments-graphql/graph/model"
+	"ozon-comments-graphql/internal/models"
+	"ozon-comments-graphql/internal/notify"
+	"ozon-comments-graphql/internal/outbox"
+	"ozon-comments-graphql/internal/storage"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/google/uuid"
+)
+
+// createComment stores a comment together with the notifications it causes
+// and, when an outbox relay is configured, their events, all in one
+// transaction. Without a relay the events are published directly once the
+// transaction has committed. Attachment blobs are written beforehand and
+// removed again if the transaction fails.
+func (r *Resolver) createComment(ctx context.Context, postID string, parentID *string, content string, author *string, uploads []*graphql.Upload) (*model.Comment, error) {
+	if author != nil && !notify.ValidUsername(*author) {
+		return nil, ErrInvalidUsername
+	}
+	files, err := r.readAttachments(ctx, uploads)
+	if err != nil {
+		return nil, err
+	}
+	attachments, err := r.putAttachments(ctx, files)
+	if err != nil {
+		return nil, err
+	}
+
+	var comment *models.Comment
+	var notifications []*models.Notification
+	err = r.Store.WithTx(ctx, func(ctx context.Context) error {
+		c, err := r.Store.CreateComment(ctx, postID, parentID, author, content)
+		if err != nil {
+			return err
+		}
+		comment = c
+		for _, a := range attachments {
+			a.CommentID = c.ID
+			if err := r.Store.AddAttachment(ctx, a); err != nil {
+				return err
+			}
+		}
+		notifications, err = r.addNotifications(ctx, c)
+		if err != nil || r.Outbox == nil {
+			return err
+		}
+
+		if err := r.addOutboxEvent(ctx, outbox.TopicCommentCreated, toModelComment(c)); err != nil {
+			return err
+		}
+		for _, n := range notifications {
+			if err := r.addOutboxEvent(ctx, outbox.TopicNotificationCreated, toModelNotification(n)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		r.deleteAttachments(ctx, attachments)
+		return nil, err
+	}
+
+	if r.Outbox != nil {
+		r.Outbox.Notify()
+	} else {
+		r.Broker.Publish(ctx, toModelComment(comment))
+		for _, n := range notifications {
+			r.Broker.PublishNotification(ctx, toModelNotification(n))
+		}
+	}
+	return toModelComment(comment), nil
+}
+
+// addNotifications stores the notifications caused by c, whose parent
+// CreateComment has already checked.
+func (r *Resolver) addNotifications(ctx context.Context, c *models.Comment) ([]*models.Notification, error) {
+	var parent *models.Comment
+	if c.ParentID != nil {
+		p, err := r.Store.GetComment(ctx, *c.ParentID)
+		if err != nil {
+			return nil, err
+		}
+		parent = p
+	}
+
+	notifications := notify.For(c, parent)
+	for _, n := range notifications {
+		if err := r.Store.AddNotification(ctx, n); err != nil {
+			return nil, err
+		}
+	}
+	return notifications, nil
+}
+
+// addOutboxEvent writes v as JSON to the outbox under topic. ctx must belong
+// to the transaction of the change v describes.
+func (r *Resolver) addOutboxEvent(ctx context.Context, topic string, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return r.Store.AddOutboxEvent(ctx, &storage.OutboxEvent{
+		ID:        uuid.NewString(),
+		Topic:     topic,
+		Payload:   payload,
+		CreatedAt: time.Now(),
+	})
+}
+
+// createPost stores a post and announces it to postAdded subscribers,
+// through the outbox when a relay is configured.
+func (r *Resolver) createPost(ctx context.Context, title, content string) (*model.Post, error) {
+	if r.Outbox == nil {
+		res := toModelPost(r.Store.CreatePost(ctx, title, content))
+		r.Broker.PublishPostAdded(ctx, res)
+		return res, nil
+	}
+
+	var post *models.Post
+	err := r.Store.WithTx(ctx, func(ctx context.Context) error {
+		post = r.Store.CreatePost(ctx, title, content)
+		return r.addOutboxEvent(ctx, outbox.TopicPostCreated, toModelPost(post))
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.Outbox.Notify()
+	return toModelPost(post), nil
+}
+
+// updatePost edits a post and announces it as an UPDATED postChanged event
+// and, when an outbox relay is configured, as a post.updated event recorded
+// in the same transaction.
+func (r *Resolver) updatePost(ctx context.Context, id string, title, content *string) (*model.Post, error) {
+	var post *models.Post
+	err := r.Store.WithTx(ctx, func(ctx context.Context) error {
+		p, err := r.Store.UpdatePost(ctx, id, title, content)
+		if err != nil {
+			return err
+		}
+		post = p
+		if r.Outbox == nil {
+			return nil
+		}
+		return r.addOutboxEvent(ctx, outbox.TopicPostUpdated, toModelPost(p))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := toModelPost(post)
+	if r.Outbox != nil {
+		r.Outbox.Notify()
+	} else {
+		r.Broker.PublishPost(ctx, &model.PostEvent{Type: model.PostEventTypeUpdated, Post: res})
+	}
+	return res, nil
+}
+
+// deletePost deletes a post and announces it as a DELETED postChanged event.
+// With an outbox relay configured, the same transaction records a
+// post.deleted event and, for a hard delete, a comment.deleted event for
+// every comment removed with the post. A hard delete also removes the
+// attachment blobs once the transaction has committed.
+func (r *Resolver) deletePost(ctx context.Context, id string, soft bool) (*model.Post, error) {
+	var post *models.Post
+	var comments []*models.Comment
+	var attachments []*models.Attachment
+	err := r.Store.WithTx(ctx, func(ctx context.Context) error {
+		var err error
+		if !soft && r.Outbox != nil {
+			if comments, err = r.Store.ListPostComments(ctx, id); err != nil {
+				return err
+			}
+		}
+		if !soft && r.Blobs != nil {
+			if attachments, err = r.Store.ListPostAttachments(ctx, id); err != nil {
+				return err
+			}
+		}
+		if post, err = r.Store.DeletePost(ctx, id, soft); err != nil || r.Outbox == nil {
+			return err
+		}
+
+		for _, c := range comments {
+			if err := r.addOutboxEvent(ctx, outbox.TopicCommentDeleted, toModelComment(c)); err != nil {
+				return err
+			}
+		}
+		return r.addOutboxEvent(ctx, outbox.TopicPostDeleted, toModelPost(post))
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.deleteAttachments(ctx, attachments)
+
+	res := toModelPost(post)
+	if r.Outbox != nil {
+		r.Outbox.Notify()
+	} else {
+		r.Broker.PublishPost(ctx, &model.PostEvent{Type: model.PostEventTypeDeleted, Post: res})
+	}
+	return res, nil
+}
+
+// toggleComments changes whether a post accepts comments. Only an actual
+// change is announced: as an UPDATED postChanged event and, when an outbox
+// relay is configured, as a post.toggled event recorded in the same
+// transaction.
+func (r *Resolver) toggleComments(ctx context.Context, postID string, disabled bool) (*model.Post, error) {
+	var post *models.Post
+	changed := false
+	err := r.Store.WithTx(ctx, func(ctx context.Context) error {
+		p, ok, err := r.Store.ToggleComments(ctx, postID, disabled)
+		if err != nil {
+			return err
+		}
+		post, changed = p, ok
+		if !changed || r.Outbox == nil {
+			return nil
+		}
+		return r.addOutboxEvent(ctx, outbox.TopicPostToggled, toModelPost(p))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := toModelPost(post)
+	switch {
+	case !changed:
+	case r.Outbox != nil:
+		r.Outbox.Notify()
+	default:
+		r.Broker.PublishPost(ctx, &model.PostEvent{Type: model.PostEventTypeUpdated, Post: res})
+	}
+	return res, nil
+}
+
+// HandleOutboxEvent publishes a relayed event to subscribers. Redeliveries of
+// an event this broker has recently published are dropped, but the record is
+// in memory only: after a restart, or on another replica, a redelivered
+// event is published again, so subscribers must be idempotent on the ID of
+// the comment or post. Topics without subscriptions are ignored. It is an
+// outbox.Handler.
+func (b *Broker) HandleOutboxEvent(ctx context.Context, e *storage.OutboxEvent) error {
+	if b.seen.Contains(e.ID) {
+		return nil
+	}
+
+	switch e.Topic {
+	case outbox.TopicCommentCreated:
+		var comment model.Comment
+		if err := json.Unmarshal(e.Payload, &comment); err != nil {
+			slog.ErrorContext(ctx, "invalid outbox event dropped", "event_id", e.ID, "topic", e.Topic, "err", err)
+			return nil
+		}
+		b.Publish(ctx, &comment)
+	case outbox.TopicNotificationCreated:
+		var n model.Notification
+		if err := json.Unmarshal(e.Payload, &n); err != nil {
+			slog.ErrorContext(ctx, "invalid outbox event dropped", "event_id", e.ID, "topic", e.Topic, "err", err)
+			return nil
+		}
+		b.PublishNotification(ctx, &n)
+	case outbox.TopicPostCreated, outbox.TopicPostUpdated, outbox.TopicPostToggled, outbox.TopicPostDeleted:
+		var post model.Post
+		if err := json.Unmarshal(e.Payload, &post); err != nil {
+			slog.ErrorContext(ctx, "invalid outbox event dropped", "event_id", e.ID, "topic", e.Topic, "err", err)
+			return nil
+		}
+		switch e.Topic {
+		case outbox.TopicPostCreated:
+			b.PublishPostAdded(ctx, &post)
+		case outbox.TopicPostDeleted:
+			b.PublishPost(ctx, &model.PostEvent{Type: model.PostEventTypeDeleted, Post: &post})
+		default:
+			b.PublishPost(ctx, &model.PostEvent{Type: model.PostEventTypeUpdated, Post: &post})
+		}
+	}
+
+	b.seen.Add(e.ID, struct{}{})
+	return nil
+}
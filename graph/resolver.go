@@ -1,10 +1,137 @@
 package graph
 
 import (
+	"errors"
+	"ozon-comments-graphql/graph/model"
+	"ozon-comments-graphql/internal/blob"
+	"ozon-comments-graphql/internal/limits"
+	"ozon-comments-graphql/internal/models"
+	"ozon-comments-graphql/internal/outbox"
 	"ozon-comments-graphql/internal/storage"
 )
 
+const (
+	defaultPageSize = 10
+	maxPageSize     = 100
+)
+
+var (
+	ErrInvalidUsername = errors.New("invalid username: want 1 to 32 letters, digits or underscores")
+	ErrTypingThrottled = errors.New("startTyping called too often, try again later")
+)
+
 type Resolver struct {
 	Store  storage.Storage
-	Broker *CommentBroker
+	Broker *Broker
+	// Outbox, when set, makes createComment and the post mutations write
+	// their events to the outbox in the same transaction; the relay then
+	// publishes them to subscribers and webhooks. Webhooks get nothing
+	// without it.
+	Outbox *outbox.Relay
+	// WebhookAllowPrivate lets registerWebhook accept URLs resolving to
+	// loopback and private addresses.
+	WebhookAllowPrivate bool
+	// Blobs keeps the files attached to comments; without it createComment
+	// rejects attachments. MaxAttachments and MaxAttachmentSize bound them
+	// per comment and per file; zero values fall back to the package
+	// defaults.
+	Blobs             blob.Store
+	MaxAttachments    int
+	MaxAttachmentSize int64
+	// TypingThrottle bounds startTyping calls per client address; nil
+	// leaves them unbounded.
+	TypingThrottle *limits.Throttle
+
+	// DefaultPageSize and MaxPageSize bound the first argument of paginated
+	// fields; zero values fall back to the package defaults.
+	DefaultPageSize int
+	MaxPageSize     int
+}
+
+func toModelPost(p *models.Post) *model.Post {
+	return &model.Post{
+		ID:               p.ID,
+		Title:            p.Title,
+		Content:          p.Content,
+		CommentsDisabled: p.CommentsDisabled,
+		CreatedAt:        p.CreatedAt,
+		CommentCount:     int32(p.CommentCount),
+		LastCommentAt:    p.LastCommentAt,
+	}
+}
+
+func toModelComment(c *models.Comment) *model.Comment {
+	return &model.Comment{
+		ID:        c.ID,
+		PostID:    c.PostID,
+		ParentID:  c.ParentID,
+		Content:   c.Content,
+		CreatedAt: c.CreatedAt,
+		Author:    c.Author,
+	}
+}
+
+func toModelNotification(n *models.Notification) *model.Notification {
+	return &model.Notification{
+		ID:        n.ID,
+		Type:      model.NotificationType(n.Type),
+		User:      n.User,
+		PostID:    n.PostID,
+		CommentID: n.CommentID,
+		Actor:     n.Actor,
+		Read:      n.ReadAt != nil,
+		CreatedAt: n.CreatedAt,
+	}
+}
+
+func toModelWebhook(w *models.Webhook) *model.Webhook {
+	events := make([]model.WebhookEvent, len(w.Events))
+	for i, e := range w.Events {
+		events[i] = model.WebhookEvent(e)
+	}
+	return &model.Webhook{
+		ID:        w.ID,
+		URL:       w.URL,
+		Events:    events,
+		CreatedAt: w.CreatedAt,
+	}
+}
+
+func toModelWebhookDelivery(d *models.WebhookDelivery) *model.WebhookDelivery {
+	res := &model.WebhookDelivery{
+		ID:            d.ID,
+		WebhookID:     d.WebhookID,
+		EventID:       d.EventID,
+		Event:         model.WebhookEvent(d.Event),
+		Payload:       string(d.Payload),
+		Status:        model.WebhookDeliveryStatus(d.Status),
+		Attempts:      int32(d.Attempts),
+		LastError:     d.LastError,
+		CreatedAt:     d.CreatedAt,
+		NextAttemptAt: d.NextAttemptAt,
+		DeliveredAt:   d.DeliveredAt,
+	}
+	if d.ResponseStatus != nil {
+		status := int32(*d.ResponseStatus)
+		res.ResponseStatus = &status
+	}
+	return res
+}
+
+func (r *Resolver) pageSize(first *int32) int {
+	def, max := r.DefaultPageSize, r.MaxPageSize
+	if def <= 0 {
+		def = defaultPageSize
+	}
+	if max <= 0 {
+		max = maxPageSize
+	}
+
+	if first == nil || *first <= 0 {
+		return def
+	}
+	if int(*first) > max {
+		return max
+	}
+	return int(*first)
 }
@@ -7,75 +7,176 @@ package graph
 import (
 	"context"
 	"ozon-comments-graphql/graph/model"
+	"ozon-comments-graphql/internal/admin"
+	"ozon-comments-graphql/internal/limits"
+	"ozon-comments-graphql/internal/notify"
+	"ozon-comments-graphql/internal/storage"
+	"ozon-comments-graphql/internal/webhook"
+
+	"github.com/99designs/gqlgen/graphql"
 )
 
+// Attachments is the resolver for the attachments field.
+func (r *commentResolver) Attachments(ctx context.Context, obj *model.Comment) ([]*model.Attachment, error) {
+	if r.Blobs == nil {
+		return []*model.Attachment{}, nil
+	}
+	attachments, err := r.listAttachments(ctx, obj.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*model.Attachment, len(attachments))
+	for i, a := range attachments {
+		res[i] = r.toModelAttachment(a)
+	}
+	return res, nil
+}
+
 // CreatePost is the resolver for the createPost field.
 func (r *mutationResolver) CreatePost(ctx context.Context, title string, content string) (*model.Post, error) {
-	p := r.Store.CreatePost(ctx, title, content)
+	return r.createPost(ctx, title, content)
+}
 
-	res := &model.Post{
-		ID:               p.ID,
-		Title:            p.Title,
-		Content:          p.Content,
-		CommentsDisabled: p.CommentsDisabled,
-		CreatedAt:        p.CreatedAt,
-	}
+// UpdatePost is the resolver for the updatePost field.
+func (r *mutationResolver) UpdatePost(ctx context.Context, id string, title *string, content *string) (*model.Post, error) {
+	return r.updatePost(ctx, id, title, content)
+}
 
-	return res, nil
+// DeletePost is the resolver for the deletePost field.
+func (r *mutationResolver) DeletePost(ctx context.Context, id string, soft *bool) (*model.Post, error) {
+	return r.deletePost(ctx, id, soft != nil && *soft)
 }
 
 // ToggleComments is the resolver for the toggleComments field.
 func (r *mutationResolver) ToggleComments(ctx context.Context, postID string, disabled bool) (*model.Post, error) {
-	p, err := r.Store.ToggleComments(ctx, postID, disabled)
+	return r.toggleComments(ctx, postID, disabled)
+}
+
+// CreateComment is the resolver for the createComment field.
+func (r *mutationResolver) CreateComment(ctx context.Context, postID string, parentID *string, content string, author *string, attachments []*graphql.Upload) (*model.Comment, error) {
+	return r.createComment(ctx, postID, parentID, content, author, attachments)
+}
+
+// StartTyping is the resolver for the startTyping field.
+func (r *mutationResolver) StartTyping(ctx context.Context, postID string, parentID *string, user string) (bool, error) {
+	if !r.TypingThrottle.Allow(limits.RemoteAddr(ctx)) {
+		return false, ErrTypingThrottled
+	}
+	if !notify.ValidUsername(user) {
+		return false, ErrInvalidUsername
+	}
+	post, err := r.Store.GetPost(ctx, postID)
 	if err != nil {
-		return nil, err
+		return false, err
+	}
+	if post.CommentsDisabled {
+		return false, storage.ErrForbidden
+	}
+	if parentID != nil {
+		parent, err := r.Store.GetComment(ctx, *parentID)
+		if err != nil {
+			return false, err
+		}
+		if parent.PostID != postID {
+			return false, storage.ErrWrongPost
+		}
 	}
 
-	return &model.Post{
-		ID:               p.ID,
-		Title:            p.Title,
-		Content:          p.Content,
-		CommentsDisabled: p.CommentsDisabled,
-		CreatedAt:        p.CreatedAt,
-	}, nil
+	// Without a known connection, as for in-process callers, the name is
+	// all there is to tell clients apart.
+	client := limits.Client(ctx)
+	if client == "" {
+		client = "user:" + user
+	}
+	r.Broker.StartTyping(ctx, postID, parentID, client, user)
+	return true, nil
 }
 
-// CreateComment is the resolver for the createComment field.
-func (r *mutationResolver) CreateComment(ctx context.Context, postID string, parentID *string, content string) (*model.Comment, error) {
-	comment, err := r.Store.CreateComment(ctx, postID, parentID, content)
+// MarkNotificationsRead is the resolver for the markNotificationsRead field.
+func (r *mutationResolver) MarkNotificationsRead(ctx context.Context, user string, ids []string) (int32, error) {
+	n, err := r.Store.MarkNotificationsRead(ctx, user, ids)
 	if err != nil {
+		return 0, err
+	}
+
+	return int32(n), nil
+}
+
+// RegisterWebhook is the resolver for the registerWebhook field.
+func (r *mutationResolver) RegisterWebhook(ctx context.Context, url string, events []model.WebhookEvent, secret string) (*model.Webhook, error) {
+	if err := admin.Require(ctx); err != nil {
+		return nil, err
+	}
+	names := make([]string, len(events))
+	for i, e := range events {
+		names[i] = e.String()
+	}
+	hook, err := webhook.New(url, names, secret)
+	if err != nil {
+		return nil, err
+	}
+	if !r.WebhookAllowPrivate {
+		if err := webhook.CheckURL(ctx, hook.URL); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.Store.CreateWebhook(ctx, hook); err != nil {
 		return nil, err
 	}
 
-	modelComment := &model.Comment{
-		ID:        comment.ID,
-		PostID:    comment.PostID,
-		ParentID:  comment.ParentID,
-		Content:   comment.Content,
-		CreatedAt: comment.CreatedAt,
+	return toModelWebhook(hook), nil
+}
+
+// Comment is the resolver for the comment field.
+func (r *notificationResolver) Comment(ctx context.Context, obj *model.Notification) (*model.Comment, error) {
+	c, err := r.Store.GetComment(ctx, obj.CommentID)
+	if err != nil {
+		return nil, err
 	}
 
-	r.Broker.Publish(modelComment)
+	return toModelComment(c), nil
+}
 
-	return modelComment, nil
+// Comments is the resolver for the comments field.
+func (r *postResolver) Comments(ctx context.Context, obj *model.Post, first *int32, after *string) (*model.CommentPage, error) {
+	return r.Query().Comments(ctx, obj.ID, first, after)
 }
 
 // Posts is the resolver for the posts field.
-func (r *queryResolver) Posts(ctx context.Context) ([]*model.Post, error) {
-	posts := r.Store.ListPosts(ctx)
-	var res []*model.Post
+func (r *queryResolver) Posts(ctx context.Context, first *int32, after *string, filter *model.PostFilter, orderBy *model.PostOrderBy) (*model.PostPage, error) {
+	opts := storage.ListPostsOptions{
+		First: r.pageSize(first),
+		After: after,
+	}
+	if orderBy != nil && *orderBy == model.PostOrderByCreatedAtAsc {
+		opts.OrderBy = storage.OrderCreatedAtAsc
+	}
+	if filter != nil {
+		opts.Filter = storage.PostFilter{
+			CommentsDisabled: filter.CommentsDisabled,
+			CreatedAfter:     filter.CreatedAfter,
+			CreatedBefore:    filter.CreatedBefore,
+		}
+		if filter.TitleContains != nil {
+			opts.Filter.TitleContains = *filter.TitleContains
+		}
+	}
 
-	for _, post := range posts {
-		res = append(res, &model.Post{
-			ID:               post.ID,
-			Title:            post.Title,
-			Content:          post.Content,
-			CommentsDisabled: post.CommentsDisabled,
-			CreatedAt:        post.CreatedAt,
-		})
+	posts, next, err := r.Store.ListPosts(ctx, opts)
+	if err != nil {
+		return nil, err
 	}
 
-	return res, nil
+	items := make([]*model.Post, len(posts))
+	for i, post := range posts {
+		items[i] = toModelPost(post)
+	}
+
+	return &model.PostPage{
+		Items:      items,
+		NextCursor: next,
+	}, nil
 }
 
 // Post is the resolver for the post field.
@@ -85,33 +186,19 @@ func (r *queryResolver) Post(ctx context.Context, id string) (*model.Post, error
 		return nil, err
 	}
 
-	return &model.Post{
-		ID:               p.ID,
-		Title:            p.Title,
-		Content:          p.Content,
-		CommentsDisabled: p.CommentsDisabled,
-		CreatedAt:        p.CreatedAt,
-	}, nil
+	return toModelPost(p), nil
 }
 
 // Comments is the resolver for the comments field.
 func (r *queryResolver) Comments(ctx context.Context, postID string, first *int32, after *string) (*model.CommentPage, error) {
-	limit := 10
-	if first != nil && *first > 0 {
-		limit = int(*first)
+	rawComments, next, err := r.Store.ListComments(ctx, postID, r.pageSize(first), after)
+	if err != nil {
+		return nil, err
 	}
 
-	rawComments, next := r.Store.ListComments(ctx, postID, limit, after)
-
 	items := make([]*model.Comment, len(rawComments))
 	for i, c := range rawComments {
-		items[i] = &model.Comment{
-			ID:        c.ID,
-			PostID:    c.PostID,
-			ParentID:  c.ParentID,
-			Content:   c.Content,
-			CreatedAt: c.CreatedAt,
-		}
+		items[i] = toModelComment(c)
 	}
 
 	return &model.CommentPage{
@@ -120,9 +207,80 @@ func (r *queryResolver) Comments(ctx context.Context, postID string, first *int3
 	}, nil
 }
 
+// Notifications is the resolver for the notifications field.
+func (r *queryResolver) Notifications(ctx context.Context, user string, first *int32, after *string, unreadOnly *bool) (*model.NotificationPage, error) {
+	notifications, next, err := r.Store.ListNotifications(ctx, user, storage.ListNotificationsOptions{
+		First:      r.pageSize(first),
+		After:      after,
+		UnreadOnly: unreadOnly != nil && *unreadOnly,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*model.Notification, len(notifications))
+	for i, n := range notifications {
+		items[i] = toModelNotification(n)
+	}
+
+	return &model.NotificationPage{
+		Items:      items,
+		NextCursor: next,
+	}, nil
+}
+
+// Webhooks is the resolver for the webhooks field.
+func (r *queryResolver) Webhooks(ctx context.Context) ([]*model.Webhook, error) {
+	if err := admin.Require(ctx); err != nil {
+		return nil, err
+	}
+	hooks, err := r.Store.ListWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*model.Webhook, len(hooks))
+	for i, hook := range hooks {
+		items[i] = toModelWebhook(hook)
+	}
+	return items, nil
+}
+
+// WebhookDeliveries is the resolver for the webhookDeliveries field.
+func (r *queryResolver) WebhookDeliveries(ctx context.Context, webhookID *string, status *model.WebhookDeliveryStatus, first *int32) ([]*model.WebhookDelivery, error) {
+	if err := admin.Require(ctx); err != nil {
+		return nil, err
+	}
+	filter := storage.WebhookDeliveryFilter{WebhookID: webhookID}
+	if status != nil {
+		s := status.String()
+		filter.Status = &s
+	}
+
+	deliveries, err := r.Store.ListWebhookDeliveries(ctx, filter, r.pageSize(first))
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*model.WebhookDelivery, len(deliveries))
+	for i, d := range deliveries {
+		items[i] = toModelWebhookDelivery(d)
+	}
+	return items, nil
+}
+
 // CommentAdded is the resolver for the commentAdded field.
-func (r *subscriptionResolver) CommentAdded(ctx context.Context, postID string) (<-chan *model.Comment, error) {
-	ch := r.Broker.Subscribe(postID)
+func (r *subscriptionResolver) CommentAdded(ctx context.Context, postID string, parentID *string, includeDescendants *bool) (<-chan *model.Comment, error) {
+	if parentID != nil {
+		parent, err := r.Store.GetComment(ctx, *parentID)
+		if err != nil {
+			return nil, err
+		}
+		if parent.PostID != postID {
+			return nil, storage.ErrNotFound
+		}
+	}
+	ch := r.Broker.SubscribeThread(postID, parentID, includeDescendants != nil && *includeDescendants)
 
 	go func() {
 		<-ctx.Done()
@@ -132,15 +290,121 @@ func (r *subscriptionResolver) CommentAdded(ctx context.Context, postID string)
 	return ch, nil
 }
 
+// PostAdded is the resolver for the postAdded field.
+func (r *subscriptionResolver) PostAdded(ctx context.Context, filter *model.PostFilter) (<-chan *model.Post, error) {
+	ch := r.Broker.SubscribePostAdded(filter)
+
+	go func() {
+		<-ctx.Done()
+		r.Broker.UnsubscribePostAdded(ch)
+	}()
+
+	return ch, nil
+}
+
+// PostChanged is the resolver for the postChanged field.
+func (r *subscriptionResolver) PostChanged(ctx context.Context, postID string) (<-chan *model.PostEvent, error) {
+	ch := r.Broker.SubscribePost(postID)
+
+	go func() {
+		<-ctx.Done()
+		r.Broker.UnsubscribePost(postID, ch)
+	}()
+
+	return ch, nil
+}
+
+// PostUpdated is the resolver for the postUpdated field.
+func (r *subscriptionResolver) PostUpdated(ctx context.Context, postID string) (<-chan *model.Post, error) {
+	events := r.Broker.SubscribePost(postID)
+	ch := make(chan *model.Post, 1)
+
+	go func() {
+		defer close(ch)
+		defer r.Broker.UnsubscribePost(postID, events)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-events:
+				if !ok {
+					return
+				}
+				if e.Type != model.PostEventTypeUpdated {
+					continue
+				}
+				select {
+				case ch <- e.Post:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// NotificationAdded is the resolver for the notificationAdded field.
+func (r *subscriptionResolver) NotificationAdded(ctx context.Context, user string) (<-chan *model.Notification, error) {
+	ch := r.Broker.SubscribeNotifications(user)
+
+	go func() {
+		<-ctx.Done()
+		r.Broker.UnsubscribeNotifications(user, ch)
+	}()
+
+	return ch, nil
+}
+
+// PostActivity is the resolver for the postActivity field.
+func (r *subscriptionResolver) PostActivity(ctx context.Context, postID string) (<-chan *model.PostActivity, error) {
+	if _, err := r.Store.GetPost(ctx, postID); err != nil {
+		return nil, err
+	}
+	ch := r.Broker.SubscribeActivity(postID)
+
+	go func() {
+		<-ctx.Done()
+		r.Broker.UnsubscribeActivity(postID, ch)
+	}()
+
+	return ch, nil
+}
+
+// TypingIndicators is the resolver for the typingIndicators field.
+func (r *subscriptionResolver) TypingIndicators(ctx context.Context, postID string) (<-chan []*model.TypingIndicator, error) {
+	ch := r.Broker.SubscribeTyping(postID)
+
+	go func() {
+		<-ctx.Done()
+		r.Broker.UnsubscribeTyping(postID, ch)
+	}()
+
+	return ch, nil
+}
+
+// Comment returns CommentResolver implementation.
+func (r *Resolver) Comment() CommentResolver { return &commentResolver{r} }
+
 // Mutation returns MutationResolver implementation.
 func (r *Resolver) Mutation() MutationResolver { return &mutationResolver{r} }
 
+// Notification returns NotificationResolver implementation.
+func (r *Resolver) Notification() NotificationResolver { return &notificationResolver{r} }
+
+// Post returns PostResolver implementation.
+func (r *Resolver) Post() PostResolver { return &postResolver{r} }
+
 // Query returns QueryResolver implementation.
 func (r *Resolver) Query() QueryResolver { return &queryResolver{r} }
 
 // Subscription returns SubscriptionResolver implementation.
 func (r *Resolver) Subscription() SubscriptionResolver { return &subscriptionResolver{r} }
 
+type commentResolver struct{ *Resolver }
 type mutationResolver struct{ *Resolver }
+type notificationResolver struct{ *Resolver }
+type postResolver struct{ *Resolver }
 type queryResolver struct{ *Resolver }
 type subscriptionResolver struct{ *Resolver }
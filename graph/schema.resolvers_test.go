@@ -6,7 +6,10 @@ import (
 	"time"
 
 	"ozon-comments-graphql/graph"
+	"ozon-comments-graphql/graph/model"
+	"ozon-comments-graphql/internal/admin"
 	"ozon-comments-graphql/internal/storage"
+	"ozon-comments-graphql/internal/webhook"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -14,7 +17,7 @@ import (
 func TestPostCreation(t *testing.T) {
 	r := &graph.Resolver{
 		Store:  storage.NewMemoryStorage(),
-		Broker: graph.NewCommentBroker(),
+		Broker: graph.NewBroker(),
 	}
 
 	post, err := r.Mutation().CreatePost(context.Background(), "Title", "Content")
@@ -26,13 +29,13 @@ func TestPostCreation(t *testing.T) {
 func TestCommentWorkflow(t *testing.T) {
 	r := &graph.Resolver{
 		Store:  storage.NewMemoryStorage(),
-		Broker: graph.NewCommentBroker(),
+		Broker: graph.NewBroker(),
 	}
 	ctx := context.Background()
 
 	post, _ := r.Mutation().CreatePost(ctx, "Test", "Content")
 
-	comment, err := r.Mutation().CreateComment(ctx, post.ID, nil, "My comment")
+	comment, err := r.Mutation().CreateComment(ctx, post.ID, nil, "My comment", nil, nil)
 	assert.NoError(t, err)
 	assert.Equal(t, "My comment", comment.Content)
 
@@ -44,7 +47,7 @@ func TestCommentWorkflow(t *testing.T) {
 func TestSubscriptionSimple(t *testing.T) {
 	r := &graph.Resolver{
 		Store:  storage.NewMemoryStorage(),
-		Broker: graph.NewCommentBroker(),
+		Broker: graph.NewBroker(),
 	}
 	ctx := context.Background()
 
@@ -53,10 +56,10 @@ func TestSubscriptionSimple(t *testing.T) {
 	subCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
 	defer cancel()
 
-	ch, err := r.Subscription().CommentAdded(subCtx, post.ID)
+	ch, err := r.Subscription().CommentAdded(subCtx, post.ID, nil, nil)
 	assert.NoError(t, err)
 
-	newComment, _ := r.Mutation().CreateComment(subCtx, post.ID, nil, "New!")
+	newComment, _ := r.Mutation().CreateComment(subCtx, post.ID, nil, "New!", nil, nil)
 
 	select {
 	case msg := <-ch:
@@ -68,7 +71,8 @@ func TestSubscriptionSimple(t *testing.T) {
 
 func TestDisabledComments(t *testing.T) {
 	r := &graph.Resolver{
-		Store: storage.NewMemoryStorage(),
+		Store:  storage.NewMemoryStorage(),
+		Broker: graph.NewBroker(),
 	}
 	ctx := context.Background()
 
@@ -77,6 +81,56 @@ func TestDisabledComments(t *testing.T) {
 	_, err := r.Mutation().ToggleComments(ctx, post.ID, true)
 	assert.NoError(t, err)
 
-	_, err = r.Mutation().CreateComment(ctx, post.ID, nil, "Test")
+	_, err = r.Mutation().CreateComment(ctx, post.ID, nil, "Test", nil, nil)
 	assert.Error(t, err)
 }
+
+func TestPostComments(t *testing.T) {
+	r := &graph.Resolver{
+		Store:  storage.NewMemoryStorage(),
+		Broker: graph.NewBroker(),
+	}
+	ctx := context.Background()
+
+	post, _ := r.Mutation().CreatePost(ctx, "Test", "Content")
+	for i := 0; i < 3; i++ {
+		_, err := r.Mutation().CreateComment(ctx, post.ID, nil, "Comment", nil, nil)
+		assert.NoError(t, err)
+	}
+
+	got, err := r.Query().Post(ctx, post.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), got.CommentCount)
+	assert.NotNil(t, got.LastCommentAt)
+
+	first := int32(2)
+	page, err := r.Post().Comments(ctx, got, &first, nil)
+	assert.NoError(t, err)
+	assert.Len(t, page.Items, 2)
+	assert.NotNil(t, page.NextCursor)
+}
+
+func TestWebhooksRequireAdmin(t *testing.T) {
+	r := &graph.Resolver{
+		Store:  storage.NewMemoryStorage(),
+		Broker: graph.NewBroker(),
+	}
+	ctx := context.Background()
+	events := []model.WebhookEvent{model.WebhookEventCommentCreated}
+
+	_, err := r.Mutation().RegisterWebhook(ctx, "https://93.184.215.14/hook", events, "secret")
+	assert.ErrorIs(t, err, admin.ErrRequired)
+	_, err = r.Query().Webhooks(ctx)
+	assert.ErrorIs(t, err, admin.ErrRequired)
+	_, err = r.Query().WebhookDeliveries(ctx, nil, nil, nil)
+	assert.ErrorIs(t, err, admin.ErrRequired)
+
+	ctx = admin.WithAdmin(ctx)
+	_, err = r.Mutation().RegisterWebhook(ctx, "http://169.254.169.254/latest", events, "secret")
+	assert.ErrorIs(t, err, webhook.ErrPrivateAddress)
+	_, err = r.Mutation().RegisterWebhook(ctx, "https://93.184.215.14/hook", events, "secret")
+	assert.NoError(t, err)
+	hooks, err := r.Query().Webhooks(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, hooks, 1)
+}
@@ -2,18 +2,23 @@ package graph_test
 
 import (
 	"context"
+	"fmt"
 	"ozon-comments-graphql/graph"
+	"ozon-comments-graphql/graph/model"
+	"ozon-comments-graphql/internal/limits"
+	"ozon-comments-graphql/internal/outbox"
 	"ozon-comments-graphql/internal/storage"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSubscriptions(t *testing.T) {
 	store := storage.NewMemoryStorage()
-	broker := graph.NewCommentBroker()
+	broker := graph.NewBroker()
 	resolver := &graph.Resolver{
 		Store:  store,
 		Broker: broker,
@@ -24,7 +29,7 @@ func TestSubscriptions(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
 
-	subCh, err := resolver.Subscription().CommentAdded(ctx, post.ID)
+	subCh, err := resolver.Subscription().CommentAdded(ctx, post.ID, nil, nil)
 	assert.NoError(t, err)
 
 	var wg sync.WaitGroup
@@ -36,8 +41,584 @@ func TestSubscriptions(t *testing.T) {
 		assert.Equal(t, "Test comment", comment.Content)
 	}()
 
-	_, err = resolver.Mutation().CreateComment(ctx, post.ID, nil, "Test comment")
+	_, err = resolver.Mutation().CreateComment(ctx, post.ID, nil, "Test comment", nil, nil)
 	assert.NoError(t, err)
 
 	wg.Wait()
 }
+
+func TestPostDeletedEndsSubscriptions(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	resolver := &graph.Resolver{
+		Store:  store,
+		Broker: graph.NewBroker(),
+	}
+
+	post := store.CreatePost(context.Background(), "Test", "Content")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	commentCh, err := resolver.Subscription().CommentAdded(ctx, post.ID, nil, nil)
+	assert.NoError(t, err)
+	postCh, err := resolver.Subscription().PostChanged(ctx, post.ID)
+	assert.NoError(t, err)
+
+	_, err = resolver.Mutation().DeletePost(ctx, post.ID, nil)
+	assert.NoError(t, err)
+
+	event, ok := <-postCh
+	assert.True(t, ok)
+	assert.Equal(t, model.PostEventTypeDeleted, event.Type)
+	assert.Equal(t, post.ID, event.Post.ID)
+
+	_, ok = <-postCh
+	assert.False(t, ok)
+	_, ok = <-commentCh
+	assert.False(t, ok)
+}
+
+func TestBrokerClose(t *testing.T) {
+	broker := graph.NewBroker()
+
+	ch := broker.Subscribe("post")
+	broker.Close()
+
+	_, ok := <-ch
+	assert.False(t, ok)
+
+	// Late subscribers complete immediately and unsubscribing stays safe.
+	late := broker.Subscribe("post")
+	_, ok = <-late
+	assert.False(t, ok)
+	broker.Unsubscribe("post", ch)
+	broker.Publish(context.Background(), &model.Comment{PostID: "post"})
+}
+
+func TestOutboxSubscriptions(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	broker := graph.NewBroker(graph.WithBufferSize(4))
+	relay := outbox.NewRelay(store, broker.HandleOutboxEvent, outbox.WithPollInterval(time.Hour))
+	resolver := &graph.Resolver{Store: store, Broker: broker, Outbox: relay}
+
+	post := store.CreatePost(context.Background(), "Test", "Content")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	go relay.Run(ctx)
+
+	subCh, err := resolver.Subscription().CommentAdded(ctx, post.ID, nil, nil)
+	assert.NoError(t, err)
+
+	created, err := resolver.Mutation().CreateComment(ctx, post.ID, nil, "via outbox", nil, nil)
+	assert.NoError(t, err)
+
+	select {
+	case comment := <-subCh:
+		assert.Equal(t, created.ID, comment.ID)
+	case <-ctx.Done():
+		t.Fatal("comment was not relayed")
+	}
+
+	// Relayed events are marked delivered.
+	pending, err := store.PendingOutboxEvents(ctx, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+	_, err = resolver.Mutation().CreateComment(ctx, post.ID, nil, "second", nil, nil)
+	assert.NoError(t, err)
+	comment := <-subCh
+	assert.Equal(t, "second", comment.Content)
+}
+
+func TestHandleOutboxEventDeduplicates(t *testing.T) {
+	broker := graph.NewBroker(graph.WithBufferSize(4))
+	ch := broker.Subscribe("post")
+	event := &storage.OutboxEvent{
+		ID:      "event-1",
+		Topic:   outbox.TopicCommentCreated,
+		Payload: []byte(`{"id":"c1","postID":"post","content":"hi","createdAt":"2024-01-01T00:00:00Z"}`),
+	}
+
+	assert.NoError(t, broker.HandleOutboxEvent(context.Background(), event))
+	assert.NoError(t, broker.HandleOutboxEvent(context.Background(), event))
+
+	assert.Len(t, ch, 1)
+	assert.Equal(t, "c1", (<-ch).ID)
+}
+
+func TestToggleCommentsOutboxEvent(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	relay := outbox.NewRelay(store, nil)
+	resolver := &graph.Resolver{Store: store, Broker: graph.NewBroker(), Outbox: relay}
+	ctx := context.Background()
+
+	post := store.CreatePost(ctx, "Test", "Content")
+
+	// Only actual changes are recorded.
+	_, err := resolver.Mutation().ToggleComments(ctx, post.ID, false)
+	assert.NoError(t, err)
+	res, err := resolver.Mutation().ToggleComments(ctx, post.ID, true)
+	assert.NoError(t, err)
+	assert.True(t, res.CommentsDisabled)
+	_, err = resolver.Mutation().ToggleComments(ctx, post.ID, true)
+	assert.NoError(t, err)
+
+	pending, err := store.PendingOutboxEvents(ctx, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, pending, 1) {
+		assert.Equal(t, outbox.TopicPostToggled, pending[0].Topic)
+		assert.Contains(t, string(pending[0].Payload), `"commentsDisabled":true`)
+	}
+
+	_, err = resolver.Mutation().ToggleComments(ctx, "missing", true)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestPostOutboxEvents(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	relay := outbox.NewRelay(store, nil)
+	resolver := &graph.Resolver{Store: store, Broker: graph.NewBroker(), Outbox: relay}
+	ctx := context.Background()
+	topics := func() []string {
+		pending, err := store.PendingOutboxEvents(ctx, 10)
+		assert.NoError(t, err)
+		var topics []string
+		for _, e := range pending {
+			topics = append(topics, e.Topic)
+		}
+		require.NoError(t, store.MarkOutboxDelivered(ctx, eventIDs(pending)))
+		return topics
+	}
+
+	post := store.CreatePost(ctx, "Test", "Content")
+	title := "Renamed"
+	_, err := resolver.Mutation().UpdatePost(ctx, post.ID, &title, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{outbox.TopicPostUpdated}, topics())
+
+	// A soft delete keeps the comments.
+	_, err = resolver.Mutation().CreateComment(ctx, post.ID, nil, "kept", nil, nil)
+	assert.NoError(t, err)
+	topics()
+	soft := true
+	_, err = resolver.Mutation().DeletePost(ctx, post.ID, &soft)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{outbox.TopicPostDeleted}, topics())
+
+	// A hard delete reports every comment removed with the post, even of a
+	// post deleted softly before.
+	_, err = resolver.Mutation().DeletePost(ctx, post.ID, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{outbox.TopicCommentDeleted, outbox.TopicPostDeleted}, topics())
+
+	// Failed changes record nothing.
+	_, err = resolver.Mutation().UpdatePost(ctx, post.ID, &title, nil)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = resolver.Mutation().DeletePost(ctx, post.ID, nil)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	assert.Empty(t, topics())
+}
+
+func TestHandleOutboxEventPostDeleted(t *testing.T) {
+	broker := graph.NewBroker()
+	ch := broker.SubscribePost("post")
+	err := broker.HandleOutboxEvent(context.Background(), &storage.OutboxEvent{
+		ID:      "event-1",
+		Topic:   outbox.TopicPostDeleted,
+		Payload: []byte(`{"id":"post","title":"Test","content":"Content","createdAt":"2024-01-01T00:00:00Z"}`),
+	})
+	assert.NoError(t, err)
+
+	event := <-ch
+	assert.Equal(t, model.PostEventTypeDeleted, event.Type)
+	_, ok := <-ch
+	assert.False(t, ok, "deleting the post ends its subscriptions")
+}
+
+func eventIDs(events []*storage.OutboxEvent) []string {
+	ids := make([]string, len(events))
+	for i, e := range events {
+		ids[i] = e.ID
+	}
+	return ids
+}
+
+func TestPostUpdated(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	store := storage.NewMemoryStorage()
+	resolver := &graph.Resolver{Store: store, Broker: graph.NewBroker(graph.WithBufferSize(4))}
+	post := store.CreatePost(ctx, "Test", "Content")
+
+	updates, err := resolver.Subscription().PostUpdated(ctx, post.ID)
+	assert.NoError(t, err)
+	next := func() *model.Post {
+		select {
+		case p := <-updates:
+			return p
+		case <-ctx.Done():
+			t.Fatal("post update was not published")
+			return nil
+		}
+	}
+
+	_, err = resolver.Mutation().ToggleComments(ctx, post.ID, true)
+	assert.NoError(t, err)
+	assert.True(t, next().CommentsDisabled)
+
+	title := "Renamed"
+	_, err = resolver.Mutation().UpdatePost(ctx, post.ID, &title, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "Renamed", next().Title)
+
+	// Deleting the post ends the subscription without an update.
+	_, err = resolver.Mutation().DeletePost(ctx, post.ID, nil)
+	assert.NoError(t, err)
+	_, ok := <-updates
+	assert.False(t, ok)
+}
+
+func TestNotifications(t *testing.T) {
+	for name, withOutbox := range map[string]bool{"direct": false, "outbox": true} {
+		t.Run(name, func(t *testing.T) {
+			store := storage.NewMemoryStorage()
+			broker := graph.NewBroker(graph.WithBufferSize(4))
+			resolver := &graph.Resolver{Store: store, Broker: broker}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if withOutbox {
+				resolver.Outbox = outbox.NewRelay(store, broker.HandleOutboxEvent, outbox.WithPollInterval(time.Hour))
+				go resolver.Outbox.Run(ctx)
+			}
+
+			alice, bob := "alice", "bob"
+			post := store.CreatePost(ctx, "Test", "Content")
+			sub, err := resolver.Subscription().NotificationAdded(ctx, alice)
+			assert.NoError(t, err)
+
+			root, err := resolver.Mutation().CreateComment(ctx, post.ID, nil, "first", &alice, nil)
+			assert.NoError(t, err)
+			reply, err := resolver.Mutation().CreateComment(ctx, post.ID, &root.ID, "@alice @carol agreed", &bob, nil)
+			assert.NoError(t, err)
+
+			select {
+			case n := <-sub:
+				assert.Equal(t, model.NotificationTypeReply, n.Type)
+				assert.Equal(t, reply.ID, n.CommentID)
+				assert.Equal(t, &bob, n.Actor)
+			case <-ctx.Done():
+				t.Fatal("notification was not published")
+			}
+
+			page, err := resolver.Query().Notifications(ctx, "carol", nil, nil, nil)
+			assert.NoError(t, err)
+			if assert.Len(t, page.Items, 1) {
+				assert.Equal(t, model.NotificationTypeMention, page.Items[0].Type)
+				assert.False(t, page.Items[0].Read)
+				comment, err := resolver.Notification().Comment(ctx, page.Items[0])
+				assert.NoError(t, err)
+				assert.Equal(t, "@alice @carol agreed", comment.Content)
+			}
+
+			n, err := resolver.Mutation().MarkNotificationsRead(ctx, "carol", nil)
+			assert.NoError(t, err)
+			assert.EqualValues(t, 1, n)
+			unreadOnly := true
+			page, err = resolver.Query().Notifications(ctx, "carol", nil, nil, &unreadOnly)
+			assert.NoError(t, err)
+			assert.Empty(t, page.Items)
+
+			invalid := "not valid"
+			_, err = resolver.Mutation().CreateComment(ctx, post.ID, nil, "hi", &invalid, nil)
+			assert.ErrorIs(t, err, graph.ErrInvalidUsername)
+		})
+	}
+}
+
+func TestThreadSubscriptions(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	store := storage.NewMemoryStorage()
+	post := store.CreatePost(ctx, "Test", "Content")
+	// Created before the broker exists, so only storage knows their parents.
+	root, _ := store.CreateComment(ctx, post.ID, nil, nil, "root")
+	child, _ := store.CreateComment(ctx, post.ID, &root.ID, nil, "child")
+
+	broker := graph.NewBroker(graph.WithBufferSize(8), graph.WithCommentStore(store))
+	resolver := &graph.Resolver{Store: store, Broker: broker}
+	includeDescendants := true
+	direct, err := resolver.Subscription().CommentAdded(ctx, post.ID, &root.ID, nil)
+	assert.NoError(t, err)
+	subtree, err := resolver.Subscription().CommentAdded(ctx, post.ID, &root.ID, &includeDescendants)
+	assert.NoError(t, err)
+	all, err := resolver.Subscription().CommentAdded(ctx, post.ID, nil, nil)
+	assert.NoError(t, err)
+
+	reply, _ := resolver.Mutation().CreateComment(ctx, post.ID, &root.ID, "reply", nil, nil)
+	grandchild, _ := resolver.Mutation().CreateComment(ctx, post.ID, &child.ID, "grandchild", nil, nil)
+	deeper, _ := resolver.Mutation().CreateComment(ctx, post.ID, &grandchild.ID, "deeper", nil, nil)
+	other, _ := resolver.Mutation().CreateComment(ctx, post.ID, nil, "other thread", nil, nil)
+
+	received := func(ch <-chan *model.Comment) []string {
+		var ids []string
+		for len(ch) > 0 {
+			ids = append(ids, (<-ch).ID)
+		}
+		return ids
+	}
+	assert.Equal(t, []string{reply.ID}, received(direct))
+	assert.Equal(t, []string{reply.ID, grandchild.ID, deeper.ID}, received(subtree))
+	assert.Equal(t, []string{reply.ID, grandchild.ID, deeper.ID, other.ID}, received(all))
+
+	_, err = resolver.Subscription().CommentAdded(ctx, "other-post", &root.ID, nil)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestPostSubscriptions(t *testing.T) {
+	for name, withOutbox := range map[string]bool{"direct": false, "outbox": true} {
+		t.Run(name, func(t *testing.T) {
+			store := storage.NewMemoryStorage()
+			broker := graph.NewBroker(graph.WithBufferSize(4))
+			resolver := &graph.Resolver{Store: store, Broker: broker}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if withOutbox {
+				resolver.Outbox = outbox.NewRelay(store, broker.HandleOutboxEvent, outbox.WithPollInterval(time.Hour))
+				go resolver.Outbox.Run(ctx)
+			}
+
+			all, err := resolver.Subscription().PostAdded(ctx, nil)
+			assert.NoError(t, err)
+			title := "GO"
+			golang, err := resolver.Subscription().PostAdded(ctx, &model.PostFilter{TitleContains: &title})
+			assert.NoError(t, err)
+
+			_, err = resolver.Mutation().CreatePost(ctx, "Rust news", "Content")
+			assert.NoError(t, err)
+			created, err := resolver.Mutation().CreatePost(ctx, "Go news", "Content")
+			assert.NoError(t, err)
+
+			for _, want := range []string{"Rust news", "Go news"} {
+				select {
+				case p := <-all:
+					assert.Equal(t, want, p.Title)
+				case <-ctx.Done():
+					t.Fatal("post was not published")
+				}
+			}
+			select {
+			case p := <-golang:
+				assert.Equal(t, created.ID, p.ID)
+			case <-ctx.Done():
+				t.Fatal("post was not published")
+			}
+			assert.Empty(t, golang)
+
+			// toggleComments is announced only when the state changes.
+			changes, err := resolver.Subscription().PostChanged(ctx, created.ID)
+			assert.NoError(t, err)
+			_, err = resolver.Mutation().ToggleComments(ctx, created.ID, false)
+			assert.NoError(t, err)
+			_, err = resolver.Mutation().ToggleComments(ctx, created.ID, true)
+			assert.NoError(t, err)
+
+			select {
+			case e := <-changes:
+				assert.Equal(t, model.PostEventTypeUpdated, e.Type)
+				assert.True(t, e.Post.CommentsDisabled)
+			case <-ctx.Done():
+				t.Fatal("toggle was not published")
+			}
+			assert.Empty(t, changes)
+		})
+	}
+}
+
+func TestPostActivity(t *testing.T) {
+	// The interval leaves a wide margin for the bursts below to fit in, and
+	// timings are only checked against half of it.
+	const interval = 300 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	store := storage.NewMemoryStorage()
+	broker := graph.NewBroker(graph.WithBufferSize(8), graph.WithCommentStore(store),
+		graph.WithActivityInterval(interval))
+	resolver := &graph.Resolver{Store: store, Broker: broker}
+	post := store.CreatePost(ctx, "Test", "Content")
+
+	_, err := resolver.Subscription().PostActivity(ctx, "missing")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	activity, err := resolver.Subscription().PostActivity(ctx, post.ID)
+	assert.NoError(t, err)
+	next := func() *model.PostActivity {
+		select {
+		case a := <-activity:
+			return a
+		case <-ctx.Done():
+			t.Fatal("activity was not published")
+			return nil
+		}
+	}
+
+	first := next()
+	assert.Equal(t, post.ID, first.PostID)
+	assert.Zero(t, first.Viewers)
+	assert.Zero(t, first.CommentCount)
+
+	// A burst of changes is folded into one update an interval later.
+	start := time.Now()
+	viewerCtx, leave := context.WithCancel(ctx)
+	_, err = resolver.Subscription().CommentAdded(viewerCtx, post.ID, nil, nil)
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = resolver.Mutation().CreateComment(ctx, post.ID, nil, "hi", nil, nil)
+		assert.NoError(t, err)
+	}
+	second := next()
+	assert.GreaterOrEqual(t, time.Since(start), interval/2)
+	assert.EqualValues(t, 1, second.Viewers)
+	assert.EqualValues(t, 3, second.CommentCount)
+
+	// The next change waits for the interval to pass again.
+	start = time.Now()
+	_, err = resolver.Mutation().CreateComment(ctx, post.ID, nil, "hi", nil, nil)
+	assert.NoError(t, err)
+	third := next()
+	assert.GreaterOrEqual(t, time.Since(start), interval/2)
+	assert.EqualValues(t, 4, third.CommentCount)
+
+	leave()
+	assert.Eventually(t, func() bool {
+		select {
+		case a := <-activity:
+			return a.Viewers == 0
+		default:
+			return false
+		}
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Deleting the post ends the subscription.
+	_, err = resolver.Mutation().DeletePost(ctx, post.ID, nil)
+	assert.NoError(t, err)
+	for range activity {
+	}
+}
+
+func TestTypingIndicators(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	store := storage.NewMemoryStorage()
+	broker := graph.NewBroker(graph.WithBufferSize(8), graph.WithTypingTTL(100*time.Millisecond))
+	resolver := &graph.Resolver{Store: store, Broker: broker}
+	post := store.CreatePost(ctx, "Test", "Content")
+	root, _ := store.CreateComment(ctx, post.ID, nil, nil, "root")
+
+	sub, err := resolver.Subscription().TypingIndicators(ctx, post.ID)
+	assert.NoError(t, err)
+	next := func() []*model.TypingIndicator {
+		select {
+		case list := <-sub:
+			return list
+		case <-ctx.Done():
+			t.Fatal("typing indicators were not published")
+			return nil
+		}
+	}
+	assert.Empty(t, next())
+
+	alice, bob := "alice", "bob"
+	ok, err := resolver.Mutation().StartTyping(ctx, post.ID, &root.ID, alice)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []*model.TypingIndicator{{User: alice, ParentID: &root.ID}}, next())
+
+	_, err = resolver.Mutation().StartTyping(ctx, post.ID, nil, bob)
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.TypingIndicator{{User: alice, ParentID: &root.ID}, {User: bob}}, next())
+
+	// Commenting in the thread ends the indicator at once.
+	_, err = resolver.Mutation().CreateComment(ctx, post.ID, &root.ID, "done", &alice, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.TypingIndicator{{User: bob}}, next())
+
+	// Repeated calls keep an indicator alive without new events.
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		time.Sleep(50 * time.Millisecond)
+		_, err = resolver.Mutation().StartTyping(ctx, post.ID, nil, bob)
+		assert.NoError(t, err)
+	}
+	assert.Empty(t, next())
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+
+	_, err = resolver.Mutation().StartTyping(ctx, post.ID, nil, "not valid")
+	assert.ErrorIs(t, err, graph.ErrInvalidUsername)
+	_, err = resolver.Mutation().StartTyping(ctx, "missing", nil, bob)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	_, _, err = store.ToggleComments(ctx, post.ID, true)
+	assert.NoError(t, err)
+	_, err = resolver.Mutation().StartTyping(ctx, post.ID, nil, bob)
+	assert.ErrorIs(t, err, storage.ErrForbidden)
+	assert.Empty(t, sub)
+}
+
+func TestTypingIndicatorsCapped(t *testing.T) {
+	ctx := context.Background()
+	broker := graph.NewBroker()
+	for i := 0; i < 60; i++ {
+		broker.StartTyping(ctx, "post", nil, fmt.Sprintf("conn%02d", i), fmt.Sprintf("user%02d", i))
+	}
+
+	sub := broker.SubscribeTyping("post")
+	defer broker.UnsubscribeTyping("post", sub)
+	list := <-sub
+	if assert.Len(t, list, 50) {
+		assert.Equal(t, "user49", list[49].User)
+	}
+
+	// Those already shown can still keep their indicators alive.
+	broker.StartTyping(ctx, "post", nil, "conn00", "user00")
+	assert.Empty(t, sub)
+}
+
+func TestTypingIndicatorPerClient(t *testing.T) {
+	ctx := context.Background()
+	broker := graph.NewBroker(graph.WithBufferSize(8))
+	sub := broker.SubscribeTyping("post")
+	defer broker.UnsubscribeTyping("post", sub)
+	assert.Empty(t, <-sub)
+
+	// A client naming other users replaces its own indicator.
+	broker.StartTyping(ctx, "post", nil, "conn", "alice")
+	assert.Equal(t, []*model.TypingIndicator{{User: "alice"}}, <-sub)
+	broker.StartTyping(ctx, "post", nil, "conn", "bob")
+	assert.Equal(t, []*model.TypingIndicator{{User: "bob"}}, <-sub)
+	thread := "thread"
+	broker.StartTyping(ctx, "post", &thread, "conn", "bob")
+	assert.Equal(t, []*model.TypingIndicator{{User: "bob", ParentID: &thread}}, <-sub)
+
+	// Two clients showing the same user are listed once.
+	broker.StartTyping(ctx, "post", &thread, "other", "bob")
+	assert.Equal(t, []*model.TypingIndicator{{User: "bob", ParentID: &thread}}, <-sub)
+	assert.Empty(t, sub)
+}
+
+func TestStartTypingThrottled(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	resolver := &graph.Resolver{
+		Store:          store,
+		Broker:         graph.NewBroker(),
+		TypingThrottle: limits.NewThrottle(time.Hour, 2),
+	}
+	post := store.CreatePost(ctx, "Test", "Content")
+
+	for i := 0; i < 2; i++ {
+		ok, err := resolver.Mutation().StartTyping(ctx, post.ID, nil, fmt.Sprintf("user%d", i))
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+	_, err := resolver.Mutation().StartTyping(ctx, post.ID, nil, "user2")
+	assert.ErrorIs(t, err, graph.ErrTypingThrottled)
+}
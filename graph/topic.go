@@ -0,0 +1,130 @@
+package graph
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// topic is the set of subscribers to one subscription field, grouped by key:
+// a post ID, a user name, or "" for feeds not tied to anything. Each
+// subscriber carries a filter of type F deciding which events it gets.
+// topic does no locking of its own; the Broker's mutex guards all topics.
+type topic[T, F any] struct {
+	name string
+	subs map[string]map[chan T]F
+}
+
+func newTopic[T, F any](name string) *topic[T, F] {
+	return &topic[T, F]{name: name, subs: make(map[string]map[chan T]F)}
+}
+
+func (t *topic[T, F]) add(key string, ch chan T, filter F) {
+	if _, ok := t.subs[key]; !ok {
+		t.subs[key] = make(map[chan T]F)
+	}
+	t.subs[key][ch] = filter
+}
+
+// remove closes ch unless it was already removed, e.g. by closeKey.
+func (t *topic[T, F]) remove(key string, ch chan T) {
+	subs, ok := t.subs[key]
+	if !ok {
+		return
+	}
+	if _, ok := subs[ch]; !ok {
+		return
+	}
+	delete(subs, ch)
+	close(ch)
+	if len(subs) == 0 {
+		delete(t.subs, key)
+	}
+}
+
+// closeKey ends every subscription under key.
+func (t *topic[T, F]) closeKey(key string) {
+	for ch := range t.subs[key] {
+		close(ch)
+	}
+	delete(t.subs, key)
+}
+
+func (t *topic[T, F]) closeAll() {
+	for key := range t.subs {
+		t.closeKey(key)
+	}
+}
+
+// size returns the number of subscribers under all keys.
+func (t *topic[T, F]) size() int {
+	n := 0
+	for _, subs := range t.subs {
+		n += len(subs)
+	}
+	return n
+}
+
+func (t *topic[T, F]) keys() []string {
+	keys := make([]string, 0, len(t.subs))
+	for key := range t.subs {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (t *topic[T, F]) counts() map[string]int {
+	counts := make(map[string]int, len(t.subs))
+	for key, subs := range t.subs {
+		counts[key] = len(subs)
+	}
+	return counts
+}
+
+// subscribe adds a subscriber to t under key. After Close it returns a
+// closed channel, so the subscription completes at once.
+func subscribe[T, F any](b *Broker, t *topic[T, F], key string, filter F) chan T {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan T, b.bufferSize)
+	if b.closed {
+		close(ch)
+		return ch
+	}
+	t.add(key, ch, filter)
+	return ch
+}
+
+func unsubscribe[T, F any](b *Broker, t *topic[T, F], key string, ch chan T) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t.remove(key, ch)
+}
+
+// deliver hands event to every subscriber under key whose filter matches,
+// never blocking: a subscriber whose buffer is full misses the event. A nil
+// match accepts every filter. How many subscribers matched and how many
+// missed the event is recorded on the publish span in ctx. The caller holds
+// b.mu.
+func deliver[T, F any](ctx context.Context, b *Broker, t *topic[T, F], key string, event T, match func(F) bool) {
+	matched, dropped := 0, 0
+	for ch, f := range t.subs[key] {
+		if match != nil && !match(f) {
+			continue
+		}
+		matched++
+		select {
+		case ch <- event:
+		default:
+			b.dropped.Add(1)
+			dropped++
+		}
+	}
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.Int("subscribers", matched),
+		attribute.Int("dropped", dropped),
+	)
+}
@@ -0,0 +1,195 @@
+package graph
+
+import (
+	"context"
+	"ozon-comments-graphql/graph/model"
+	"sort"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultTypingTTL = 5 * time.Second
+	// maxTypists bounds the indicators shown on one post, and so the size of
+	// every typingIndicators event.
+	maxTypists = 50
+)
+
+// typing is a live typing indicator of one client: the user it last named,
+// writing in one thread. The timer fires at the first expiry; if StartTyping
+// extended it meanwhile, the timer is rearmed instead.
+type typing struct {
+	user      string
+	parentID  *string
+	expiresAt time.Time
+	timer     *time.Timer
+}
+
+// WithTypingTTL sets how long a typing indicator lasts after the last
+// StartTyping for it.
+func WithTypingTTL(d time.Duration) BrokerOption {
+	return func(b *Broker) {
+		b.typingTTL = d
+	}
+}
+
+// StartTyping shows user as typing on a post, in the thread below parentID
+// or at the top level. client identifies the connection the call came from:
+// each client has at most one indicator per post, so naming another user or
+// thread replaces it. Only a new or changed indicator is announced;
+// repeating the call merely keeps it alive. While maxTypists clients are
+// shown on the post, new ones are ignored.
+func (b *Broker) StartTyping(ctx context.Context, postID string, parentID *string, client, user string) {
+	ctx, span := tracer.Start(ctx, "broker.publish typingIndicators",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("post.id", postID)),
+	)
+	defer span.End()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+	expiresAt := time.Now().Add(b.typingTTL)
+	if t, ok := b.typists[postID][client]; ok {
+		t.expiresAt = expiresAt
+		if t.user == user && parentKey(t.parentID) == parentKey(parentID) {
+			return
+		}
+		t.user, t.parentID = user, parentID
+		deliver(ctx, b, b.typing, postID, b.typingList(postID), nil)
+		return
+	}
+	if len(b.typists[postID]) >= maxTypists {
+		return
+	}
+
+	if _, ok := b.typists[postID]; !ok {
+		b.typists[postID] = make(map[string]*typing)
+	}
+	t := &typing{user: user, parentID: parentID, expiresAt: expiresAt}
+	t.timer = time.AfterFunc(b.typingTTL, func() { b.expireTyping(postID, client, t) })
+	b.typists[postID][client] = t
+	deliver(ctx, b, b.typing, postID, b.typingList(postID), nil)
+}
+
+func (b *Broker) expireTyping(postID, client string, t *typing) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.typists[postID][client] != t {
+		return
+	}
+	if left := time.Until(t.expiresAt); left > 0 {
+		t.timer = time.AfterFunc(left, func() { b.expireTyping(postID, client, t) })
+		return
+	}
+	b.stopTyping(context.Background(), postID, client)
+}
+
+// commented ends the typing indicators showing the author in the thread a
+// published comment went to.
+func (b *Broker) commented(ctx context.Context, comment *model.Comment) {
+	if comment.Author == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for client, t := range b.typists[comment.PostID] {
+		if t.user == *comment.Author && parentKey(t.parentID) == parentKey(comment.ParentID) {
+			b.stopTyping(ctx, comment.PostID, client)
+		}
+	}
+}
+
+// stopTyping removes a client's indicator and announces the change. The
+// caller holds b.mu.
+func (b *Broker) stopTyping(ctx context.Context, postID, client string) {
+	t, ok := b.typists[postID][client]
+	if !ok {
+		return
+	}
+	ctx, span := tracer.Start(ctx, "broker.publish typingIndicators",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("post.id", postID)),
+	)
+	defer span.End()
+
+	t.timer.Stop()
+	delete(b.typists[postID], client)
+	if len(b.typists[postID]) == 0 {
+		delete(b.typists, postID)
+	}
+	deliver(ctx, b, b.typing, postID, b.typingList(postID), nil)
+}
+
+// dropTyping forgets every indicator on a post without announcing it. The
+// caller holds b.mu.
+func (b *Broker) dropTyping(postID string) {
+	for _, t := range b.typists[postID] {
+		t.timer.Stop()
+	}
+	delete(b.typists, postID)
+}
+
+// typingList returns the indicators on a post ordered by user and thread.
+// Clients showing the same user in the same thread appear once. The caller
+// holds b.mu.
+func (b *Broker) typingList(postID string) []*model.TypingIndicator {
+	list := make([]*model.TypingIndicator, 0, len(b.typists[postID]))
+	for _, t := range b.typists[postID] {
+		list = append(list, &model.TypingIndicator{User: t.user, ParentID: t.parentID})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].User != list[j].User {
+			return list[i].User < list[j].User
+		}
+		return parentKey(list[i].ParentID) < parentKey(list[j].ParentID)
+	})
+
+	deduped := list[:0]
+	for _, ind := range list {
+		if n := len(deduped); n > 0 && deduped[n-1].User == ind.User && parentKey(deduped[n-1].ParentID) == parentKey(ind.ParentID) {
+			continue
+		}
+		deduped = append(deduped, ind)
+	}
+	return deduped
+}
+
+// parentKey returns parentID, or "" for a top-level comment.
+func parentKey(parentID *string) string {
+	if parentID == nil {
+		return ""
+	}
+	return *parentID
+}
+
+// SubscribeTyping subscribes to the typing indicators on a post. The current
+// list is the first event.
+func (b *Broker) SubscribeTyping(postID string) chan []*model.TypingIndicator {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan []*model.TypingIndicator, b.bufferSize)
+	if b.closed {
+		close(ch)
+		return ch
+	}
+	b.typing.add(postID, ch, struct{}{})
+	select {
+	case ch <- b.typingList(postID):
+	default:
+	}
+	return ch
+}
+
+func (b *Broker) UnsubscribeTyping(postID string, ch chan []*model.TypingIndicator) {
+	unsubscribe(b, b.typing, postID, ch)
+}
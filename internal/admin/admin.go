@@ -0,0 +1,47 @@
+// Package admin guards the operations only the operator of the service may
+// use, such as managing webhooks. A request is an admin request when it
+// carries "Authorization: Bearer <token>" with the configured admin token;
+// without a configured token nobody is an admin.
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var ErrRequired = errors.New("admin token required")
+
+type ctxKey struct{}
+
+// Middleware marks requests bearing token as admin requests. Websocket
+// connections inherit the mark of their upgrade request.
+func Middleware(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && validToken(r.Header.Get("Authorization"), token) {
+			r = r.WithContext(context.WithValue(r.Context(), ctxKey{}, true))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func validToken(header, token string) bool {
+	got, ok := strings.CutPrefix(header, "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// Require returns ErrRequired unless ctx belongs to an admin request.
+func Require(ctx context.Context) error {
+	if ok, _ := ctx.Value(ctxKey{}).(bool); !ok {
+		return ErrRequired
+	}
+	return nil
+}
+
+// WithAdmin marks ctx as an admin request, for callers that authenticate
+// admins by other means, and for tests.
+func WithAdmin(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctxKey{}, true)
+}
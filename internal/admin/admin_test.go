@@ -0,0 +1,32 @@
+package admin_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ozon-comments-graphql/internal/admin"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	check := func(token, header string) error {
+		var err error
+		h := admin.Middleware(token, http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			err = admin.Require(r.Context())
+		}))
+		req := httptest.NewRequest(http.MethodPost, "/query", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		return err
+	}
+
+	assert.NoError(t, check("s3cret", "Bearer s3cret"))
+	assert.ErrorIs(t, check("s3cret", "Bearer wrong"), admin.ErrRequired)
+	assert.ErrorIs(t, check("s3cret", "s3cret"), admin.ErrRequired)
+	assert.ErrorIs(t, check("s3cret", ""), admin.ErrRequired)
+	assert.ErrorIs(t, check("", "Bearer "), admin.ErrRequired, "no token configured")
+}
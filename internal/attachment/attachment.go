@@ -0,0 +1,243 @@
+// Package attachment validates files uploaded with comments and makes
+// thumbnails of images. The content type is detected from the bytes; what
+// the client claims is ignored.
+package attachment
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // registers the GIF decoder
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// ThumbnailSize bounds both sides of a thumbnail.
+	ThumbnailSize = 256
+	// MaxPixels rejects images whose header promises more pixels than is
+	// sane to decode, e.g. decompression bombs: a tiny file can still
+	// decode to up to 8 bytes per pixel.
+	MaxPixels = 16_000_000
+	// maxDecodes bounds the images decoded at once across all requests, so
+	// that concurrent uploads cannot add up to more memory than a few
+	// MaxPixels images.
+	maxDecodes = 2
+	// samples is how many source pixels per side are averaged into one
+	// thumbnail pixel at most.
+	samples         = 4
+	maxFilenameLen  = 255
+	defaultFilename = "file"
+)
+
+var (
+	ErrTooLarge    = errors.New("attachment too large")
+	ErrUnsupported = errors.New("unsupported attachment type")
+	ErrInvalid     = errors.New("invalid attachment")
+)
+
+// types maps accepted content types to the extension blobs get.
+var types = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"application/pdf": ".pdf",
+	"text/plain":      ".txt",
+}
+
+// decodes holds a slot per image being decoded.
+var decodes = make(chan struct{}, maxDecodes)
+
+// File is a validated upload.
+type File struct {
+	Filename    string
+	ContentType string
+	// Ext is the extension matching ContentType, with the dot.
+	Ext  string
+	Data []byte
+	// Thumbnail is set by MakeThumbnail for images this package can
+	// decode: JPEG, PNG and GIF. It is a JPEG for JPEG sources and a PNG
+	// otherwise.
+	Thumbnail *Thumbnail
+	// Width and Height are read from the header of such images.
+	Width, Height int
+}
+
+type Thumbnail struct {
+	ContentType string
+	Ext         string
+	Data        []byte
+}
+
+// Read reads and validates one upload of at most maxSize bytes. Images are
+// only checked by their header here; see MakeThumbnail.
+func Read(filename string, r io.Reader, maxSize int64) (*File, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("%w: %q is over %d bytes", ErrTooLarge, filename, maxSize)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: %q is empty", ErrInvalid, filename)
+	}
+
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	ext, ok := types[contentType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q is %s", ErrUnsupported, filename, contentType)
+	}
+
+	f := &File{
+		Filename:    cleanFilename(filename),
+		ContentType: contentType,
+		Ext:         ext,
+		Data:        data,
+	}
+	if f.decodable() {
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q is not a valid image: %v", ErrInvalid, f.Filename, err)
+		}
+		if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > MaxPixels {
+			return nil, fmt.Errorf("%w: %q is %dx%d pixels", ErrTooLarge, f.Filename, cfg.Width, cfg.Height)
+		}
+		f.Width, f.Height = cfg.Width, cfg.Height
+	}
+	return f, nil
+}
+
+func (f *File) decodable() bool {
+	switch f.ContentType {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// Pixels returns how many pixels decoding the image takes, 0 for files that
+// are not decoded.
+func (f *File) Pixels() int {
+	return f.Width * f.Height
+}
+
+// MakeThumbnail decodes an image and sets Thumbnail. It waits while too many
+// images are being decoded, until ctx is done.
+func (f *File) MakeThumbnail(ctx context.Context) error {
+	if !f.decodable() || f.Thumbnail != nil {
+		return nil
+	}
+	select {
+	case decodes <- struct{}{}:
+		defer func() { <-decodes }()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(f.Data))
+	if err != nil {
+		return fmt.Errorf("%w: %q is not a valid image: %v", ErrInvalid, f.Filename, err)
+	}
+
+	thumb := scale(img, ThumbnailSize)
+	var buf bytes.Buffer
+	t := &Thumbnail{ContentType: "image/png", Ext: ".png"}
+	if format == "jpeg" {
+		t.ContentType, t.Ext = "image/jpeg", ".jpg"
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 80})
+	} else {
+		err = png.Encode(&buf, thumb)
+	}
+	if err != nil {
+		return err
+	}
+	t.Data = buf.Bytes()
+	f.Thumbnail = t
+	return nil
+}
+
+// scale shrinks img to fit a max×max box, keeping its aspect ratio. Each
+// thumbnail pixel averages up to samples×samples source pixels spread over
+// the area it covers, so large images need not be copied. Smaller images
+// keep their size.
+func scale(img image.Image, max int) image.Image {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	w, h := sw, sh
+	if w > max || h > max {
+		if w >= h {
+			w, h = max, h*max/w
+		} else {
+			w, h = w*max/h, max
+		}
+	}
+	w, h = max1(w), max1(h)
+
+	dst := image.NewRGBA64(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := cover(y, sh, h)
+		for x := 0; x < w; x++ {
+			x0, x1 := cover(x, sw, w)
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy += max1((y1 - y0) / samples) {
+				for sx := x0; sx < x1; sx += max1((x1 - x0) / samples) {
+					cr, cg, cb, ca := img.At(b.Min.X+sx, b.Min.Y+sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{
+				R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n),
+			})
+		}
+	}
+	return dst
+}
+
+// cover returns the source range [lo, hi) that pixel i of dst pixels covers
+// out of src. It is never empty.
+func cover(i, src, dst int) (lo, hi int) {
+	lo, hi = i*src/dst, (i+1)*src/dst
+	if hi <= lo {
+		hi = lo + 1
+	}
+	return lo, hi
+}
+
+func max1(v int) int {
+	if v < 1 {
+		return 1
+	}
+	return v
+}
+
+// cleanFilename keeps the base name of what the client sent, without
+// control characters and within a sane length.
+func cleanFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, name)
+	for len(name) > maxFilenameLen {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	if name == "" || name == "." || name == "/" {
+		return defaultFilename
+	}
+	return name
+}
@@ -0,0 +1,123 @@
+package attachment_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+
+	"ozon-comments-graphql/internal/attachment"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func pngImage(t *testing.T, w, h int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 200, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+// pngHeader returns just the signature and header chunk of a PNG claiming to
+// be w×h pixels: enough for DecodeConfig, not for decoding.
+func pngHeader(w, h int) []byte {
+	ihdr := make([]byte, 17)
+	copy(ihdr, "IHDR")
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(w))
+	binary.BigEndian.PutUint32(ihdr[8:], uint32(h))
+	ihdr[12], ihdr[13] = 8, 6 // 8-bit RGBA
+
+	buf := bytes.NewBufferString("\x89PNG\r\n\x1a\n")
+	_ = binary.Write(buf, binary.BigEndian, uint32(13))
+	buf.Write(ihdr)
+	_ = binary.Write(buf, binary.BigEndian, crc32.ChecksumIEEE(ihdr))
+	return buf.Bytes()
+}
+
+func TestReadImage(t *testing.T) {
+	f, err := attachment.Read(`C:\photos\cat.PNG`, bytes.NewReader(pngImage(t, 800, 400)), 1<<20)
+	require.NoError(t, err)
+	assert.Nil(t, f.Thumbnail, "nothing is decoded while reading")
+	require.NoError(t, f.MakeThumbnail(context.Background()))
+
+	assert.Equal(t, "cat.PNG", f.Filename)
+	assert.Equal(t, "image/png", f.ContentType)
+	assert.Equal(t, ".png", f.Ext)
+	assert.Equal(t, 800, f.Width)
+	assert.Equal(t, 400, f.Height)
+
+	require.NotNil(t, f.Thumbnail)
+	assert.Equal(t, "image/png", f.Thumbnail.ContentType)
+	cfg, err := png.DecodeConfig(bytes.NewReader(f.Thumbnail.Data))
+	require.NoError(t, err)
+	assert.Equal(t, attachment.ThumbnailSize, cfg.Width)
+	assert.Equal(t, attachment.ThumbnailSize/2, cfg.Height)
+}
+
+func TestReadSmallImageKeepsSize(t *testing.T) {
+	f, err := attachment.Read("dot.png", bytes.NewReader(pngImage(t, 3, 5)), 1<<20)
+	require.NoError(t, err)
+	require.NoError(t, f.MakeThumbnail(context.Background()))
+
+	require.NotNil(t, f.Thumbnail)
+	cfg, err := png.DecodeConfig(bytes.NewReader(f.Thumbnail.Data))
+	require.NoError(t, err)
+	assert.Equal(t, 3, cfg.Width)
+	assert.Equal(t, 5, cfg.Height)
+}
+
+func TestReadIgnoresClaimedType(t *testing.T) {
+	f, err := attachment.Read("notes.png", strings.NewReader("just some text\n"), 1<<20)
+	require.NoError(t, err)
+
+	assert.Equal(t, "text/plain", f.ContentType)
+	assert.Equal(t, ".txt", f.Ext)
+	require.NoError(t, f.MakeThumbnail(context.Background()))
+	assert.Nil(t, f.Thumbnail)
+}
+
+func TestReadRejectsHugeImagesBeforeDecoding(t *testing.T) {
+	_, err := attachment.Read("bomb.png", bytes.NewReader(pngHeader(5000, 5000)), 1<<20)
+	assert.ErrorIs(t, err, attachment.ErrTooLarge)
+
+	f, err := attachment.Read("big.png", bytes.NewReader(pngHeader(4000, 4000)), 1<<20)
+	require.NoError(t, err)
+	assert.Equal(t, 16_000_000, f.Pixels())
+	assert.ErrorIs(t, f.MakeThumbnail(context.Background()), attachment.ErrInvalid)
+}
+
+func TestReadRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		max  int64
+		want error
+	}{
+		{"too large", bytes.Repeat([]byte("a"), 11), 10, attachment.ErrTooLarge},
+		{"empty", nil, 10, attachment.ErrInvalid},
+		{"html", []byte("<html><script>alert(1)</script></html>"), 1 << 20, attachment.ErrUnsupported},
+		{"executable", []byte("MZ\x90\x00\x03\x00\x00\x00"), 1 << 20, attachment.ErrUnsupported},
+		{"broken image", []byte("\x89PNG\r\n\x1a\n\x00\x00"), 1 << 20, attachment.ErrInvalid},
+		{"too many pixels", pngHeader(attachment.MaxPixels, 2), 1 << 20, attachment.ErrTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := attachment.Read("file", bytes.NewReader(tt.data), tt.max)
+			assert.True(t, errors.Is(err, tt.want), "got %v", err)
+		})
+	}
+}
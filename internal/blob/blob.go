@@ -0,0 +1,88 @@
+// Package blob stores the bytes of comment attachments outside the
+// database. FS keeps them on the local filesystem; the Store interface is
+// shaped after S3-compatible object stores, so such a backend can be added
+// without touching callers.
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps blobs under slash-separated keys. Put overwrites; Get returns
+// ErrNotFound for a missing key and Delete ignores one.
+type Store interface {
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	// URL returns where clients download the blob: a path served by
+	// Handler, or for object stores a public or presigned URL.
+	URL(key string) string
+}
+
+// ValidKey reports whether key is safe to use as a relative path: no empty,
+// "." or ".." segments and no leading slash.
+func ValidKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	for _, seg := range strings.Split(key, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// Handler serves blobs from store under the request path, which should have
+// the URL prefix stripped. Only images are shown inline; everything else is
+// served as a download so that uploads cannot run as pages of this origin.
+func Handler(store Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		key := strings.TrimPrefix(r.URL.Path, "/")
+		if !ValidKey(key) {
+			http.NotFound(w, r)
+			return
+		}
+
+		rc, err := store.Get(r.Context(), key)
+		if errors.Is(err, ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, "blob unavailable", http.StatusInternalServerError)
+			return
+		}
+		defer rc.Close()
+
+		contentType := mime.TypeByExtension(path.Ext(key))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		h := w.Header()
+		h.Set("Content-Type", contentType)
+		h.Set("X-Content-Type-Options", "nosniff")
+		// Keys are never reused, so blobs never change.
+		h.Set("Cache-Control", "public, max-age=31536000, immutable")
+		if !strings.HasPrefix(contentType, "image/") {
+			h.Set("Content-Disposition", "attachment")
+		}
+		if r.Method == http.MethodHead {
+			return
+		}
+		_, _ = io.Copy(w, rc)
+	})
+}
@@ -0,0 +1,78 @@
+package blob_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ozon-comments-graphql/internal/blob"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFS(t *testing.T) {
+	store, err := blob.NewFS(t.TempDir(), "/files")
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	require.NoError(t, store.Put(ctx, "a/b.txt", strings.NewReader("hello"), "text/plain"))
+	rc, err := store.Get(ctx, "a/b.txt")
+	require.NoError(t, err)
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, "hello", string(data))
+	assert.Equal(t, "/files/a/b.txt", store.URL("a/b.txt"))
+
+	require.NoError(t, store.Delete(ctx, "a/b.txt"))
+	require.NoError(t, store.Delete(ctx, "a/b.txt"))
+	_, err = store.Get(ctx, "a/b.txt")
+	assert.True(t, errors.Is(err, blob.ErrNotFound))
+
+	_, err = store.Get(ctx, "a")
+	assert.True(t, errors.Is(err, blob.ErrNotFound), "directories are not blobs")
+	assert.Error(t, store.Put(ctx, "../escape", strings.NewReader("x"), "text/plain"))
+}
+
+func TestValidKey(t *testing.T) {
+	for _, key := range []string{"a", "a/b.png", "attachments/1_thumb.jpg"} {
+		assert.True(t, blob.ValidKey(key), key)
+	}
+	for _, key := range []string{"", "/a", "a/../b", "..", "a//b", "a/", `a\b`, "./a"} {
+		assert.False(t, blob.ValidKey(key), key)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	store, err := blob.NewFS(t.TempDir(), "/")
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, store.Put(ctx, "x.png", strings.NewReader("png"), "image/png"))
+	require.NoError(t, store.Put(ctx, "x.pdf", strings.NewReader("pdf"), "application/pdf"))
+	h := blob.Handler(store)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/x.png", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "png", rec.Body.String())
+	assert.Equal(t, "image/png", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "nosniff", rec.Header().Get("X-Content-Type-Options"))
+	assert.Empty(t, rec.Header().Get("Content-Disposition"))
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/x.pdf", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "attachment", rec.Header().Get("Content-Disposition"))
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/missing.png", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/x.png", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
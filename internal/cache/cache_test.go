@@ -0,0 +1,165 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"ozon-comments-graphql/graph"
+	"ozon-comments-graphql/internal/cache"
+	"ozon-comments-graphql/internal/models"
+	"ozon-comments-graphql/internal/storage"
+
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorageInvalidation(t *testing.T) {
+	ctx := context.Background()
+	backend := storage.NewMemoryStorage()
+	store := cache.Storage(backend, 100, time.Minute)
+
+	post := store.CreatePost(ctx, "Title", "Content")
+	comments, _, _ := store.ListComments(ctx, post.ID, 10, nil)
+	assert.Empty(t, comments)
+
+	// Writes that bypass the cache are not seen until it is invalidated.
+	_, err := backend.CreateComment(ctx, post.ID, nil, nil, "hidden")
+	require.NoError(t, err)
+	comments, _, _ = store.ListComments(ctx, post.ID, 10, nil)
+	assert.Empty(t, comments)
+
+	_, err = store.CreateComment(ctx, post.ID, nil, nil, "visible")
+	require.NoError(t, err)
+	comments, _, _ = store.ListComments(ctx, post.ID, 10, nil)
+	assert.Len(t, comments, 2)
+
+	got, err := store.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, got.CommentCount)
+
+	_, _, err = store.ToggleComments(ctx, post.ID, true)
+	require.NoError(t, err)
+	disabled := true
+	posts, _, err := store.ListPosts(ctx, storage.ListPostsOptions{
+		Filter: storage.PostFilter{CommentsDisabled: &disabled},
+		First:  10,
+	})
+	require.NoError(t, err)
+	assert.Len(t, posts, 1)
+
+	store.CreatePost(ctx, "Second", "Content")
+	posts, _, err = store.ListPosts(ctx, storage.ListPostsOptions{First: 10})
+	require.NoError(t, err)
+	assert.Len(t, posts, 2)
+}
+
+func TestStorageTTL(t *testing.T) {
+	ctx := context.Background()
+	backend := storage.NewMemoryStorage()
+	store := cache.Storage(backend, 100, 20*time.Millisecond)
+
+	posts, _, err := store.ListPosts(ctx, storage.ListPostsOptions{First: 10})
+	require.NoError(t, err)
+	assert.Empty(t, posts)
+
+	backend.CreatePost(ctx, "Title", "Content")
+	assert.Eventually(t, func() bool {
+		posts, _, _ := store.ListPosts(ctx, storage.ListPostsOptions{First: 10})
+		return len(posts) == 1
+	}, time.Second, 10*time.Millisecond)
+}
+
+// flakyStorage fails ListComments until fail is cleared.
+type flakyStorage struct {
+	storage.Storage
+	fail bool
+}
+
+func (s *flakyStorage) ListComments(ctx context.Context, postID string, first int, afterID *string) ([]*models.Comment, *string, error) {
+	if s.fail {
+		return nil, nil, errors.New("connection refused")
+	}
+	return s.Storage.ListComments(ctx, postID, first, afterID)
+}
+
+func TestStorageDoesNotCacheErrors(t *testing.T) {
+	ctx := context.Background()
+	backend := &flakyStorage{Storage: storage.NewMemoryStorage(), fail: true}
+	store := cache.Storage(backend, 100, time.Minute)
+
+	post := store.CreatePost(ctx, "Title", "Content")
+	_, err := backend.CreateComment(ctx, post.ID, nil, nil, "hello")
+	require.NoError(t, err)
+
+	_, _, err = store.ListComments(ctx, post.ID, 10, nil)
+	assert.Error(t, err)
+
+	backend.fail = false
+	comments, _, err := store.ListComments(ctx, post.ID, 10, nil)
+	require.NoError(t, err)
+	assert.Len(t, comments, 1)
+}
+
+func TestCacheControlHeader(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	post := store.CreatePost(context.Background(), "Title", "Content")
+
+	srv := handler.New(graph.NewExecutableSchema(graph.Config{Resolvers: &graph.Resolver{
+		Store:  store,
+		Broker: graph.NewBroker(),
+	}}))
+	srv.AddTransport(transport.GET{})
+	srv.AddTransport(transport.POST{})
+	srv.Use(cache.GraphQL())
+	h := cache.Middleware(srv)
+
+	get := func(query string) string {
+		req := httptest.NewRequest(http.MethodGet, "/query?query="+url.QueryEscape(query), nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Header().Get("Cache-Control")
+	}
+
+	assert.Equal(t, "public, max-age=60", get(`{ post(id: "`+post.ID+`") { title } }`))
+	assert.Equal(t, "public, max-age=10", get(`{ post(id: "`+post.ID+`") { title commentCount } }`))
+	assert.Equal(t, "no-store", get(`{ __typename }`))
+	assert.Equal(t, "no-store", get(`{ post(id: "`+post.ID+`") { nope } }`))
+
+	req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"query":"{ posts { items { id } } }"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Empty(t, rec.Header().Get("Cache-Control"))
+}
+
+func TestStorageWithTx(t *testing.T) {
+	ctx := context.Background()
+	store := cache.Storage(storage.NewMemoryStorage(), 100, time.Minute)
+	post := store.CreatePost(ctx, "Title", "Content")
+
+	_, err := store.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+
+	err = store.WithTx(ctx, func(ctx context.Context) error {
+		if _, err := store.CreateComment(ctx, post.ID, nil, nil, "in tx"); err != nil {
+			return err
+		}
+		got, err := store.GetPost(ctx, post.ID)
+		require.NoError(t, err)
+		assert.Equal(t, 1, got.CommentCount, "reads inside the transaction bypass the cache")
+		return nil
+	})
+	require.NoError(t, err)
+
+	got, err := store.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, got.CommentCount)
+}
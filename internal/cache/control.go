@@ -0,0 +1,166 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+const directiveName = "cacheControl"
+
+// policy accumulates @cacheControl hints while a query executes. The
+// response may be cached for the smallest maxAge of any field it resolved.
+// Root fields without a hint are uncacheable; nested fields without one
+// inherit their parent's.
+type policy struct {
+	mu     sync.Mutex
+	maxAge int
+	set    bool
+}
+
+func (p *policy) restrict(maxAge int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.set || maxAge < p.maxAge {
+		p.maxAge = maxAge
+		p.set = true
+	}
+}
+
+func (p *policy) header() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.set || p.maxAge <= 0 {
+		return "no-store"
+	}
+	return "public, max-age=" + strconv.Itoa(p.maxAge)
+}
+
+type policyKey struct{}
+
+// Middleware sets Cache-Control on GET responses from the @cacheControl
+// hints of the fields the query resolved. Other methods are never cacheable
+// and pass through untouched.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		p := &policy{}
+		ctx := context.WithValue(r.Context(), policyKey{}, p)
+		next.ServeHTTP(&headerWriter{ResponseWriter: w, policy: p}, r.WithContext(ctx))
+	})
+}
+
+// headerWriter adds Cache-Control right before the response is written,
+// when execution has finished and every hint has been recorded.
+type headerWriter struct {
+	http.ResponseWriter
+	policy  *policy
+	written bool
+}
+
+func (w *headerWriter) WriteHeader(code int) {
+	if !w.written {
+		w.written = true
+		if code == http.StatusOK {
+			w.Header().Set("Cache-Control", w.policy.header())
+		} else {
+			w.Header().Set("Cache-Control", "no-store")
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *headerWriter) Write(b []byte) (int, error) {
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *headerWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+type graphqlExtension struct{}
+
+var (
+	_ graphql.HandlerExtension    = graphqlExtension{}
+	_ graphql.ResponseInterceptor = graphqlExtension{}
+	_ graphql.FieldInterceptor    = graphqlExtension{}
+)
+
+// GraphQL returns a gqlgen extension that records @cacheControl hints for
+// Middleware. Mutations and responses with errors are never cacheable.
+func GraphQL() graphql.HandlerExtension {
+	return graphqlExtension{}
+}
+
+func (graphqlExtension) ExtensionName() string {
+	return "CacheControl"
+}
+
+func (graphqlExtension) Validate(graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (graphqlExtension) InterceptResponse(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	p, ok := ctx.Value(policyKey{}).(*policy)
+	if !ok {
+		return next(ctx)
+	}
+
+	if graphql.HasOperationContext(ctx) {
+		oc := graphql.GetOperationContext(ctx)
+		if oc.Operation == nil || oc.Operation.Operation != ast.Query {
+			p.restrict(0)
+		}
+	}
+
+	resp := next(ctx)
+	if resp == nil || len(resp.Errors) > 0 {
+		p.restrict(0)
+	}
+	return resp
+}
+
+func (graphqlExtension) InterceptField(ctx context.Context, next graphql.Resolver) (interface{}, error) {
+	p, ok := ctx.Value(policyKey{}).(*policy)
+	fc := graphql.GetFieldContext(ctx)
+	if !ok || fc == nil {
+		return next(ctx)
+	}
+
+	if maxAge, ok := hint(fc.Field.Definition); ok {
+		p.restrict(maxAge)
+	} else if fc.Parent == nil || fc.Parent.Parent == nil {
+		p.restrict(0)
+	}
+	return next(ctx)
+}
+
+func hint(def *ast.FieldDefinition) (int, bool) {
+	if def == nil {
+		return 0, false
+	}
+	d := def.Directives.ForName(directiveName)
+	if d == nil {
+		return 0, false
+	}
+	arg := d.Arguments.ForName("maxAge")
+	if arg == nil || arg.Value == nil {
+		return 0, false
+	}
+	maxAge, err := strconv.Atoi(arg.Value.Raw)
+	if err != nil {
+		return 0, false
+	}
+	return maxAge, true
+}
@@ -0,0 +1,338 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ozon-comments-graphql/internal/models"
+	"ozon-comments-graphql/internal/storage"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+)
+
+// cachedStorage keeps recent reads in an LRU whose entries expire after a
+// TTL. Keys embed a version: writes bump the version of the post they touch
+// (or of post listings) instead of hunting down every affected key, and the
+// orphaned entries age out of the LRU on their own. The TTL bounds how stale
+// a replica can be when another one writes.
+type cachedStorage struct {
+	next    storage.Storage
+	entries *expirable.LRU[string, interface{}]
+
+	mu       sync.Mutex
+	versions map[string]uint64
+	listsVer atomic.Uint64
+}
+
+type postsPage struct {
+	posts []*models.Post
+	next  *string
+}
+
+type commentsPage struct {
+	comments []*models.Comment
+	next     *string
+}
+
+// Storage wraps s with a read cache of at most size entries, each living for
+// ttl.
+func Storage(s storage.Storage, size int, ttl time.Duration) storage.Storage {
+	return &cachedStorage{
+		next:     s,
+		entries:  expirable.NewLRU[string, interface{}](size, nil, ttl),
+		versions: make(map[string]uint64),
+	}
+}
+
+func (s *cachedStorage) postVersion(id string) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.versions[id]
+}
+
+// invalidate must be called after the write it reflects has completed, so a
+// concurrent reader never stores pre-write data under the new version.
+// Inside a transaction that is only once it commits, so writes there are
+// recorded and replayed by WithTx.
+func (s *cachedStorage) invalidate(ctx context.Context, postID string) {
+	if tx, ok := ctx.Value(txKey{}).(*pendingTx); ok && tx.owner == s {
+		tx.mu.Lock()
+		tx.posts = append(tx.posts, postID)
+		tx.mu.Unlock()
+		return
+	}
+	if postID != "" {
+		s.mu.Lock()
+		s.versions[postID]++
+		s.mu.Unlock()
+	}
+	s.listsVer.Add(1)
+}
+
+func (s *cachedStorage) CreatePost(ctx context.Context, title, content string) *models.Post {
+	p := s.next.CreatePost(ctx, title, content)
+	s.invalidate(ctx, "")
+	return p
+}
+
+func (s *cachedStorage) UpdatePost(ctx context.Context, id string, title, content *string) (*models.Post, error) {
+	defer s.invalidate(ctx, id)
+	return s.next.UpdatePost(ctx, id, title, content)
+}
+
+func (s *cachedStorage) DeletePost(ctx context.Context, id string, soft bool) (*models.Post, error) {
+	defer s.invalidate(ctx, id)
+	return s.next.DeletePost(ctx, id, soft)
+}
+
+func (s *cachedStorage) ToggleComments(ctx context.Context, id string, disabled bool) (*models.Post, bool, error) {
+	defer s.invalidate(ctx, id)
+	return s.next.ToggleComments(ctx, id, disabled)
+}
+
+func (s *cachedStorage) ListPosts(ctx context.Context, opts storage.ListPostsOptions) ([]*models.Post, *string, error) {
+	if s.inTx(ctx) {
+		return s.next.ListPosts(ctx, opts)
+	}
+	key := fmt.Sprintf("posts:%d:%s", s.listsVer.Load(), listPostsKey(opts))
+	if v, ok := s.entries.Get(key); ok {
+		page := v.(postsPage)
+		return page.posts, page.next, nil
+	}
+
+	posts, next, err := s.next.ListPosts(ctx, opts)
+	if err == nil {
+		s.entries.Add(key, postsPage{posts: posts, next: next})
+	}
+	return posts, next, err
+}
+
+func (s *cachedStorage) GetPost(ctx context.Context, id string) (*models.Post, error) {
+	if s.inTx(ctx) {
+		return s.next.GetPost(ctx, id)
+	}
+	key := fmt.Sprintf("post:%s:%d", id, s.postVersion(id))
+	if v, ok := s.entries.Get(key); ok {
+		return v.(*models.Post), nil
+	}
+
+	p, err := s.next.GetPost(ctx, id)
+	if err == nil {
+		s.entries.Add(key, p)
+	}
+	return p, err
+}
+
+func (s *cachedStorage) CreateComment(ctx context.Context, postID string, parentID, author *string, content string) (*models.Comment, error) {
+	c, err := s.next.CreateComment(ctx, postID, parentID, author, content)
+	if err == nil {
+		s.invalidate(ctx, postID)
+	}
+	return c, err
+}
+
+func (s *cachedStorage) ListComments(ctx context.Context, postID string, first int, afterID *string) ([]*models.Comment, *string, error) {
+	if s.inTx(ctx) {
+		return s.next.ListComments(ctx, postID, first, afterID)
+	}
+	key := fmt.Sprintf("comments:%s:%d:%d:%s", postID, s.postVersion(postID), first, deref(afterID))
+	if v, ok := s.entries.Get(key); ok {
+		page := v.(commentsPage)
+		return page.comments, page.next, nil
+	}
+
+	comments, next, err := s.next.ListComments(ctx, postID, first, afterID)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.entries.Add(key, commentsPage{comments: comments, next: next})
+	return comments, next, nil
+}
+
+func (s *cachedStorage) GetComment(ctx context.Context, id string) (*models.Comment, error) {
+	return s.next.GetComment(ctx, id)
+}
+
+func (s *cachedStorage) CommentAncestry(ctx context.Context, id string, limit int) ([]*models.Comment, error) {
+	return s.next.CommentAncestry(ctx, id, limit)
+}
+
+func (s *cachedStorage) GetPersistedQuery(ctx context.Context, hash string) (string, error) {
+	return s.next.GetPersistedQuery(ctx, hash)
+}
+
+func (s *cachedStorage) SavePersistedQuery(ctx context.Context, hash, query string) error {
+	return s.next.SavePersistedQuery(ctx, hash, query)
+}
+
+func (s *cachedStorage) PrunePersistedQueries(ctx context.Context, savedBefore time.Time) (int, error) {
+	return s.next.PrunePersistedQueries(ctx, savedBefore)
+}
+
+func (s *cachedStorage) ExportPosts(ctx context.Context, fn func(*models.Post) error) error {
+	return s.next.ExportPosts(ctx, fn)
+}
+
+func (s *cachedStorage) ExportComments(ctx context.Context, fn func(*models.Comment) error) error {
+	return s.next.ExportComments(ctx, fn)
+}
+
+func (s *cachedStorage) ImportPost(ctx context.Context, p *models.Post) error {
+	defer s.invalidate(ctx, p.ID)
+	return s.next.ImportPost(ctx, p)
+}
+
+func (s *cachedStorage) ImportComment(ctx context.Context, c *models.Comment) error {
+	defer s.invalidate(ctx, c.PostID)
+	return s.next.ImportComment(ctx, c)
+}
+
+func (s *cachedStorage) AddOutboxEvent(ctx context.Context, e *storage.OutboxEvent) error {
+	return s.next.AddOutboxEvent(ctx, e)
+}
+
+func (s *cachedStorage) PendingOutboxEvents(ctx context.Context, limit int) ([]*storage.OutboxEvent, error) {
+	return s.next.PendingOutboxEvents(ctx, limit)
+}
+
+func (s *cachedStorage) ClaimOutboxEvents(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*storage.OutboxEvent, error) {
+	return s.next.ClaimOutboxEvents(ctx, now, lease, limit)
+}
+
+func (s *cachedStorage) ReleaseOutboxEvents(ctx context.Context, ids []string) error {
+	return s.next.ReleaseOutboxEvents(ctx, ids)
+}
+
+func (s *cachedStorage) MarkOutboxDelivered(ctx context.Context, ids []string) error {
+	return s.next.MarkOutboxDelivered(ctx, ids)
+}
+
+func (s *cachedStorage) PruneOutbox(ctx context.Context, deliveredBefore time.Time) (int, error) {
+	return s.next.PruneOutbox(ctx, deliveredBefore)
+}
+
+func (s *cachedStorage) AddNotification(ctx context.Context, n *models.Notification) error {
+	return s.next.AddNotification(ctx, n)
+}
+
+func (s *cachedStorage) ListNotifications(ctx context.Context, user string, opts storage.ListNotificationsOptions) ([]*models.Notification, *string, error) {
+	return s.next.ListNotifications(ctx, user, opts)
+}
+
+func (s *cachedStorage) MarkNotificationsRead(ctx context.Context, user string, ids []string) (int, error) {
+	return s.next.MarkNotificationsRead(ctx, user, ids)
+}
+
+func (s *cachedStorage) AddAttachment(ctx context.Context, a *models.Attachment) error {
+	return s.next.AddAttachment(ctx, a)
+}
+
+func (s *cachedStorage) ListAttachments(ctx context.Context, commentID string) ([]*models.Attachment, error) {
+	return s.next.ListAttachments(ctx, commentID)
+}
+
+func (s *cachedStorage) ListAttachmentsFor(ctx context.Context, commentIDs []string) (map[string][]*models.Attachment, error) {
+	return s.next.ListAttachmentsFor(ctx, commentIDs)
+}
+
+func (s *cachedStorage) ListPostComments(ctx context.Context, postID string) ([]*models.Comment, error) {
+	return s.next.ListPostComments(ctx, postID)
+}
+
+func (s *cachedStorage) ListPostAttachments(ctx context.Context, postID string) ([]*models.Attachment, error) {
+	return s.next.ListPostAttachments(ctx, postID)
+}
+
+func (s *cachedStorage) CreateWebhook(ctx context.Context, w *models.Webhook) error {
+	return s.next.CreateWebhook(ctx, w)
+}
+
+func (s *cachedStorage) ListWebhooks(ctx context.Context) ([]*models.Webhook, error) {
+	return s.next.ListWebhooks(ctx)
+}
+
+func (s *cachedStorage) AddWebhookDelivery(ctx context.Context, d *models.WebhookDelivery) error {
+	return s.next.AddWebhookDelivery(ctx, d)
+}
+
+func (s *cachedStorage) ClaimWebhookDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*models.WebhookDelivery, error) {
+	return s.next.ClaimWebhookDeliveries(ctx, now, lease, limit)
+}
+
+func (s *cachedStorage) UpdateWebhookDelivery(ctx context.Context, d *models.WebhookDelivery) error {
+	return s.next.UpdateWebhookDelivery(ctx, d)
+}
+
+func (s *cachedStorage) ListWebhookDeliveries(ctx context.Context, filter storage.WebhookDeliveryFilter, limit int) ([]*models.WebhookDelivery, error) {
+	return s.next.ListWebhookDeliveries(ctx, filter, limit)
+}
+
+func (s *cachedStorage) PruneWebhookDeliveries(ctx context.Context, finishedBefore time.Time) (int, error) {
+	return s.next.PruneWebhookDeliveries(ctx, finishedBefore)
+}
+
+type txKey struct{}
+
+// pendingTx collects the posts written inside a transaction.
+type pendingTx struct {
+	owner *cachedStorage
+	mu    sync.Mutex
+	posts []string
+}
+
+func (s *cachedStorage) inTx(ctx context.Context) bool {
+	tx, ok := ctx.Value(txKey{}).(*pendingTx)
+	return ok && tx.owner == s
+}
+
+// WithTx bypasses the cache for reads inside the transaction, which must see
+// its own uncommitted writes, and invalidates what it wrote once it is over.
+func (s *cachedStorage) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if s.inTx(ctx) {
+		return s.next.WithTx(ctx, fn)
+	}
+
+	tx := &pendingTx{owner: s}
+	err := s.next.WithTx(context.WithValue(ctx, txKey{}, tx), fn)
+	for _, id := range tx.posts {
+		s.invalidate(ctx, id)
+	}
+	return err
+}
+
+func (s *cachedStorage) Health(ctx context.Context) error {
+	return s.next.Health(ctx)
+}
+
+func (s *cachedStorage) Close() {
+	s.entries.Purge()
+	s.next.Close()
+}
+
+func listPostsKey(opts storage.ListPostsOptions) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d:%d:%s|", opts.OrderBy, opts.First, deref(opts.After))
+	f := opts.Filter
+	if f.CommentsDisabled != nil {
+		fmt.Fprintf(&b, "disabled=%t|", *f.CommentsDisabled)
+	}
+	if f.CreatedAfter != nil {
+		fmt.Fprintf(&b, "after=%d|", f.CreatedAfter.UnixNano())
+	}
+	if f.CreatedBefore != nil {
+		fmt.Fprintf(&b, "before=%d|", f.CreatedBefore.UnixNano())
+	}
+	fmt.Fprintf(&b, "title=%q", f.TitleContains)
+	return b.String()
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,332 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	StorageMemory   = "memory"
+	StoragePostgres = "postgres"
+
+	PersistedQueriesAPQ       = "apq"
+	PersistedQueriesAllowList = "allowlist"
+)
+
+type Config struct {
+	Server        ServerConfig        `yaml:"server"`
+	Storage       StorageConfig       `yaml:"storage"`
+	Comments      CommentsConfig      `yaml:"comments"`
+	Attachments   AttachmentsConfig   `yaml:"attachments"`
+	GraphQL       GraphQLConfig       `yaml:"graphql"`
+	Cache         CacheConfig         `yaml:"cache"`
+	Broker        BrokerConfig        `yaml:"broker"`
+	Outbox        OutboxConfig        `yaml:"outbox"`
+	Webhooks      WebhooksConfig      `yaml:"webhooks"`
+	Subscriptions SubscriptionsConfig `yaml:"subscriptions"`
+	Websocket     WebsocketConfig     `yaml:"websocket"`
+	SSE           SSEConfig           `yaml:"sse"`
+	CORS          CORSConfig          `yaml:"cors"`
+	Log           LogConfig           `yaml:"log"`
+	Tracing       TracingConfig       `yaml:"tracing"`
+}
+
+type ServerConfig struct {
+	Port            string        `yaml:"port"`
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+	ShutdownDrain   time.Duration `yaml:"shutdownDrain"`
+	// AdminToken authorizes webhook management and /admin/data. Empty
+	// disables them.
+	AdminToken string `yaml:"adminToken"`
+}
+
+type StorageConfig struct {
+	Type         string `yaml:"type"`
+	DatabaseURL  string `yaml:"databaseURL"`
+	PoolMinConns int32  `yaml:"poolMinConns"`
+	PoolMaxConns int32  `yaml:"poolMaxConns"`
+}
+
+type CommentsConfig struct {
+	MaxLength       int `yaml:"maxLength"`
+	DefaultPageSize int `yaml:"defaultPageSize"`
+	MaxPageSize     int `yaml:"maxPageSize"`
+}
+
+// AttachmentsConfig controls files uploaded with comments. They are kept
+// below Dir and served at /attachments/.
+type AttachmentsConfig struct {
+	Dir           string `yaml:"dir"`
+	MaxSize       int64  `yaml:"maxSize"`
+	MaxPerComment int    `yaml:"maxPerComment"`
+}
+
+type GraphQLConfig struct {
+	QueryCacheSize int `yaml:"queryCacheSize"`
+	APQCacheSize   int `yaml:"apqCacheSize"`
+	// SharedAPQ keeps automatic persisted queries in storage so that every
+	// replica behind a load balancer knows hashes registered on any of them.
+	SharedAPQ bool `yaml:"sharedAPQ"`
+	// SharedAPQTTL is how long a query stays in storage after it was
+	// registered.
+	SharedAPQTTL time.Duration `yaml:"sharedAPQTTL"`
+	// PersistedQueries is apq (any query, hashes optional) or allowlist
+	// (only operations from Manifest are executed).
+	PersistedQueries string `yaml:"persistedQueries"`
+	Manifest         string `yaml:"manifest"`
+}
+
+// CacheConfig controls the storage read cache. It is off by default: with
+// several replicas each one may serve data up to TTL old.
+type CacheConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Size    int           `yaml:"size"`
+	TTL     time.Duration `yaml:"ttl"`
+}
+
+type BrokerConfig struct {
+	BufferSize int `yaml:"bufferSize"`
+	// ActivityInterval is the minimum time between two postActivity
+	// updates for the same post.
+	ActivityInterval time.Duration `yaml:"activityInterval"`
+	// TypingTTL is how long a typing indicator lasts after the last
+	// startTyping for it.
+	TypingTTL time.Duration `yaml:"typingTTL"`
+	// TypingInterval is the minimum time between startTyping calls from one
+	// client address once its short burst is used up; zero disables the
+	// limit.
+	TypingInterval time.Duration `yaml:"typingInterval"`
+}
+
+type OutboxConfig struct {
+	PollInterval time.Duration `yaml:"pollInterval"`
+	BatchSize    int           `yaml:"batchSize"`
+	Retention    time.Duration `yaml:"retention"`
+}
+
+type WebhooksConfig struct {
+	MaxAttempts int `yaml:"maxAttempts"`
+	// Backoff is the delay before the first retry; it doubles after every
+	// further failure up to BackoffMax.
+	Backoff    time.Duration `yaml:"backoff"`
+	BackoffMax time.Duration `yaml:"backoffMax"`
+	Timeout    time.Duration `yaml:"timeout"`
+	// Retention is how long delivered and dead deliveries are kept.
+	Retention time.Duration `yaml:"retention"`
+	// AllowPrivate permits webhook URLs on loopback and private networks.
+	AllowPrivate bool `yaml:"allowPrivate"`
+}
+
+// SubscriptionsConfig caps subscriptions; zero disables a limit.
+type SubscriptionsConfig struct {
+	Max              int           `yaml:"max"`
+	MaxPerConnection int           `yaml:"maxPerConnection"`
+	MaxPerPost       int           `yaml:"maxPerPost"`
+	MaxLifetime      time.Duration `yaml:"maxLifetime"`
+}
+
+type WebsocketConfig struct {
+	KeepAlive time.Duration `yaml:"keepAlive"`
+	// IdleTimeout closes connections that have had no subscriptions, or
+	// have not sent connection_init, for this long. Zero disables it.
+	IdleTimeout time.Duration `yaml:"idleTimeout"`
+}
+
+type SSEConfig struct {
+	KeepAlive time.Duration `yaml:"keepAlive"`
+}
+
+type CORSConfig struct {
+	AllowedOrigins []string `yaml:"allowedOrigins"`
+}
+
+type LogConfig struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+type TracingConfig struct {
+	Exporter     string  `yaml:"exporter"`
+	OTLPEndpoint string  `yaml:"otlpEndpoint"`
+	File         string  `yaml:"file"`
+	SampleRatio  float64 `yaml:"sampleRatio"`
+}
+
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Port:            "8080",
+			ShutdownTimeout: 30 * time.Second,
+			ShutdownDrain:   5 * time.Second,
+		},
+		Storage: StorageConfig{
+			Type:         StorageMemory,
+			PoolMinConns: 0,
+			PoolMaxConns: 10,
+		},
+		Comments: CommentsConfig{
+			MaxLength:       2000,
+			DefaultPageSize: 10,
+			MaxPageSize:     100,
+		},
+		Attachments: AttachmentsConfig{
+			Dir:           "data/attachments",
+			MaxSize:       10 << 20,
+			MaxPerComment: 5,
+		},
+		GraphQL: GraphQLConfig{
+			QueryCacheSize:   1000,
+			APQCacheSize:     100,
+			SharedAPQTTL:     24 * time.Hour,
+			PersistedQueries: PersistedQueriesAPQ,
+		},
+		Cache: CacheConfig{
+			Size: 10000,
+			TTL:  5 * time.Second,
+		},
+		Broker: BrokerConfig{
+			BufferSize:       1,
+			ActivityInterval: time.Second,
+			TypingTTL:        5 * time.Second,
+			TypingInterval:   time.Second,
+		},
+		Outbox: OutboxConfig{
+			PollInterval: time.Second,
+			BatchSize:    100,
+			Retention:    24 * time.Hour,
+		},
+		Webhooks: WebhooksConfig{
+			MaxAttempts: 8,
+			Backoff:     5 * time.Second,
+			BackoffMax:  time.Hour,
+			Timeout:     10 * time.Second,
+			Retention:   7 * 24 * time.Hour,
+		},
+		Subscriptions: SubscriptionsConfig{
+			Max:              10000,
+			MaxPerConnection: 100,
+			MaxPerPost:       1000,
+			MaxLifetime:      24 * time.Hour,
+		},
+		Websocket: WebsocketConfig{
+			KeepAlive:   10 * time.Second,
+			IdleTimeout: 5 * time.Minute,
+		},
+		SSE: SSEConfig{
+			KeepAlive: 10 * time.Second,
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		Tracing: TracingConfig{
+			SampleRatio: 1,
+		},
+	}
+}
+
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	port, err := strconv.Atoi(c.Server.Port)
+	check(err == nil && port > 0 && port < 65536, "port: %q is not a valid TCP port", c.Server.Port)
+	check(c.Server.ShutdownTimeout > 0, "shutdown-timeout: must be positive")
+	check(c.Server.ShutdownDrain >= 0, "shutdown-drain: must not be negative")
+
+	switch c.Storage.Type {
+	case StorageMemory:
+	case StoragePostgres:
+		check(c.Storage.DatabaseURL != "", "database-url: required for postgres storage")
+	default:
+		check(false, "storage-type: unknown %q, want %q or %q", c.Storage.Type, StorageMemory, StoragePostgres)
+	}
+	check(c.Storage.PoolMaxConns > 0, "pool-max-conns: must be positive")
+	check(c.Storage.PoolMinConns >= 0 && c.Storage.PoolMinConns <= c.Storage.PoolMaxConns,
+		"pool-min-conns: must be between 0 and pool-max-conns")
+
+	check(c.Comments.MaxLength > 0, "comment-max-length: must be positive")
+	check(c.Comments.MaxPageSize > 0, "max-page-size: must be positive")
+	check(c.Comments.DefaultPageSize > 0 && c.Comments.DefaultPageSize <= c.Comments.MaxPageSize,
+		"default-page-size: must be between 1 and max-page-size")
+	check(c.Attachments.Dir != "", "attachments-dir: required")
+	check(c.Attachments.MaxSize > 0, "attachments-max-size: must be positive")
+	check(c.Attachments.MaxPerComment > 0, "attachments-max-per-comment: must be positive")
+
+	check(c.GraphQL.QueryCacheSize > 0, "query-cache-size: must be positive")
+	check(c.GraphQL.APQCacheSize > 0, "apq-cache-size: must be positive")
+	if c.GraphQL.SharedAPQ {
+		check(c.GraphQL.SharedAPQTTL > 0, "apq-shared-ttl: must be positive")
+	}
+	switch c.GraphQL.PersistedQueries {
+	case PersistedQueriesAPQ:
+	case PersistedQueriesAllowList:
+		check(c.GraphQL.Manifest != "", "persisted-queries-manifest: required in allowlist mode")
+	default:
+		check(false, "persisted-queries: unknown %q, want %q or %q",
+			c.GraphQL.PersistedQueries, PersistedQueriesAPQ, PersistedQueriesAllowList)
+	}
+	if c.Cache.Enabled {
+		check(c.Cache.Size > 0, "response-cache-size: must be positive")
+		check(c.Cache.TTL > 0, "response-cache-ttl: must be positive")
+	}
+	check(c.Broker.BufferSize > 0, "broker-buffer-size: must be positive")
+	check(c.Broker.ActivityInterval > 0, "broker-activity-interval: must be positive")
+	check(c.Broker.TypingTTL > 0, "broker-typing-ttl: must be positive")
+	check(c.Broker.TypingInterval >= 0, "broker-typing-interval: must not be negative")
+	check(c.Outbox.PollInterval > 0, "outbox-poll-interval: must be positive")
+	check(c.Outbox.BatchSize > 0, "outbox-batch-size: must be positive")
+	check(c.Outbox.Retention > 0, "outbox-retention: must be positive")
+	check(c.Webhooks.MaxAttempts > 0, "webhook-max-attempts: must be positive")
+	check(c.Webhooks.Backoff > 0, "webhook-backoff: must be positive")
+	check(c.Webhooks.BackoffMax >= c.Webhooks.Backoff, "webhook-backoff-max: must not be less than webhook-backoff")
+	check(c.Webhooks.Retention > 0, "webhook-retention: must be positive")
+	check(c.Webhooks.Timeout > 0, "webhook-timeout: must be positive")
+	check(c.Subscriptions.Max >= 0, "subscriptions-max: must not be negative")
+	check(c.Subscriptions.MaxPerConnection >= 0, "subscriptions-max-per-connection: must not be negative")
+	check(c.Subscriptions.MaxPerPost >= 0, "subscriptions-max-per-post: must not be negative")
+	check(c.Subscriptions.MaxLifetime >= 0, "subscriptions-max-lifetime: must not be negative")
+	check(c.Websocket.KeepAlive > 0, "ws-keepalive: must be positive")
+	check(c.Websocket.IdleTimeout >= 0, "ws-idle-timeout: must not be negative")
+	check(c.SSE.KeepAlive > 0, "sse-keepalive: must be positive")
+
+	for _, origin := range c.CORS.AllowedOrigins {
+		check(validOrigin(origin), "cors-allowed-origins: invalid origin %q", origin)
+	}
+
+	var lvl slog.Level
+	check(lvl.UnmarshalText([]byte(c.Log.Level)) == nil, "log-level: unknown %q", c.Log.Level)
+	check(c.Log.Format == "text" || c.Log.Format == "json", "log-format: unknown %q, want text or json", c.Log.Format)
+
+	switch c.Tracing.Exporter {
+	case "", "otlp":
+	case "file":
+		check(c.Tracing.File != "", "trace-file: required for the file exporter")
+	default:
+		check(false, "trace-exporter: unknown %q, want otlp or file", c.Tracing.Exporter)
+	}
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "trace-sample-ratio: must be between 0 and 1")
+
+	return errors.Join(errs...)
+}
+
+func validOrigin(origin string) bool {
+	if origin == "*" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+		return false
+	}
+	return !strings.Contains(strings.TrimPrefix(u.Host, "*."), "*")
+}
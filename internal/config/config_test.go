@@ -0,0 +1,105 @@
+package config_test
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ozon-comments-graphql/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+	return path
+}
+
+// clearEnv unsets every variable Load reads for the rest of the test, so the
+// tests do not depend on the environment they are run in.
+func clearEnv(t *testing.T) {
+	t.Helper()
+	names := []string{"CONFIG_FILE"}
+	_, _, err := config.LoadArgs("test", []string{"-h"}, func(fs *flag.FlagSet) {
+		fs.SetOutput(io.Discard)
+		fs.VisitAll(func(f *flag.Flag) { names = append(names, config.EnvName(f.Name)) })
+	})
+	require.ErrorIs(t, err, flag.ErrHelp)
+	for _, name := range names {
+		// t.Setenv restores the original value once the test is done; the
+		// variable is then unset so that a .env file can still provide it.
+		t.Setenv(name, "")
+		require.NoError(t, os.Unsetenv(name))
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	clearEnv(t)
+	cfg, err := config.Load("test", []string{"-env-file", writeFile(t, ".env", "")})
+	require.NoError(t, err)
+	assert.Equal(t, config.Default(), cfg)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, "config.yaml", `
+server:
+  port: "9000"
+  shutdownTimeout: 1m
+comments:
+  maxLength: 500
+broker:
+  bufferSize: 4
+cors:
+  allowedOrigins: ["https://a.example.com"]
+`)
+	envFile := writeFile(t, ".env", "COMMENT_MAX_LENGTH=700\nBROKER_BUFFER_SIZE=6\n")
+	clearEnv(t)
+	t.Setenv("BROKER_BUFFER_SIZE", "8")
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://*.example.com, https://other.org")
+
+	cfg, err := config.Load("test", []string{"-config", file, "-env-file", envFile, "-port", "9100"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "9100", cfg.Server.Port)                 // flag beats file
+	assert.Equal(t, time.Minute, cfg.Server.ShutdownTimeout) // file beats default
+	assert.Equal(t, 700, cfg.Comments.MaxLength)             // .env beats file
+	assert.Equal(t, 8, cfg.Broker.BufferSize)                // env beats .env
+	assert.Equal(t, []string{"https://*.example.com", "https://other.org"}, cfg.CORS.AllowedOrigins)
+}
+
+func TestLoadRejectsUnknownFileKeys(t *testing.T) {
+	clearEnv(t)
+	file := writeFile(t, "config.yaml", "server:\n  prot: \"9000\"\n")
+	_, err := config.Load("test", []string{"-config", file, "-env-file", writeFile(t, ".env", "")})
+	assert.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	cfg := config.Default()
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Storage.Type = "mongo"
+	assert.ErrorContains(t, cfg.Validate(), "storage-type")
+
+	cfg = config.Default()
+	cfg.Storage.Type = config.StoragePostgres
+	assert.ErrorContains(t, cfg.Validate(), "database-url")
+
+	cfg = config.Default()
+	cfg.Comments.DefaultPageSize = 500
+	assert.ErrorContains(t, cfg.Validate(), "default-page-size")
+
+	cfg = config.Default()
+	cfg.CORS.AllowedOrigins = []string{"example.com"}
+	assert.ErrorContains(t, cfg.Validate(), "cors-allowed-origins")
+
+	cfg = config.Default()
+	cfg.GraphQL.PersistedQueries = config.PersistedQueriesAllowList
+	assert.ErrorContains(t, cfg.Validate(), "persisted-queries-manifest")
+}
@@ -0,0 +1,184 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+const defaultEnvFile = ".env"
+
+// Load builds the configuration from, in increasing order of precedence:
+// defaults, a YAML file (-config or CONFIG_FILE), the .env file, process
+// environment variables and command-line flags. Every setting's environment
+// variable is its flag name upper-cased with dashes turned into underscores,
+// e.g. -storage-type and STORAGE_TYPE.
+func Load(name string, args []string) (*Config, error) {
+	cfg, _, err := LoadArgs(name, args, nil)
+	return cfg, err
+}
+
+// LoadArgs is Load for subcommands: extra, when not nil, registers the
+// subcommand's own flags, and the positional arguments left after the flags
+// are returned.
+func LoadArgs(name string, args []string, extra func(fs *flag.FlagSet)) (*Config, []string, error) {
+	// Parse flags into a scratch config first: they have the highest
+	// precedence, so they are applied last.
+	parsed := flag.NewFlagSet(name, flag.ContinueOnError)
+	configFile := parsed.String("config", "", "path to a YAML config file (env CONFIG_FILE)")
+	envFile := parsed.String("env-file", "", "path to a .env file (default .env if present)")
+	bind(parsed, Default())
+	if extra != nil {
+		extra(parsed)
+	}
+	if err := parsed.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if err := loadEnvFile(*envFile); err != nil {
+		return nil, nil, err
+	}
+
+	cfg := Default()
+
+	path := *configFile
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		if err := loadFile(cfg, path); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	fields := flag.NewFlagSet(name, flag.ContinueOnError)
+	bind(fields, cfg)
+
+	var errs []error
+	fields.VisitAll(func(f *flag.Flag) {
+		if v := os.Getenv(EnvName(f.Name)); v != "" {
+			if err := f.Value.Set(v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", EnvName(f.Name), err))
+			}
+		}
+	})
+	parsed.Visit(func(f *flag.Flag) {
+		if fields.Lookup(f.Name) == nil {
+			return
+		}
+		if err := fields.Set(f.Name, f.Value.String()); err != nil {
+			errs = append(errs, fmt.Errorf("-%s: %w", f.Name, err))
+		}
+	})
+	if err := errors.Join(errs...); err != nil {
+		return nil, nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return cfg, parsed.Args(), nil
+}
+
+// EnvName maps a flag name to its environment variable.
+func EnvName(flagName string) string {
+	return strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+func loadEnvFile(path string) error {
+	if path == "" {
+		err := godotenv.Load(defaultEnvFile)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if err := godotenv.Load(path); err != nil {
+		return fmt.Errorf("env file: %w", err)
+	}
+	return nil
+}
+
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// bind registers a flag for every setting, writing into cfg.
+func bind(fs *flag.FlagSet, cfg *Config) {
+	fs.StringVar(&cfg.Server.Port, "port", cfg.Server.Port, "HTTP listen port")
+	fs.DurationVar(&cfg.Server.ShutdownTimeout, "shutdown-timeout", cfg.Server.ShutdownTimeout, "overall graceful shutdown limit")
+	fs.DurationVar(&cfg.Server.ShutdownDrain, "shutdown-drain", cfg.Server.ShutdownDrain, "pause between completing subscriptions and closing websockets")
+	fs.StringVar(&cfg.Server.AdminToken, "admin-token", cfg.Server.AdminToken, "bearer token for admin operations: webhook management and /admin/data")
+
+	fs.StringVar(&cfg.Storage.Type, "storage-type", cfg.Storage.Type, "storage backend: memory or postgres")
+	fs.StringVar(&cfg.Storage.DatabaseURL, "database-url", cfg.Storage.DatabaseURL, "PostgreSQL connection string")
+	int32Var(fs, &cfg.Storage.PoolMinConns, "pool-min-conns", "minimum PostgreSQL pool connections")
+	int32Var(fs, &cfg.Storage.PoolMaxConns, "pool-max-conns", "maximum PostgreSQL pool connections")
+
+	fs.IntVar(&cfg.Comments.MaxLength, "comment-max-length", cfg.Comments.MaxLength, "maximum comment length in bytes")
+	fs.IntVar(&cfg.Comments.DefaultPageSize, "default-page-size", cfg.Comments.DefaultPageSize, "page size when first is omitted")
+	fs.IntVar(&cfg.Comments.MaxPageSize, "max-page-size", cfg.Comments.MaxPageSize, "upper bound for first")
+	fs.StringVar(&cfg.Attachments.Dir, "attachments-dir", cfg.Attachments.Dir, "directory comment attachments are stored in")
+	fs.Int64Var(&cfg.Attachments.MaxSize, "attachments-max-size", cfg.Attachments.MaxSize, "maximum size of one attachment in bytes")
+	fs.IntVar(&cfg.Attachments.MaxPerComment, "attachments-max-per-comment", cfg.Attachments.MaxPerComment, "maximum attachments per comment")
+
+	fs.IntVar(&cfg.GraphQL.QueryCacheSize, "query-cache-size", cfg.GraphQL.QueryCacheSize, "parsed query LRU size")
+	fs.IntVar(&cfg.GraphQL.APQCacheSize, "apq-cache-size", cfg.GraphQL.APQCacheSize, "automatic persisted query LRU size")
+	fs.BoolVar(&cfg.GraphQL.SharedAPQ, "apq-shared", cfg.GraphQL.SharedAPQ, "store automatic persisted queries in storage, shared by replicas")
+	fs.DurationVar(&cfg.GraphQL.SharedAPQTTL, "apq-shared-ttl", cfg.GraphQL.SharedAPQTTL, "how long a shared persisted query is kept")
+	fs.StringVar(&cfg.GraphQL.PersistedQueries, "persisted-queries", cfg.GraphQL.PersistedQueries, "apq or allowlist")
+	fs.StringVar(&cfg.GraphQL.Manifest, "persisted-queries-manifest", cfg.GraphQL.Manifest, "operation manifest for allowlist mode")
+
+	fs.BoolVar(&cfg.Cache.Enabled, "response-cache", cfg.Cache.Enabled, "cache storage reads in memory")
+	fs.IntVar(&cfg.Cache.Size, "response-cache-size", cfg.Cache.Size, "maximum cached reads")
+	fs.DurationVar(&cfg.Cache.TTL, "response-cache-ttl", cfg.Cache.TTL, "how long a cached read lives")
+
+	fs.IntVar(&cfg.Broker.BufferSize, "broker-buffer-size", cfg.Broker.BufferSize, "per-subscriber event buffer")
+	fs.DurationVar(&cfg.Broker.ActivityInterval, "broker-activity-interval", cfg.Broker.ActivityInterval, "minimum time between postActivity updates of a post")
+	fs.DurationVar(&cfg.Broker.TypingTTL, "broker-typing-ttl", cfg.Broker.TypingTTL, "how long a typing indicator lasts without a new startTyping")
+	fs.DurationVar(&cfg.Broker.TypingInterval, "broker-typing-interval", cfg.Broker.TypingInterval, "minimum time between startTyping calls from one client address, 0 for no limit")
+	fs.DurationVar(&cfg.Outbox.PollInterval, "outbox-poll-interval", cfg.Outbox.PollInterval, "how often the event outbox is polled")
+	fs.IntVar(&cfg.Outbox.BatchSize, "outbox-batch-size", cfg.Outbox.BatchSize, "events claimed per outbox relay batch")
+	fs.DurationVar(&cfg.Outbox.Retention, "outbox-retention", cfg.Outbox.Retention, "how long delivered outbox events are kept")
+	fs.IntVar(&cfg.Webhooks.MaxAttempts, "webhook-max-attempts", cfg.Webhooks.MaxAttempts, "webhook delivery attempts before it is dead-lettered")
+	fs.DurationVar(&cfg.Webhooks.Backoff, "webhook-backoff", cfg.Webhooks.Backoff, "delay before the first webhook retry, doubled after each failure")
+	fs.DurationVar(&cfg.Webhooks.BackoffMax, "webhook-backoff-max", cfg.Webhooks.BackoffMax, "upper bound of the webhook retry delay")
+	fs.DurationVar(&cfg.Webhooks.Timeout, "webhook-timeout", cfg.Webhooks.Timeout, "timeout of a single webhook delivery attempt")
+	fs.DurationVar(&cfg.Webhooks.Retention, "webhook-retention", cfg.Webhooks.Retention, "how long delivered and dead webhook deliveries are kept")
+	fs.BoolVar(&cfg.Webhooks.AllowPrivate, "webhook-allow-private", cfg.Webhooks.AllowPrivate, "allow webhook URLs on loopback and private networks")
+	fs.IntVar(&cfg.Subscriptions.Max, "subscriptions-max", cfg.Subscriptions.Max, "maximum active subscriptions, 0 for no limit")
+	fs.IntVar(&cfg.Subscriptions.MaxPerConnection, "subscriptions-max-per-connection", cfg.Subscriptions.MaxPerConnection, "maximum subscriptions per websocket connection, 0 for no limit")
+	fs.IntVar(&cfg.Subscriptions.MaxPerPost, "subscriptions-max-per-post", cfg.Subscriptions.MaxPerPost, "maximum subscribers per post, 0 for no limit")
+	fs.DurationVar(&cfg.Subscriptions.MaxLifetime, "subscriptions-max-lifetime", cfg.Subscriptions.MaxLifetime, "subscriptions end after this long, 0 for never")
+	fs.DurationVar(&cfg.Websocket.KeepAlive, "ws-keepalive", cfg.Websocket.KeepAlive, "websocket keep-alive ping interval")
+	fs.DurationVar(&cfg.Websocket.IdleTimeout, "ws-idle-timeout", cfg.Websocket.IdleTimeout, "close websocket connections without subscriptions after this long, 0 for never")
+	fs.DurationVar(&cfg.SSE.KeepAlive, "sse-keepalive", cfg.SSE.KeepAlive, "Server-Sent Events keep-alive interval")
+
+	fs.Var((*listValue)(&cfg.CORS.AllowedOrigins), "cors-allowed-origins", "comma-separated allowed origins, *.domain wildcards allowed")
+
+	fs.StringVar(&cfg.Log.Level, "log-level", cfg.Log.Level, "debug, info, warn or error")
+	fs.StringVar(&cfg.Log.Format, "log-format", cfg.Log.Format, "text or json")
+
+	fs.StringVar(&cfg.Tracing.Exporter, "trace-exporter", cfg.Tracing.Exporter, "otlp, file or empty to disable tracing")
+	fs.StringVar(&cfg.Tracing.OTLPEndpoint, "trace-otlp-endpoint", cfg.Tracing.OTLPEndpoint, "OTLP/HTTP collector URL")
+	fs.StringVar(&cfg.Tracing.File, "trace-file", cfg.Tracing.File, "output file for the file exporter")
+	fs.Float64Var(&cfg.Tracing.SampleRatio, "trace-sample-ratio", cfg.Tracing.SampleRatio, "fraction of traces recorded")
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"flag"
+	"strconv"
+	"strings"
+)
+
+type int32Value int32
+
+func int32Var(fs *flag.FlagSet, p *int32, name, usage string) {
+	fs.Var((*int32Value)(p), name, usage)
+}
+
+func (v *int32Value) Set(s string) error {
+	n, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		return err
+	}
+	*v = int32Value(n)
+	return nil
+}
+
+func (v *int32Value) String() string {
+	if v == nil {
+		return "0"
+	}
+	return strconv.FormatInt(int64(*v), 10)
+}
+
+// listValue is a comma-separated list; setting it replaces the whole list.
+type listValue []string
+
+func (v *listValue) Set(s string) error {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	*v = out
+	return nil
+}
+
+func (v *listValue) String() string {
+	if v == nil {
+		return ""
+	}
+	return strings.Join(*v, ",")
+}
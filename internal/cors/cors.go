@@ -0,0 +1,127 @@
+package cors
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const preflightMaxAge = 10 * time.Minute
+
+var (
+	allowedMethods = []string{http.MethodGet, http.MethodPost, http.MethodOptions}
+	allowedHeaders = []string{"Content-Type", "Authorization", "X-Request-ID", "traceparent", "tracestate"}
+)
+
+// Policy is an origin allow-list shared by the CORS middleware and the
+// websocket upgrader. Entries are exact origins ("https://example.com"),
+// wildcard subdomains ("https://*.example.com") or "*" for any origin.
+type Policy struct {
+	any       bool
+	exact     map[string]struct{}
+	wildcards []wildcard
+}
+
+type wildcard struct {
+	scheme string
+	suffix string
+}
+
+func New(origins []string) *Policy {
+	p := &Policy{exact: make(map[string]struct{})}
+	for _, o := range origins {
+		o = strings.ToLower(strings.TrimSuffix(o, "/"))
+		switch {
+		case o == "*":
+			p.any = true
+		case strings.Contains(o, "://*."):
+			scheme, host, _ := strings.Cut(o, "://*.")
+			p.wildcards = append(p.wildcards, wildcard{scheme: scheme, suffix: "." + host})
+		default:
+			p.exact[o] = struct{}{}
+		}
+	}
+	return p
+}
+
+// Allowed reports whether origin is on the allow-list. A wildcard matches
+// any subdomain but not the bare domain itself.
+func (p *Policy) Allowed(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	return p.any || p.listed(origin)
+}
+
+// listed reports whether origin matches an explicit or wildcard entry, as
+// opposed to just "*".
+func (p *Policy) listed(origin string) bool {
+	origin = strings.ToLower(origin)
+	if _, ok := p.exact[origin]; ok {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	for _, w := range p.wildcards {
+		if u.Scheme == w.scheme && strings.HasSuffix(u.Host, w.suffix) && len(u.Host) > len(w.suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckOrigin is a websocket.Upgrader.CheckOrigin implementation. Requests
+// without an Origin header (non-browser clients) and same-origin requests
+// are always accepted.
+func (p *Policy) CheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return p.Allowed(origin)
+}
+
+// Middleware adds CORS headers for allowed origins. Only listed origins may
+// send credentials; origins admitted by "*" alone get a literal "*", which
+// browsers never combine with cookies or HTTP authentication. Preflight
+// requests are still passed on, so transport.Options answers them.
+func (p *Policy) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		if p.Allowed(origin) {
+			h := w.Header()
+			if p.listed(origin) {
+				h.Set("Access-Control-Allow-Origin", origin)
+				h.Set("Access-Control-Allow-Credentials", "true")
+			} else {
+				h.Set("Access-Control-Allow-Origin", "*")
+			}
+			h.Set("Access-Control-Expose-Headers", "X-Request-ID")
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				h.Add("Vary", "Access-Control-Request-Method")
+				h.Add("Vary", "Access-Control-Request-Headers")
+				h.Set("Access-Control-Allow-Methods", strings.Join(allowedMethods, ", "))
+				h.Set("Access-Control-Allow-Headers", strings.Join(allowedHeaders, ", "))
+				h.Set("Access-Control-Max-Age", strconv.Itoa(int(preflightMaxAge.Seconds())))
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AllowedMethods is the method list to configure transport.Options with.
+func AllowedMethods() []string {
+	return append([]string(nil), allowedMethods...)
+}
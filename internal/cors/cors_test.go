@@ -0,0 +1,121 @@
+package cors_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ozon-comments-graphql/graph"
+	"ozon-comments-graphql/internal/cors"
+	"ozon-comments-graphql/internal/storage"
+
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllowed(t *testing.T) {
+	p := cors.New([]string{"https://app.example.com", "https://*.example.org"})
+
+	cases := map[string]bool{
+		"https://app.example.com":    true,
+		"https://APP.example.com":    true,
+		"http://app.example.com":     false,
+		"https://evil.com":           false,
+		"https://a.example.org":      true,
+		"https://a.b.example.org":    true,
+		"https://example.org":        false,
+		"http://a.example.org":       false,
+		"https://a.example.org.evil": false,
+		"https://notexample.org":     false,
+		"":                           false,
+	}
+	for origin, want := range cases {
+		assert.Equal(t, want, p.Allowed(origin), origin)
+	}
+
+	assert.True(t, cors.New([]string{"*"}).Allowed("https://anything.test"))
+	assert.False(t, cors.New(nil).Allowed("https://anything.test"))
+}
+
+func TestPreflight(t *testing.T) {
+	srv := handler.New(graph.NewExecutableSchema(graph.Config{Resolvers: &graph.Resolver{}}))
+	srv.AddTransport(transport.Options{AllowedMethods: cors.AllowedMethods()})
+	h := cors.New([]string{"https://*.example.com"}).Middleware(srv)
+
+	preflight := func(origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "/query", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := preflight("https://app.example.com")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "https://app.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, rec.Header().Get("Access-Control-Allow-Methods"), http.MethodPost)
+
+	rec = preflight("https://evil.com")
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestCredentials(t *testing.T) {
+	h := cors.New([]string{"*", "https://app.example.com"}).Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	request := func(origin string) http.Header {
+		req := httptest.NewRequest(http.MethodPost, "/query", nil)
+		req.Header.Set("Origin", origin)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Header()
+	}
+
+	got := request("https://app.example.com")
+	assert.Equal(t, "https://app.example.com", got.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", got.Get("Access-Control-Allow-Credentials"))
+
+	got = request("https://evil.com")
+	assert.Equal(t, "*", got.Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, got.Get("Access-Control-Allow-Credentials"), "any origin must not read credentialed responses")
+}
+
+func TestWebsocketOrigin(t *testing.T) {
+	srv := handler.New(graph.NewExecutableSchema(graph.Config{Resolvers: &graph.Resolver{
+		Store:  storage.NewMemoryStorage(),
+		Broker: graph.NewBroker(),
+	}}))
+	srv.AddTransport(transport.Websocket{
+		Upgrader: websocket.Upgrader{CheckOrigin: cors.New([]string{"https://*.example.com"}).CheckOrigin},
+	})
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+	dial := func(origin string) (*http.Response, error) {
+		header := http.Header{}
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+		d := websocket.Dialer{Subprotocols: []string{"graphql-transport-ws"}}
+		conn, resp, err := d.Dial(url, header)
+		if conn != nil {
+			conn.Close()
+		}
+		return resp, err
+	}
+
+	_, err := dial("https://app.example.com")
+	require.NoError(t, err)
+	_, err = dial("")
+	require.NoError(t, err)
+
+	resp, err := dial("https://evil.com")
+	assert.Error(t, err)
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	}
+}
@@ -0,0 +1,70 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+
+	checkTimeout = 2 * time.Second
+)
+
+type Check struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+type ComponentStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type Response struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentStatus `json:"components,omitempty"`
+}
+
+// Liveness reports that the process is up and serving HTTP.
+func Liveness() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, Response{Status: StatusOK})
+	})
+}
+
+// Readiness runs every check and answers 503 if any of them fails.
+func Readiness(checks ...Check) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+		defer cancel()
+
+		res := Response{
+			Status:     StatusOK,
+			Components: make(map[string]ComponentStatus, len(checks)),
+		}
+		code := http.StatusOK
+
+		for _, c := range checks {
+			if err := c.Check(ctx); err != nil {
+				res.Components[c.Name] = ComponentStatus{Status: StatusUnavailable, Error: err.Error()}
+				res.Status = StatusUnavailable
+				code = http.StatusServiceUnavailable
+				continue
+			}
+			res.Components[c.Name] = ComponentStatus{Status: StatusOK}
+		}
+
+		writeJSON(w, code, res)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,40 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"ozon-comments-graphql/internal/health"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLiveness(t *testing.T) {
+	rec := httptest.NewRecorder()
+	health.Liveness().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, rec.Body.String())
+}
+
+func TestReadiness(t *testing.T) {
+	ok := health.Check{Name: "storage", Check: func(context.Context) error { return nil }}
+	down := health.Check{Name: "broker", Check: func(context.Context) error { return errors.New("broker closed") }}
+
+	rec := httptest.NewRecorder()
+	health.Readiness(ok).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	health.Readiness(ok, down).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	var res health.Response
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	assert.Equal(t, health.StatusUnavailable, res.Status)
+	assert.Equal(t, health.StatusOK, res.Components["storage"].Status)
+	assert.Equal(t, "broker closed", res.Components["broker"].Error)
+}
@@ -0,0 +1,92 @@
+package limits
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type addrKey struct{}
+
+// Middleware records the remote address of each request for RemoteAddr and
+// Client. Websocket connections inherit the address of their upgrade
+// request.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), addrKey{}, host)))
+	})
+}
+
+// RemoteAddr returns the client address recorded by Middleware, or "" when
+// ctx does not come from an HTTP request.
+func RemoteAddr(ctx context.Context) string {
+	addr, _ := ctx.Value(addrKey{}).(string)
+	return addr
+}
+
+// Client identifies the sender of the request in ctx: its websocket
+// connection when InitFunc registered one, otherwise its remote address. It
+// returns "" when neither is known.
+func Client(ctx context.Context) string {
+	if c, ok := ctx.Value(connKey{}).(*conn); ok {
+		return "conn:" + strconv.FormatUint(c.id, 10)
+	}
+	if addr := RemoteAddr(ctx); addr != "" {
+		return "addr:" + addr
+	}
+	return ""
+}
+
+// Throttle allows every key a burst of calls and then one call per
+// interval. A nil Throttle allows everything.
+type Throttle struct {
+	interval time.Duration
+	burst    int
+
+	mu sync.Mutex
+	// full holds, per key, when its whole burst is available again; keys
+	// past that time are dropped by the next sweep.
+	full      map[string]time.Time
+	nextSweep time.Time
+}
+
+func NewThrottle(interval time.Duration, burst int) *Throttle {
+	return &Throttle{interval: interval, burst: burst, full: make(map[string]time.Time)}
+}
+
+// Allow reports whether key may make a call now, counting the call if so.
+func (t *Throttle) Allow(key string) bool {
+	if t == nil || t.interval <= 0 {
+		return true
+	}
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if now.After(t.nextSweep) {
+		for k, full := range t.full {
+			if !full.After(now) {
+				delete(t.full, k)
+			}
+		}
+		t.nextSweep = now.Add(time.Duration(t.burst) * t.interval)
+	}
+
+	full := t.full[key]
+	if full.Before(now) {
+		full = now
+	}
+	if full.Sub(now) > time.Duration(t.burst-1)*t.interval {
+		return false
+	}
+	t.full[key] = full.Add(t.interval)
+	return true
+}
@@ -0,0 +1,219 @@
+// Package limits protects the server from subscription floods: it caps
+// subscriptions in total, per websocket connection and per post, ends
+// subscriptions after a maximum lifetime and closes websocket connections
+// that stay without subscriptions for too long. It also identifies the
+// client behind a request and throttles calls per client.
+package limits
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/errcode"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+const errLimitCode = "SUBSCRIPTION_LIMIT_EXCEEDED"
+
+// Config holds the limits. A zero value disables the respective limit.
+type Config struct {
+	MaxSubscriptions int
+	MaxPerConnection int
+	MaxPerPost       int
+	// MaxLifetime ends a subscription after this long; clients are
+	// expected to subscribe again.
+	MaxLifetime time.Duration
+	// IdleTimeout closes a websocket connection that has had no
+	// subscriptions for this long.
+	IdleTimeout time.Duration
+}
+
+// Subscriptions enforces Config. It is a gqlgen extension and provides the
+// websocket transport's InitFunc and CloseFunc; connections without them,
+// e.g. Server-Sent Events with one subscription per request, are only
+// subject to the total and per-post limits.
+type Subscriptions struct {
+	cfg Config
+
+	mu      sync.Mutex
+	total   int
+	perPost map[string]int
+}
+
+var (
+	_ graphql.HandlerExtension = (*Subscriptions)(nil)
+	_ graphql.FieldInterceptor = (*Subscriptions)(nil)
+)
+
+func New(cfg Config) *Subscriptions {
+	return &Subscriptions{cfg: cfg, perPost: make(map[string]int)}
+}
+
+func (*Subscriptions) ExtensionName() string {
+	return "SubscriptionLimits"
+}
+
+func (*Subscriptions) Validate(graphql.ExecutableSchema) error {
+	return nil
+}
+
+// InterceptField admits each subscription against the limits and releases
+// its slot once it ends. Subscriptions taking a postID argument count
+// towards that post.
+func (s *Subscriptions) InterceptField(ctx context.Context, next graphql.Resolver) (interface{}, error) {
+	fc := graphql.GetFieldContext(ctx)
+	if fc == nil || fc.Object != "Subscription" {
+		return next(ctx)
+	}
+
+	postID, _ := fc.Args["postID"].(string)
+	c, _ := ctx.Value(connKey{}).(*conn)
+	if err := s.acquire(c, postID); err != nil {
+		return nil, err
+	}
+
+	cancel := context.CancelFunc(func() {})
+	if s.cfg.MaxLifetime > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.cfg.MaxLifetime)
+	}
+	res, err := next(ctx)
+	if err != nil {
+		cancel()
+		s.release(c, postID)
+		return nil, err
+	}
+
+	go func() {
+		<-ctx.Done()
+		cancel()
+		s.release(c, postID)
+	}()
+	return res, nil
+}
+
+func (s *Subscriptions) acquire(c *conn, postID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cfg.MaxSubscriptions > 0 && s.total >= s.cfg.MaxSubscriptions {
+		return limitError("the server has too many subscriptions, try again later", s.cfg.MaxSubscriptions)
+	}
+	if postID != "" && s.cfg.MaxPerPost > 0 && s.perPost[postID] >= s.cfg.MaxPerPost {
+		return limitError("the post has too many subscribers, try again later", s.cfg.MaxPerPost)
+	}
+	if c != nil && !c.acquire(s.cfg.MaxPerConnection) {
+		return limitError("too many subscriptions on this connection", s.cfg.MaxPerConnection)
+	}
+
+	s.total++
+	if postID != "" {
+		s.perPost[postID]++
+	}
+	return nil
+}
+
+func (s *Subscriptions) release(c *conn, postID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.total--
+	if postID != "" {
+		if s.perPost[postID]--; s.perPost[postID] == 0 {
+			delete(s.perPost, postID)
+		}
+	}
+	if c != nil {
+		c.release(s.cfg.IdleTimeout)
+	}
+}
+
+// Active returns the number of running subscriptions.
+func (s *Subscriptions) Active() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.total
+}
+
+func limitError(msg string, max int) *gqlerror.Error {
+	err := gqlerror.Errorf("subscription limit exceeded: %s (at most %d)", msg, max)
+	errcode.Set(err, errLimitCode)
+	return err
+}
+
+type connKey struct{}
+
+var lastConnID atomic.Uint64
+
+// conn tracks the subscriptions of one websocket connection. close ends the
+// connection; it is armed by the idle timer while nothing is subscribed.
+type conn struct {
+	id     uint64
+	mu     sync.Mutex
+	active int
+	idle   *time.Timer
+	close  context.CancelFunc
+}
+
+func (c *conn) acquire(max int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if max > 0 && c.active >= max {
+		return false
+	}
+	c.active++
+	if c.idle != nil {
+		c.idle.Stop()
+		c.idle = nil
+	}
+	return true
+}
+
+func (c *conn) release(idleTimeout time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.active--; c.active == 0 {
+		c.startIdle(idleTimeout)
+	}
+}
+
+// startIdle arms the idle timer. The caller holds c.mu.
+func (c *conn) startIdle(timeout time.Duration) {
+	if timeout > 0 && c.idle == nil {
+		c.idle = time.AfterFunc(timeout, c.close)
+	}
+}
+
+func (c *conn) stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.idle != nil {
+		c.idle.Stop()
+	}
+	c.close()
+}
+
+// InitFunc is a transport.WebsocketInitFunc registering the connection, so
+// that its subscriptions are counted and it is closed once idle for too long.
+func (s *Subscriptions) InitFunc(ctx context.Context, _ transport.InitPayload) (context.Context, *transport.InitPayload, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	c := &conn{id: lastConnID.Add(1), close: cancel}
+	c.mu.Lock()
+	c.startIdle(s.cfg.IdleTimeout)
+	c.mu.Unlock()
+	return context.WithValue(ctx, connKey{}, c), nil, nil
+}
+
+// CloseFunc is a transport.WebsocketCloseFunc releasing the connection.
+func (s *Subscriptions) CloseFunc(ctx context.Context, _ int) {
+	if c, ok := ctx.Value(connKey{}).(*conn); ok {
+		c.stop()
+	}
+}
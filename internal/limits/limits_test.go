@@ -0,0 +1,120 @@
+package limits_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"ozon-comments-graphql/internal/limits"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// subscribe runs a subscription field through s and returns the context the
+// resolver got, which is done once the subscription has to end.
+func subscribe(ctx context.Context, s *limits.Subscriptions, postID string) (context.Context, error) {
+	ctx = graphql.WithFieldContext(ctx, &graphql.FieldContext{
+		Object: "Subscription",
+		Args:   map[string]interface{}{"postID": postID},
+	})
+	var resolved context.Context
+	_, err := s.InterceptField(ctx, func(ctx context.Context) (interface{}, error) {
+		resolved = ctx
+		return make(chan struct{}), nil
+	})
+	return resolved, err
+}
+
+func TestSubscriptionLimits(t *testing.T) {
+	s := limits.New(limits.Config{MaxSubscriptions: 2, MaxPerPost: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err := subscribe(ctx, s, "a")
+	require.NoError(t, err)
+	_, err = subscribe(ctx, s, "a")
+	var gqlErr *gqlerror.Error
+	require.ErrorAs(t, err, &gqlErr)
+	assert.Contains(t, gqlErr.Message, "the post has too many subscribers")
+	assert.Equal(t, "SUBSCRIPTION_LIMIT_EXCEEDED", gqlErr.Extensions["code"])
+
+	// Subscriptions without a post only count towards the total.
+	_, err = subscribe(ctx, s, "")
+	require.NoError(t, err)
+	_, err = subscribe(ctx, s, "b")
+	require.ErrorAs(t, err, &gqlErr)
+	assert.Contains(t, gqlErr.Message, "the server has too many subscriptions")
+	assert.Equal(t, 2, s.Active())
+
+	// Ended subscriptions free their slots.
+	cancel()
+	assert.Eventually(t, func() bool { return s.Active() == 0 }, time.Second, 5*time.Millisecond)
+	_, err = subscribe(context.Background(), s, "a")
+	assert.NoError(t, err)
+}
+
+func TestSubscriptionMaxLifetime(t *testing.T) {
+	s := limits.New(limits.Config{MaxLifetime: 50 * time.Millisecond})
+
+	ctx, err := subscribe(context.Background(), s, "a")
+	require.NoError(t, err)
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("subscription outlived its maximum lifetime")
+	}
+	assert.Eventually(t, func() bool { return s.Active() == 0 }, time.Second, 5*time.Millisecond)
+}
+
+func TestNonSubscriptionFieldsPass(t *testing.T) {
+	s := limits.New(limits.Config{MaxSubscriptions: 1})
+	ctx := graphql.WithFieldContext(context.Background(), &graphql.FieldContext{Object: "Query"})
+	for i := 0; i < 3; i++ {
+		res, err := s.InterceptField(ctx, func(context.Context) (interface{}, error) { return "ok", nil })
+		assert.NoError(t, err)
+		assert.Equal(t, "ok", res)
+	}
+	assert.Zero(t, s.Active())
+}
+
+func TestThrottle(t *testing.T) {
+	throttle := limits.NewThrottle(50*time.Millisecond, 2)
+	assert.True(t, throttle.Allow("a"))
+	assert.True(t, throttle.Allow("a"))
+	assert.False(t, throttle.Allow("a"))
+	assert.True(t, throttle.Allow("b"), "keys are throttled separately")
+
+	assert.Eventually(t, func() bool { return throttle.Allow("a") }, time.Second, 5*time.Millisecond)
+
+	var disabled *limits.Throttle
+	assert.True(t, disabled.Allow("a"))
+}
+
+func TestClient(t *testing.T) {
+	s := limits.New(limits.Config{})
+	var addr, client, wsClient, otherWS string
+	handler := limits.Middleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		addr, client = limits.RemoteAddr(ctx), limits.Client(ctx)
+		ws, _, err := s.InitFunc(ctx, nil)
+		require.NoError(t, err)
+		wsClient = limits.Client(ws)
+		other, _, err := s.InitFunc(ctx, nil)
+		require.NoError(t, err)
+		otherWS = limits.Client(other)
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/query", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "192.0.2.1", addr)
+	assert.Equal(t, "addr:192.0.2.1", client)
+	assert.NotEqual(t, client, wsClient, "websocket connections are told apart from their address")
+	assert.NotEqual(t, wsClient, otherWS)
+	assert.Empty(t, limits.Client(context.Background()))
+}
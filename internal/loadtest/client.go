@@ -0,0 +1,108 @@
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	createPostMutation    = `mutation($title: String!, $content: String!) { createPost(title: $title, content: $content) { id } }`
+	createCommentMutation = `mutation($postID: ID!, $content: String!) { createComment(postID: $postID, content: $content) { id } }`
+	commentAddedQuery     = `subscription($postID: ID!) { commentAdded(postID: $postID) { content } }`
+)
+
+type gqlRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables,omitempty"`
+}
+
+type gqlResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// do sends one GraphQL request over HTTP POST and decodes data into out.
+func do(ctx context.Context, client *http.Client, url string, req gqlRequest, out interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var gr gqlResponse
+	if err := json.NewDecoder(resp.Body).Decode(&gr); err != nil {
+		return fmt.Errorf("status %d: %w", resp.StatusCode, err)
+	}
+	if len(gr.Errors) > 0 {
+		return fmt.Errorf("graphql: %s", gr.Errors[0].Message)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(gr.Data, out)
+}
+
+type wsMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// subscribe opens a graphql-transport-ws connection with a single
+// commentAdded subscription. The caller reads "next" messages from conn.
+func subscribe(ctx context.Context, url, postID string) (*websocket.Conn, error) {
+	dialer := websocket.Dialer{Subprotocols: []string{"graphql-transport-ws"}}
+	conn, _, err := dialer.DialContext(ctx, wsURL(url), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.WriteJSON(wsMessage{Type: "connection_init"}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var ack wsMessage
+	if err := conn.ReadJSON(&ack); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if ack.Type != "connection_ack" {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected %q instead of connection_ack", ack.Type)
+	}
+
+	payload, _ := json.Marshal(gqlRequest{
+		Query:     commentAddedQuery,
+		Variables: map[string]interface{}{"postID": postID},
+	})
+	if err := conn.WriteJSON(wsMessage{ID: "1", Type: "subscribe", Payload: payload}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func wsURL(url string) string {
+	if strings.HasPrefix(url, "https://") {
+		return "wss://" + strings.TrimPrefix(url, "https://")
+	}
+	return "ws://" + strings.TrimPrefix(url, "http://")
+}
@@ -0,0 +1,239 @@
+// Package loadtest drives a running server with concurrent createComment
+// mutations while commentAdded subscribers listen, and reports mutation
+// throughput and latency together with subscription delivery loss.
+package loadtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type Config struct {
+	// URL is the GraphQL endpoint, e.g. http://localhost:8080/query.
+	URL string
+	// Posts are created for the run; comments are spread across them.
+	Posts int
+	// Subscribers is the number of commentAdded subscriptions per post.
+	Subscribers int
+	// Writers is the number of goroutines sending createComment.
+	Writers  int
+	Duration time.Duration
+	// Settle is the pause between opening subscriptions and the first
+	// mutation; Drain is how long to wait for deliveries afterwards.
+	Settle time.Duration
+	Drain  time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		URL:         "http://localhost:8080/query",
+		Posts:       10,
+		Subscribers: 5,
+		Writers:     20,
+		Duration:    10 * time.Second,
+		Settle:      time.Second,
+		Drain:       2 * time.Second,
+	}
+}
+
+type Report struct {
+	Mutations       int
+	Errors          int
+	Elapsed         time.Duration
+	MutationLatency Percentiles
+	Expected        int
+	Delivered       int
+	DeliveryLatency Percentiles
+}
+
+// Throughput is successful mutations per second.
+func (r *Report) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Mutations) / r.Elapsed.Seconds()
+}
+
+// Loss is the share of expected subscription deliveries that never arrived.
+func (r *Report) Loss() float64 {
+	if r.Expected == 0 {
+		return 0
+	}
+	return 1 - float64(r.Delivered)/float64(r.Expected)
+}
+
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "mutations:  %d ok, %d failed in %s (%.1f/s)\n",
+		r.Mutations, r.Errors, r.Elapsed.Round(time.Millisecond), r.Throughput())
+	fmt.Fprintf(w, "latency:    p50 %s  p90 %s  p99 %s  max %s\n",
+		r.MutationLatency.P50, r.MutationLatency.P90, r.MutationLatency.P99, r.MutationLatency.Max)
+	fmt.Fprintf(w, "deliveries: %d of %d (loss %.2f%%)\n", r.Delivered, r.Expected, 100*r.Loss())
+	fmt.Fprintf(w, "delivery:   p50 %s  p90 %s  p99 %s  max %s\n",
+		r.DeliveryLatency.P50, r.DeliveryLatency.P90, r.DeliveryLatency.P99, r.DeliveryLatency.Max)
+}
+
+type target struct {
+	id          string
+	subscribers int
+	created     atomic.Int64
+}
+
+// Run performs one load test. Failed mutations are counted, not fatal; only
+// failing to set up posts or subscriptions aborts the run.
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	if cfg.Posts <= 0 || cfg.Writers <= 0 || cfg.Subscribers < 0 || cfg.Duration <= 0 {
+		return nil, fmt.Errorf("posts, writers and duration must be positive")
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	targets := make([]*target, cfg.Posts)
+	for i := range targets {
+		var out struct {
+			CreatePost struct{ ID string } `json:"createPost"`
+		}
+		err := do(ctx, client, cfg.URL, gqlRequest{
+			Query:     createPostMutation,
+			Variables: map[string]interface{}{"title": fmt.Sprintf("loadtest %d", i), "content": "loadtest"},
+		}, &out)
+		if err != nil {
+			return nil, fmt.Errorf("create post: %w", err)
+		}
+		targets[i] = &target{id: out.CreatePost.ID}
+	}
+
+	var (
+		sent      sync.Map // comment content -> send time
+		delivered atomic.Int64
+		delivery  latencies
+		readers   sync.WaitGroup
+		conns     []*websocket.Conn
+	)
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	for _, t := range targets {
+		for i := 0; i < cfg.Subscribers; i++ {
+			conn, err := subscribe(ctx, cfg.URL, t.id)
+			if err != nil {
+				return nil, fmt.Errorf("subscribe: %w", err)
+			}
+			conns = append(conns, conn)
+			t.subscribers++
+
+			readers.Add(1)
+			go func() {
+				defer readers.Done()
+				read(conn, &sent, &delivered, &delivery)
+			}()
+		}
+	}
+
+	select {
+	case <-time.After(cfg.Settle):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	var (
+		mutation latencies
+		failed   atomic.Int64
+		writers  sync.WaitGroup
+		seq      atomic.Int64
+	)
+	runCtx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+
+	start := time.Now()
+	for w := 0; w < cfg.Writers; w++ {
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			for runCtx.Err() == nil {
+				n := seq.Add(1)
+				t := targets[int(n)%len(targets)]
+				content := fmt.Sprintf("loadtest %d", n)
+
+				begin := time.Now()
+				sent.Store(content, begin)
+				// In-flight requests outlive runCtx: a mutation cut off after
+				// the server committed it would still be delivered.
+				err := do(ctx, client, cfg.URL, gqlRequest{
+					Query:     createCommentMutation,
+					Variables: map[string]interface{}{"postID": t.id, "content": content},
+				}, nil)
+				if err != nil {
+					failed.Add(1)
+					continue
+				}
+				mutation.add(time.Since(begin))
+				t.created.Add(1)
+			}
+		}()
+	}
+	writers.Wait()
+	elapsed := time.Since(start)
+
+	select {
+	case <-time.After(cfg.Drain):
+	case <-ctx.Done():
+	}
+	for _, conn := range conns {
+		conn.Close()
+	}
+	readers.Wait()
+
+	report := &Report{
+		Errors:          int(failed.Load()),
+		Elapsed:         elapsed,
+		MutationLatency: mutation.percentiles(),
+		Delivered:       int(delivered.Load()),
+		DeliveryLatency: delivery.percentiles(),
+	}
+	for _, t := range targets {
+		created := int(t.created.Load())
+		report.Mutations += created
+		report.Expected += created * t.subscribers
+	}
+	return report, nil
+}
+
+// read consumes subscription events until the connection closes, counting
+// those that match a comment this run created.
+func read(conn *websocket.Conn, sent *sync.Map, delivered *atomic.Int64, delivery *latencies) {
+	for {
+		var msg wsMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			return
+		}
+		switch msg.Type {
+		case "ping":
+			_ = conn.WriteJSON(wsMessage{Type: "pong"})
+		case "next":
+			var payload struct {
+				Data struct {
+					CommentAdded struct{ Content string } `json:"commentAdded"`
+				} `json:"data"`
+			}
+			if json.Unmarshal(msg.Payload, &payload) != nil {
+				continue
+			}
+			if at, ok := sent.Load(payload.Data.CommentAdded.Content); ok {
+				delivery.add(time.Since(at.(time.Time)))
+				delivered.Add(1)
+			}
+		case "complete", "error":
+			return
+		}
+	}
+}
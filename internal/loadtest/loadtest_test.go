@@ -0,0 +1,51 @@
+package loadtest_test
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"ozon-comments-graphql/graph"
+	"ozon-comments-graphql/internal/loadtest"
+	"ozon-comments-graphql/internal/storage"
+
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	srv := handler.New(graph.NewExecutableSchema(graph.Config{Resolvers: &graph.Resolver{
+		Store:  storage.NewMemoryStorage(),
+		Broker: graph.NewBroker(graph.WithBufferSize(1024)),
+	}}))
+	srv.AddTransport(transport.Websocket{})
+	srv.AddTransport(transport.POST{})
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+
+	report, err := loadtest.Run(context.Background(), loadtest.Config{
+		URL:         ts.URL,
+		Posts:       2,
+		Subscribers: 2,
+		Writers:     4,
+		Duration:    200 * time.Millisecond,
+		Settle:      100 * time.Millisecond,
+		Drain:       200 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	assert.Positive(t, report.Mutations)
+	assert.Zero(t, report.Errors)
+	assert.Equal(t, 2*report.Mutations, report.Expected)
+	assert.Equal(t, report.Expected, report.Delivered)
+	assert.Zero(t, report.Loss())
+	assert.Positive(t, report.Throughput())
+
+	var out bytes.Buffer
+	report.Print(&out)
+	assert.Contains(t, out.String(), "loss 0.00%")
+}
@@ -0,0 +1,58 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+type graphqlExtension struct {
+	logger *slog.Logger
+}
+
+var (
+	_ graphql.HandlerExtension    = graphqlExtension{}
+	_ graphql.ResponseInterceptor = graphqlExtension{}
+)
+
+// GraphQL returns a gqlgen extension logging the name, type and duration of
+// every query and mutation.
+func GraphQL(logger *slog.Logger) graphql.HandlerExtension {
+	return graphqlExtension{logger: logger}
+}
+
+func (graphqlExtension) ExtensionName() string {
+	return "StructuredLogging"
+}
+
+func (graphqlExtension) Validate(graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (e graphqlExtension) InterceptResponse(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	resp := next(ctx)
+	if !graphql.HasOperationContext(ctx) {
+		return resp
+	}
+
+	oc := graphql.GetOperationContext(ctx)
+	if oc.Operation == nil || oc.Operation.Operation == ast.Subscription {
+		return resp
+	}
+
+	level := slog.LevelInfo
+	attrs := []slog.Attr{
+		slog.String("operation", oc.OperationName),
+		slog.String("type", string(oc.Operation.Operation)),
+		slog.Duration("duration", time.Since(oc.Stats.OperationStart)),
+	}
+	if resp != nil && len(resp.Errors) > 0 {
+		level = slog.LevelWarn
+		attrs = append(attrs, slog.String("errors", resp.Errors.Error()))
+	}
+	e.logger.LogAttrs(ctx, level, "graphql operation", attrs...)
+	return resp
+}
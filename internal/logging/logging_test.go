@@ -0,0 +1,64 @@
+package logging_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"ozon-comments-graphql/internal/logging"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddlewareRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, "debug", "json")
+	assert.NoError(t, err)
+
+	var seen string
+	h := logging.Middleware(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = logging.RequestID(r.Context())
+		slog.New(logger.Handler()).InfoContext(r.Context(), "inside handler")
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/query", nil)
+	req.Header.Set(logging.RequestIDHeader, "abc-123")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	assert.Equal(t, "abc-123", seen)
+	assert.Equal(t, "abc-123", rec.Header().Get(logging.RequestIDHeader))
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	assert.Len(t, lines, 2)
+	for _, line := range lines {
+		var entry map[string]interface{}
+		assert.NoError(t, json.Unmarshal(line, &entry))
+		assert.Equal(t, "abc-123", entry["request_id"])
+	}
+
+	var access map[string]interface{}
+	assert.NoError(t, json.Unmarshal(lines[1], &access))
+	assert.Equal(t, float64(http.StatusTeapot), access["status"])
+}
+
+func TestMiddlewareGeneratesRequestID(t *testing.T) {
+	logger, err := logging.New(&bytes.Buffer{}, "info", "text")
+	assert.NoError(t, err)
+
+	h := logging.Middleware(logger, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.NotEmpty(t, rec.Header().Get(logging.RequestIDHeader))
+}
+
+func TestNewRejectsInvalidSettings(t *testing.T) {
+	_, err := logging.New(&bytes.Buffer{}, "loud", "text")
+	assert.Error(t, err)
+	_, err = logging.New(&bytes.Buffer{}, "info", "xml")
+	assert.Error(t, err)
+}
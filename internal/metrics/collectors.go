@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type BrokerStats interface {
+	// TopicSubscribers returns the number of active subscribers per
+	// subscription topic, e.g. commentAdded.
+	TopicSubscribers() map[string]int
+	// WatchedPosts returns how many posts have at least one subscriber.
+	WatchedPosts() int
+	DroppedMessages() uint64
+}
+
+type brokerCollector struct {
+	stats       BrokerStats
+	subscribers *prometheus.Desc
+	posts       *prometheus.Desc
+	dropped     *prometheus.Desc
+}
+
+// RegisterBroker exposes subscriber and dropped-message counts read from the
+// broker at scrape time. Subscribers are labelled by topic only: a label per
+// post would create a series for every post ever watched.
+func RegisterBroker(reg prometheus.Registerer, stats BrokerStats) {
+	reg.MustRegister(&brokerCollector{
+		stats: stats,
+		subscribers: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "broker", "active_subscribers"),
+			"Active subscribers per subscription topic.",
+			[]string{"topic"}, nil,
+		),
+		posts: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "broker", "watched_posts"),
+			"Posts with at least one subscriber.",
+			nil, nil,
+		),
+		dropped: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "broker", "dropped_messages_total"),
+			"Events discarded because a subscriber was not keeping up.",
+			nil, nil,
+		),
+	})
+}
+
+func (c *brokerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.subscribers
+	ch <- c.posts
+	ch <- c.dropped
+}
+
+func (c *brokerCollector) Collect(ch chan<- prometheus.Metric) {
+	for topic, n := range c.stats.TopicSubscribers() {
+		ch <- prometheus.MustNewConstMetric(c.subscribers, prometheus.GaugeValue, float64(n), topic)
+	}
+	ch <- prometheus.MustNewConstMetric(c.posts, prometheus.GaugeValue, float64(c.stats.WatchedPosts()))
+	ch <- prometheus.MustNewConstMetric(c.dropped, prometheus.CounterValue, float64(c.stats.DroppedMessages()))
+}
+
+type poolCollector struct {
+	stat         func() *pgxpool.Stat
+	acquired     *prometheus.Desc
+	idle         *prometheus.Desc
+	total        *prometheus.Desc
+	max          *prometheus.Desc
+	acquireCount *prometheus.Desc
+	acquireWait  *prometheus.Desc
+}
+
+// RegisterPool exposes pgxpool statistics read at scrape time.
+func RegisterPool(reg prometheus.Registerer, stat func() *pgxpool.Stat) {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "pgxpool", name), help, nil, nil)
+	}
+	reg.MustRegister(&poolCollector{
+		stat:         stat,
+		acquired:     desc("acquired_conns", "Connections currently in use."),
+		idle:         desc("idle_conns", "Idle connections."),
+		total:        desc("total_conns", "Total connections in the pool."),
+		max:          desc("max_conns", "Maximum pool size."),
+		acquireCount: desc("acquire_total", "Successful connection acquisitions."),
+		acquireWait:  desc("acquire_wait_seconds_total", "Time spent waiting for a connection."),
+	})
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.acquired
+	ch <- c.idle
+	ch <- c.total
+	ch <- c.max
+	ch <- c.acquireCount
+	ch <- c.acquireWait
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.stat()
+	ch <- prometheus.MustNewConstMetric(c.acquired, prometheus.GaugeValue, float64(s.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(s.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(c.total, prometheus.GaugeValue, float64(s.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(c.max, prometheus.GaugeValue, float64(s.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(c.acquireCount, prometheus.CounterValue, float64(s.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.acquireWait, prometheus.CounterValue, s.AcquireDuration().Seconds())
+}
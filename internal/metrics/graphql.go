@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+type graphqlExtension struct {
+	m     *Metrics
+	known map[string]struct{}
+}
+
+var (
+	_ graphql.HandlerExtension    = graphqlExtension{}
+	_ graphql.ResponseInterceptor = graphqlExtension{}
+)
+
+// GraphQL returns a gqlgen extension recording per-operation latency and
+// error counts. Operation names are chosen by clients, so only the known
+// ones, e.g. those of the persisted query allow-list, become label values:
+// any other named operation is recorded as "other", keeping the number of
+// series bounded.
+func (m *Metrics) GraphQL(known ...string) graphql.HandlerExtension {
+	e := graphqlExtension{m: m, known: make(map[string]struct{}, len(known))}
+	for _, name := range known {
+		e.known[name] = struct{}{}
+	}
+	return e
+}
+
+func (graphqlExtension) ExtensionName() string {
+	return "PrometheusMetrics"
+}
+
+func (graphqlExtension) Validate(graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (e graphqlExtension) InterceptResponse(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	resp := next(ctx)
+	if !graphql.HasOperationContext(ctx) {
+		return resp
+	}
+
+	oc := graphql.GetOperationContext(ctx)
+	name, opType := "anonymous", "unknown"
+	if oc.Operation != nil {
+		opType = string(oc.Operation.Operation)
+		if oc.Operation.Name != "" {
+			name = "other"
+			if _, ok := e.known[oc.Operation.Name]; ok {
+				name = oc.Operation.Name
+			}
+		}
+	}
+
+	// Subscriptions produce a response per event, so only their errors are
+	// counted; latency is meaningful for queries and mutations.
+	if opType != string(ast.Subscription) {
+		e.m.operationDuration.WithLabelValues(name, opType).Observe(time.Since(oc.Stats.OperationStart).Seconds())
+	}
+	if resp != nil && len(resp.Errors) > 0 {
+		e.m.operationErrors.WithLabelValues(name, opType).Inc()
+	}
+	return resp
+}
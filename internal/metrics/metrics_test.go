@@ -0,0 +1,104 @@
+package metrics_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"ozon-comments-graphql/graph"
+	"ozon-comments-graphql/internal/metrics"
+	"ozon-comments-graphql/internal/storage"
+	"strings"
+	"testing"
+
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeBroker struct{}
+
+func (fakeBroker) TopicSubscribers() map[string]int {
+	return map[string]int{"commentAdded": 3, "typingIndicators": 1}
+}
+func (fakeBroker) WatchedPosts() int       { return 2 }
+func (fakeBroker) DroppedMessages() uint64 { return 3 }
+
+func TestStorageMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := metrics.New(reg)
+	s := m.Storage(storage.NewMemoryStorage())
+	ctx := context.Background()
+
+	post := s.CreatePost(ctx, "Title", "Content")
+	_, err := s.GetPost(ctx, post.ID)
+	assert.NoError(t, err)
+	_, err = s.GetPost(ctx, "missing")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	assert.Equal(t, 2, testutil.CollectAndCount(reg, "comments_storage_call_duration_seconds"))
+	err = testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP comments_storage_call_errors_total Storage calls that returned an error.
+# TYPE comments_storage_call_errors_total counter
+comments_storage_call_errors_total{method="GetPost"} 1
+`), "comments_storage_call_errors_total")
+	assert.NoError(t, err)
+}
+
+func TestBrokerMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metrics.RegisterBroker(reg, fakeBroker{})
+
+	err := testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP comments_broker_active_subscribers Active subscribers per subscription topic.
+# TYPE comments_broker_active_subscribers gauge
+comments_broker_active_subscribers{topic="commentAdded"} 3
+comments_broker_active_subscribers{topic="typingIndicators"} 1
+# HELP comments_broker_watched_posts Posts with at least one subscriber.
+# TYPE comments_broker_watched_posts gauge
+comments_broker_watched_posts 2
+# HELP comments_broker_dropped_messages_total Events discarded because a subscriber was not keeping up.
+# TYPE comments_broker_dropped_messages_total counter
+comments_broker_dropped_messages_total 3
+`))
+	assert.NoError(t, err)
+}
+
+func TestGraphQLMetricsOnlyLabelKnownOperations(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := metrics.New(reg)
+	srv := handler.New(graph.NewExecutableSchema(graph.Config{Resolvers: &graph.Resolver{
+		Store:  storage.NewMemoryStorage(),
+		Broker: graph.NewBroker(),
+	}}))
+	srv.AddTransport(transport.POST{})
+	srv.Use(m.GraphQL("Posts"))
+
+	for _, query := range []string{
+		`query Posts { posts { items { id } } }`,
+		`query Random1 { posts { items { id } } }`,
+		`query Random2 { posts { items { id } } }`,
+		`{ posts { items { id } } }`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"query":"`+query+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		srv.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	assert.Equal(t, 3, testutil.CollectAndCount(reg, "comments_graphql_operation_duration_seconds"))
+
+	families, err := reg.Gather()
+	assert.NoError(t, err)
+	var names []string
+	for _, f := range families {
+		for _, metric := range f.GetMetric() {
+			for _, l := range metric.GetLabel() {
+				if l.GetName() == "operation" {
+					names = append(names, l.GetValue())
+				}
+			}
+		}
+	}
+	assert.ElementsMatch(t, []string{"Posts", "other", "anonymous"}, names)
+}
@@ -0,0 +1,228 @@
+package metrics
+
+import (
+	"context"
+	"ozon-comments-graphql/internal/models"
+	"ozon-comments-graphql/internal/storage"
+	"time"
+)
+
+type instrumentedStorage struct {
+	next storage.Storage
+	m    *Metrics
+}
+
+// Storage wraps s so that every call is timed and its errors counted.
+func (m *Metrics) Storage(s storage.Storage) storage.Storage {
+	return &instrumentedStorage{next: s, m: m}
+}
+
+func (s *instrumentedStorage) observe(method string, start time.Time, err error) {
+	s.m.storageDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		s.m.storageErrors.WithLabelValues(method).Inc()
+	}
+}
+
+func (s *instrumentedStorage) CreatePost(ctx context.Context, title, content string) *models.Post {
+	defer s.observe("CreatePost", time.Now(), nil)
+	return s.next.CreatePost(ctx, title, content)
+}
+
+func (s *instrumentedStorage) UpdatePost(ctx context.Context, id string, title, content *string) (p *models.Post, err error) {
+	defer func(start time.Time) { s.observe("UpdatePost", start, err) }(time.Now())
+	return s.next.UpdatePost(ctx, id, title, content)
+}
+
+func (s *instrumentedStorage) DeletePost(ctx context.Context, id string, soft bool) (p *models.Post, err error) {
+	defer func(start time.Time) { s.observe("DeletePost", start, err) }(time.Now())
+	return s.next.DeletePost(ctx, id, soft)
+}
+
+func (s *instrumentedStorage) ToggleComments(ctx context.Context, id string, disabled bool) (p *models.Post, changed bool, err error) {
+	defer func(start time.Time) { s.observe("ToggleComments", start, err) }(time.Now())
+	return s.next.ToggleComments(ctx, id, disabled)
+}
+
+func (s *instrumentedStorage) ListPosts(ctx context.Context, opts storage.ListPostsOptions) (posts []*models.Post, next *string, err error) {
+	defer func(start time.Time) { s.observe("ListPosts", start, err) }(time.Now())
+	return s.next.ListPosts(ctx, opts)
+}
+
+func (s *instrumentedStorage) GetPost(ctx context.Context, id string) (p *models.Post, err error) {
+	defer func(start time.Time) { s.observe("GetPost", start, err) }(time.Now())
+	return s.next.GetPost(ctx, id)
+}
+
+func (s *instrumentedStorage) CreateComment(ctx context.Context, postID string, parentID, author *string, content string) (c *models.Comment, err error) {
+	defer func(start time.Time) { s.observe("CreateComment", start, err) }(time.Now())
+	return s.next.CreateComment(ctx, postID, parentID, author, content)
+}
+
+func (s *instrumentedStorage) ListComments(ctx context.Context, postID string, first int, afterID *string) (c []*models.Comment, next *string, err error) {
+	defer func(start time.Time) { s.observe("ListComments", start, err) }(time.Now())
+	return s.next.ListComments(ctx, postID, first, afterID)
+}
+
+func (s *instrumentedStorage) GetComment(ctx context.Context, id string) (c *models.Comment, err error) {
+	defer func(start time.Time) { s.observe("GetComment", start, err) }(time.Now())
+	return s.next.GetComment(ctx, id)
+}
+
+func (s *instrumentedStorage) CommentAncestry(ctx context.Context, id string, limit int) (c []*models.Comment, err error) {
+	defer func(start time.Time) { s.observe("CommentAncestry", start, err) }(time.Now())
+	return s.next.CommentAncestry(ctx, id, limit)
+}
+
+func (s *instrumentedStorage) GetPersistedQuery(ctx context.Context, hash string) (q string, err error) {
+	defer func(start time.Time) { s.observe("GetPersistedQuery", start, err) }(time.Now())
+	return s.next.GetPersistedQuery(ctx, hash)
+}
+
+func (s *instrumentedStorage) SavePersistedQuery(ctx context.Context, hash, query string) (err error) {
+	defer func(start time.Time) { s.observe("SavePersistedQuery", start, err) }(time.Now())
+	return s.next.SavePersistedQuery(ctx, hash, query)
+}
+
+func (s *instrumentedStorage) PrunePersistedQueries(ctx context.Context, savedBefore time.Time) (n int, err error) {
+	defer func(start time.Time) { s.observe("PrunePersistedQueries", start, err) }(time.Now())
+	return s.next.PrunePersistedQueries(ctx, savedBefore)
+}
+
+func (s *instrumentedStorage) ExportPosts(ctx context.Context, fn func(*models.Post) error) (err error) {
+	defer func(start time.Time) { s.observe("ExportPosts", start, err) }(time.Now())
+	return s.next.ExportPosts(ctx, fn)
+}
+
+func (s *instrumentedStorage) ExportComments(ctx context.Context, fn func(*models.Comment) error) (err error) {
+	defer func(start time.Time) { s.observe("ExportComments", start, err) }(time.Now())
+	return s.next.ExportComments(ctx, fn)
+}
+
+func (s *instrumentedStorage) ImportPost(ctx context.Context, p *models.Post) (err error) {
+	defer func(start time.Time) { s.observe("ImportPost", start, err) }(time.Now())
+	return s.next.ImportPost(ctx, p)
+}
+
+func (s *instrumentedStorage) ImportComment(ctx context.Context, c *models.Comment) (err error) {
+	defer func(start time.Time) { s.observe("ImportComment", start, err) }(time.Now())
+	return s.next.ImportComment(ctx, c)
+}
+
+func (s *instrumentedStorage) AddOutboxEvent(ctx context.Context, e *storage.OutboxEvent) (err error) {
+	defer func(start time.Time) { s.observe("AddOutboxEvent", start, err) }(time.Now())
+	return s.next.AddOutboxEvent(ctx, e)
+}
+
+func (s *instrumentedStorage) PendingOutboxEvents(ctx context.Context, limit int) (events []*storage.OutboxEvent, err error) {
+	defer func(start time.Time) { s.observe("PendingOutboxEvents", start, err) }(time.Now())
+	return s.next.PendingOutboxEvents(ctx, limit)
+}
+
+func (s *instrumentedStorage) ClaimOutboxEvents(ctx context.Context, now time.Time, lease time.Duration, limit int) (events []*storage.OutboxEvent, err error) {
+	defer func(start time.Time) { s.observe("ClaimOutboxEvents", start, err) }(time.Now())
+	return s.next.ClaimOutboxEvents(ctx, now, lease, limit)
+}
+
+func (s *instrumentedStorage) ReleaseOutboxEvents(ctx context.Context, ids []string) (err error) {
+	defer func(start time.Time) { s.observe("ReleaseOutboxEvents", start, err) }(time.Now())
+	return s.next.ReleaseOutboxEvents(ctx, ids)
+}
+
+func (s *instrumentedStorage) MarkOutboxDelivered(ctx context.Context, ids []string) (err error) {
+	defer func(start time.Time) { s.observe("MarkOutboxDelivered", start, err) }(time.Now())
+	return s.next.MarkOutboxDelivered(ctx, ids)
+}
+
+func (s *instrumentedStorage) PruneOutbox(ctx context.Context, deliveredBefore time.Time) (n int, err error) {
+	defer func(start time.Time) { s.observe("PruneOutbox", start, err) }(time.Now())
+	return s.next.PruneOutbox(ctx, deliveredBefore)
+}
+
+func (s *instrumentedStorage) AddNotification(ctx context.Context, n *models.Notification) (err error) {
+	defer func(start time.Time) { s.observe("AddNotification", start, err) }(time.Now())
+	return s.next.AddNotification(ctx, n)
+}
+
+func (s *instrumentedStorage) ListNotifications(ctx context.Context, user string, opts storage.ListNotificationsOptions) (ns []*models.Notification, next *string, err error) {
+	defer func(start time.Time) { s.observe("ListNotifications", start, err) }(time.Now())
+	return s.next.ListNotifications(ctx, user, opts)
+}
+
+func (s *instrumentedStorage) MarkNotificationsRead(ctx context.Context, user string, ids []string) (n int, err error) {
+	defer func(start time.Time) { s.observe("MarkNotificationsRead", start, err) }(time.Now())
+	return s.next.MarkNotificationsRead(ctx, user, ids)
+}
+
+func (s *instrumentedStorage) AddAttachment(ctx context.Context, a *models.Attachment) (err error) {
+	defer func(start time.Time) { s.observe("AddAttachment", start, err) }(time.Now())
+	return s.next.AddAttachment(ctx, a)
+}
+
+func (s *instrumentedStorage) ListAttachments(ctx context.Context, commentID string) (as []*models.Attachment, err error) {
+	defer func(start time.Time) { s.observe("ListAttachments", start, err) }(time.Now())
+	return s.next.ListAttachments(ctx, commentID)
+}
+
+func (s *instrumentedStorage) ListAttachmentsFor(ctx context.Context, commentIDs []string) (as map[string][]*models.Attachment, err error) {
+	defer func(start time.Time) { s.observe("ListAttachmentsFor", start, err) }(time.Now())
+	return s.next.ListAttachmentsFor(ctx, commentIDs)
+}
+
+func (s *instrumentedStorage) ListPostComments(ctx context.Context, postID string) (cs []*models.Comment, err error) {
+	defer func(start time.Time) { s.observe("ListPostComments", start, err) }(time.Now())
+	return s.next.ListPostComments(ctx, postID)
+}
+
+func (s *instrumentedStorage) ListPostAttachments(ctx context.Context, postID string) (as []*models.Attachment, err error) {
+	defer func(start time.Time) { s.observe("ListPostAttachments", start, err) }(time.Now())
+	return s.next.ListPostAttachments(ctx, postID)
+}
+
+func (s *instrumentedStorage) CreateWebhook(ctx context.Context, w *models.Webhook) (err error) {
+	defer func(start time.Time) { s.observe("CreateWebhook", start, err) }(time.Now())
+	return s.next.CreateWebhook(ctx, w)
+}
+
+func (s *instrumentedStorage) ListWebhooks(ctx context.Context) (hooks []*models.Webhook, err error) {
+	defer func(start time.Time) { s.observe("ListWebhooks", start, err) }(time.Now())
+	return s.next.ListWebhooks(ctx)
+}
+
+func (s *instrumentedStorage) AddWebhookDelivery(ctx context.Context, d *models.WebhookDelivery) (err error) {
+	defer func(start time.Time) { s.observe("AddWebhookDelivery", start, err) }(time.Now())
+	return s.next.AddWebhookDelivery(ctx, d)
+}
+
+func (s *instrumentedStorage) ClaimWebhookDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) (ds []*models.WebhookDelivery, err error) {
+	defer func(start time.Time) { s.observe("ClaimWebhookDeliveries", start, err) }(time.Now())
+	return s.next.ClaimWebhookDeliveries(ctx, now, lease, limit)
+}
+
+func (s *instrumentedStorage) UpdateWebhookDelivery(ctx context.Context, d *models.WebhookDelivery) (err error) {
+	defer func(start time.Time) { s.observe("UpdateWebhookDelivery", start, err) }(time.Now())
+	return s.next.UpdateWebhookDelivery(ctx, d)
+}
+
+func (s *instrumentedStorage) ListWebhookDeliveries(ctx context.Context, filter storage.WebhookDeliveryFilter, limit int) (ds []*models.WebhookDelivery, err error) {
+	defer func(start time.Time) { s.observe("ListWebhookDeliveries", start, err) }(time.Now())
+	return s.next.ListWebhookDeliveries(ctx, filter, limit)
+}
+
+func (s *instrumentedStorage) PruneWebhookDeliveries(ctx context.Context, finishedBefore time.Time) (n int, err error) {
+	defer func(start time.Time) { s.observe("PruneWebhookDeliveries", start, err) }(time.Now())
+	return s.next.PruneWebhookDeliveries(ctx, finishedBefore)
+}
+
+func (s *instrumentedStorage) WithTx(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	defer func(start time.Time) { s.observe("WithTx", start, err) }(time.Now())
+	return s.next.WithTx(ctx, fn)
+}
+
+func (s *instrumentedStorage) Health(ctx context.Context) error {
+	return s.next.Health(ctx)
+}
+
+func (s *instrumentedStorage) Close() {
+	s.next.Close()
+}
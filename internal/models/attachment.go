@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// Attachment is a file uploaded with a comment. The bytes live in a blob
+// store under Key; only the metadata is kept with the comment.
+type Attachment struct {
+	ID          string
+	CommentID   string
+	Filename    string
+	ContentType string
+	Size        int64
+	Key         string
+	// ThumbnailKey, Width and Height are set for images that could be
+	// decoded.
+	ThumbnailKey *string
+	Width        *int
+	Height       *int
+	CreatedAt    time.Time
+}
@@ -8,4 +8,7 @@ type Comment struct {
 	ParentID  *string
 	Content   string
 	CreatedAt time.Time
+	// Author is the username of whoever wrote the comment, nil for
+	// anonymous comments. Usernames are not authenticated.
+	Author *string
 }
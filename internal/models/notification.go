@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+const (
+	NotificationMention = "MENTION"
+	NotificationReply   = "REPLY"
+)
+
+// Notification tells User that CommentID mentioned them or replied to one of
+// their comments.
+type Notification struct {
+	ID        string
+	User      string
+	Type      string
+	PostID    string
+	CommentID string
+	// Actor is the author of the comment.
+	Actor     *string
+	CreatedAt time.Time
+	ReadAt    *time.Time
+}
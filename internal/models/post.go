@@ -8,4 +8,7 @@ type Post struct {
 	Content          string
 	CommentsDisabled bool
 	CreatedAt        time.Time
+	CommentCount     int
+	LastCommentAt    *time.Time
+	DeletedAt        *time.Time
 }
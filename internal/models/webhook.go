@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+type Webhook struct {
+	ID        string
+	URL       string
+	Events    []string
+	Secret    string
+	CreatedAt time.Time
+}
+
+const (
+	DeliveryPending   = "PENDING"
+	DeliveryDelivered = "DELIVERED"
+	// DeliveryDead marks a delivery that ran out of attempts: the dead-letter
+	// list.
+	DeliveryDead = "DEAD"
+)
+
+type WebhookDelivery struct {
+	ID             string
+	WebhookID      string
+	EventID        string
+	Event          string
+	Payload        []byte
+	Status         string
+	Attempts       int
+	ResponseStatus *int
+	LastError      *string
+	CreatedAt      time.Time
+	NextAttemptAt  *time.Time
+	DeliveredAt    *time.Time
+}
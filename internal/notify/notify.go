@@ -0,0 +1,83 @@
+// Package notify decides who hears about a new comment: users it @mentions
+// and the author of the comment it replies to.
+package notify
+
+import (
+	"regexp"
+
+	"ozon-comments-graphql/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// MaxMentions is how many users one comment can notify by @mention; further
+// mentions are plain text.
+const MaxMentions = 10
+
+var (
+	usernameRe = regexp.MustCompile(`^[A-Za-z0-9_]{1,32}$`)
+	// mentionRe matches @name not preceded by a word character, so e-mail
+	// addresses are not mentions.
+	mentionRe = regexp.MustCompile(`(?:^|[^A-Za-z0-9_@])@([A-Za-z0-9_]{1,32})\b`)
+)
+
+// ValidUsername reports whether name can author comments and be mentioned:
+// 1 to 32 letters, digits or underscores.
+func ValidUsername(name string) bool {
+	return usernameRe.MatchString(name)
+}
+
+// Mentions returns the first MaxMentions users mentioned in content, each
+// once, in order of first appearance. A name longer than a username is not a
+// mention.
+func Mentions(content string) []string {
+	var users []string
+	seen := make(map[string]struct{})
+	for _, m := range mentionRe.FindAllStringSubmatch(content, -1) {
+		if len(users) == MaxMentions {
+			break
+		}
+		user := m[1]
+		if _, ok := seen[user]; ok {
+			continue
+		}
+		seen[user] = struct{}{}
+		users = append(users, user)
+	}
+	return users
+}
+
+// For returns the notifications caused by comment. parent is the comment it
+// replies to, nil for top-level comments. Authors are never notified about
+// their own comments, and the author of parent gets a single REPLY even if
+// also mentioned.
+func For(comment, parent *models.Comment) []*models.Notification {
+	var res []*models.Notification
+	notified := make(map[string]struct{})
+	if comment.Author != nil {
+		notified[*comment.Author] = struct{}{}
+	}
+	add := func(user, typ string) {
+		if _, ok := notified[user]; ok {
+			return
+		}
+		notified[user] = struct{}{}
+		res = append(res, &models.Notification{
+			ID:        uuid.NewString(),
+			User:      user,
+			Type:      typ,
+			PostID:    comment.PostID,
+			CommentID: comment.ID,
+			Actor:     comment.Author,
+			CreatedAt: comment.CreatedAt,
+		})
+	}
+
+	if parent != nil && parent.Author != nil {
+		add(*parent.Author, models.NotificationReply)
+	}
+	for _, user := range Mentions(comment.Content) {
+		add(user, models.NotificationMention)
+	}
+	return res
+}
@@ -0,0 +1,68 @@
+package notify_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"ozon-comments-graphql/internal/models"
+	"ozon-comments-graphql/internal/notify"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMentions(t *testing.T) {
+	assert.Equal(t, []string{"alice", "bob", "dave"},
+		notify.Mentions("@alice hi @bob, write to a@b.com; @alice again (@dave) @@carol"))
+	assert.Empty(t, notify.Mentions("@this_is_a_very_long_name_of_more_than_32_chars"))
+	assert.Empty(t, notify.Mentions("no mentions here"))
+}
+
+func TestValidUsername(t *testing.T) {
+	assert.True(t, notify.ValidUsername("alice_1"))
+	assert.False(t, notify.ValidUsername(""))
+	assert.False(t, notify.ValidUsername("al ice"))
+	assert.False(t, notify.ValidUsername("@alice"))
+}
+
+func TestFor(t *testing.T) {
+	alice, bob := "alice", "bob"
+	parent := &models.Comment{ID: "p", PostID: "post", Author: &alice}
+	reply := &models.Comment{
+		ID:        "c",
+		PostID:    "post",
+		ParentID:  &parent.ID,
+		Author:    &bob,
+		Content:   "@alice @carol @bob thanks",
+		CreatedAt: time.Now(),
+	}
+
+	got := notify.For(reply, parent)
+	if assert.Len(t, got, 2) {
+		assert.Equal(t, "alice", got[0].User)
+		assert.Equal(t, models.NotificationReply, got[0].Type)
+		assert.Equal(t, "carol", got[1].User)
+		assert.Equal(t, models.NotificationMention, got[1].Type)
+		assert.Equal(t, "c", got[1].CommentID)
+		assert.Equal(t, &bob, got[1].Actor)
+	}
+
+	// Anonymous parents get no reply notification; self-replies neither.
+	assert.Empty(t, notify.For(&models.Comment{ID: "c", Content: "hi"}, &models.Comment{ID: "p"}))
+	assert.Empty(t, notify.For(&models.Comment{ID: "c", Author: &alice}, parent))
+}
+
+func TestMentionsAreCapped(t *testing.T) {
+	var content strings.Builder
+	for i := range notify.MaxMentions + 5 {
+		fmt.Fprintf(&content, "@user%d ", i)
+	}
+
+	got := notify.Mentions(content.String())
+	if assert.Len(t, got, notify.MaxMentions) {
+		assert.Equal(t, "user0", got[0])
+		assert.Equal(t, fmt.Sprintf("user%d", notify.MaxMentions-1), got[notify.MaxMentions-1])
+	}
+	assert.Len(t, notify.For(&models.Comment{ID: "c", Content: content.String()}, nil), notify.MaxMentions)
+}
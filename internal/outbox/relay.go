@@ -0,0 +1,191 @@
+// Package outbox relays events that were written to storage in the same
+// transaction as the change they describe. Delivery is at-least-once: an
+// event is marked delivered only after its handler succeeded, so a crash in
+// between hands it out again under the same ID once its claim expires.
+package outbox
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"ozon-comments-graphql/internal/storage"
+)
+
+const pruneInterval = time.Hour
+
+// claimLease is how long a claimed batch is kept from other relays. It only
+// matters when a relay dies mid-batch; normally the batch is marked delivered
+// or released long before.
+const claimLease = time.Minute
+
+// Topics of the events written to the outbox. Payloads are JSON: the
+// comment for TopicCommentCreated and TopicCommentDeleted, the post for the
+// post topics, and the notification for TopicNotificationCreated. Comments
+// are only deleted along with their post by a hard delete.
+const (
+	TopicCommentCreated      = "comment.created"
+	TopicCommentDeleted      = "comment.deleted"
+	TopicPostCreated         = "post.created"
+	TopicPostUpdated         = "post.updated"
+	TopicPostToggled         = "post.toggled"
+	TopicPostDeleted         = "post.deleted"
+	TopicNotificationCreated = "notification.created"
+)
+
+// Handler publishes one event. It should return an error only for failures
+// worth retrying; the relay stops the batch there and retries later. An
+// event that can never be handled must be logged and acknowledged.
+type Handler func(ctx context.Context, e *storage.OutboxEvent) error
+
+// Fanout hands every event to each handler in turn and fails on the first
+// error. Handlers after a failed one see the event again on redelivery, so
+// each must tolerate duplicates.
+func Fanout(handlers ...Handler) Handler {
+	return func(ctx context.Context, e *storage.OutboxEvent) error {
+		for _, h := range handlers {
+			if err := h(ctx, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+type Relay struct {
+	store     storage.Storage
+	handle    Handler
+	interval  time.Duration
+	batchSize int
+	retention time.Duration
+	wake      chan struct{}
+}
+
+type Option func(*Relay)
+
+// WithPollInterval sets how often the outbox is checked when nobody calls
+// Notify, e.g. for events committed by another replica.
+func WithPollInterval(d time.Duration) Option {
+	return func(r *Relay) {
+		r.interval = d
+	}
+}
+
+func WithBatchSize(n int) Option {
+	return func(r *Relay) {
+		r.batchSize = n
+	}
+}
+
+// WithRetention sets how long delivered events are kept before pruning.
+func WithRetention(d time.Duration) Option {
+	return func(r *Relay) {
+		r.retention = d
+	}
+}
+
+func NewRelay(store storage.Storage, handle Handler, opts ...Option) *Relay {
+	r := &Relay{
+		store:     store,
+		handle:    handle,
+		interval:  time.Second,
+		batchSize: 100,
+		retention: 24 * time.Hour,
+		wake:      make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Notify wakes the relay without waiting for the next poll. Call it after a
+// transaction that added events has committed.
+func (r *Relay) Notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run relays events until ctx is done. Events still pending then stay in the
+// outbox for the next run.
+func (r *Relay) Run(ctx context.Context) {
+	poll := time.NewTicker(r.interval)
+	defer poll.Stop()
+	prune := time.NewTicker(pruneInterval)
+	defer prune.Stop()
+
+	for {
+		r.drain(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+		case <-r.wake:
+		case <-prune.C:
+			n, err := r.store.PruneOutbox(ctx, time.Now().Add(-r.retention))
+			if err != nil {
+				slog.WarnContext(ctx, "outbox prune failed", "err", err)
+			} else if n > 0 {
+				slog.DebugContext(ctx, "outbox pruned", "events", n)
+			}
+		}
+	}
+}
+
+// drain relays batches until the outbox is empty or a batch fails.
+func (r *Relay) drain(ctx context.Context) {
+	for ctx.Err() == nil {
+		n, err := r.relayBatch(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.WarnContext(ctx, "outbox relay failed", "err", err)
+			}
+			return
+		}
+		if n < r.batchSize {
+			return
+		}
+	}
+}
+
+// relayBatch claims a batch, hands each event to the handler in order and
+// marks the handled ones delivered. Handlers run outside any transaction:
+// they may be slow or write to storage themselves, and in-memory storage
+// would block every other writer meanwhile. On failure the rest of the batch
+// is released for the next attempt.
+func (r *Relay) relayBatch(ctx context.Context) (int, error) {
+	events, err := r.store.ClaimOutboxEvents(ctx, time.Now(), claimLease, r.batchSize)
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+
+	var delivered []string
+	for i, e := range events {
+		if err := r.handle(ctx, e); err != nil {
+			slog.WarnContext(ctx, "outbox event not delivered", "event_id", e.ID, "topic", e.Topic, "err", err)
+			if err := r.store.ReleaseOutboxEvents(ctx, eventIDs(events[i:])); err != nil {
+				slog.WarnContext(ctx, "outbox release failed", "err", err)
+			}
+			break
+		}
+		delivered = append(delivered, e.ID)
+	}
+	if len(delivered) == 0 {
+		return 0, nil
+	}
+	if err := r.store.MarkOutboxDelivered(ctx, delivered); err != nil {
+		return 0, err
+	}
+	return len(delivered), nil
+}
+
+func eventIDs(events []*storage.OutboxEvent) []string {
+	ids := make([]string, len(events))
+	for i, e := range events {
+		ids[i] = e.ID
+	}
+	return ids
+}
@@ -0,0 +1,191 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"ozon-comments-graphql/internal/outbox"
+	"ozon-comments-graphql/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recorder struct {
+	mu       sync.Mutex
+	ids      []string
+	failures int
+}
+
+func (r *recorder) handle(_ context.Context, e *storage.OutboxEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failures > 0 {
+		r.failures--
+		return errors.New("broker unavailable")
+	}
+	r.ids = append(r.ids, e.ID)
+	return nil
+}
+
+func (r *recorder) handled() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.ids...)
+}
+
+func addEvents(t *testing.T, store storage.Storage, ids ...string) {
+	t.Helper()
+	err := store.WithTx(context.Background(), func(ctx context.Context) error {
+		for _, id := range ids {
+			err := store.AddOutboxEvent(ctx, &storage.OutboxEvent{ID: id, Topic: "test", CreatedAt: time.Now()})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func runRelay(t *testing.T, relay *outbox.Relay) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		relay.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestRelayDeliversInOrder(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	rec := &recorder{}
+	relay := outbox.NewRelay(store, rec.handle, outbox.WithPollInterval(time.Hour), outbox.WithBatchSize(2))
+	addEvents(t, store, "a", "b", "c")
+	runRelay(t, relay)
+
+	assert.Eventually(t, func() bool { return len(rec.handled()) == 3 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []string{"a", "b", "c"}, rec.handled())
+
+	addEvents(t, store, "d")
+	relay.Notify()
+	assert.Eventually(t, func() bool { return len(rec.handled()) == 4 }, time.Second, 5*time.Millisecond)
+
+	pending, err := store.PendingOutboxEvents(context.Background(), 10)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+func TestRelayRetriesFailedEvents(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	rec := &recorder{failures: 2}
+	relay := outbox.NewRelay(store, rec.handle, outbox.WithPollInterval(10*time.Millisecond))
+	addEvents(t, store, "a", "b")
+	runRelay(t, relay)
+
+	assert.Eventually(t, func() bool { return len(rec.handled()) == 2 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []string{"a", "b"}, rec.handled())
+}
+
+func TestRolledBackEventsAreNotRelayed(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	failure := errors.New("rollback")
+	err := store.WithTx(context.Background(), func(ctx context.Context) error {
+		require.NoError(t, store.AddOutboxEvent(ctx, &storage.OutboxEvent{ID: "a", Topic: "test"}))
+		return failure
+	})
+	assert.ErrorIs(t, err, failure)
+
+	pending, err := store.PendingOutboxEvents(context.Background(), 10)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+func TestPruneOutbox(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	addEvents(t, store, "a", "b")
+	require.NoError(t, store.MarkOutboxDelivered(ctx, []string{"a"}))
+
+	n, err := store.PruneOutbox(ctx, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	pending, err := store.PendingOutboxEvents(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, "b", pending[0].ID)
+}
+
+func TestRelayHandlersRunOutsideTransaction(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	handling := make(chan struct{})
+	release := make(chan struct{})
+	handle := func(context.Context, *storage.OutboxEvent) error {
+		close(handling)
+		<-release
+		return nil
+	}
+	relay := outbox.NewRelay(store, handle, outbox.WithPollInterval(time.Hour))
+	addEvents(t, store, "a")
+	runRelay(t, relay)
+	var once sync.Once
+	unblock := func() { once.Do(func() { close(release) }) }
+	t.Cleanup(unblock)
+	<-handling
+
+	// While the handler blocks, other writers must not wait for it.
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		store.CreatePost(context.Background(), "Title", "Content")
+	}()
+	select {
+	case <-written:
+	case <-time.After(time.Second):
+		t.Fatal("storage write blocked by a running outbox handler")
+	}
+	unblock()
+
+	assert.Eventually(t, func() bool {
+		pending, err := store.PendingOutboxEvents(context.Background(), 10)
+		return err == nil && len(pending) == 0
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestClaimOutboxEvents(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	addEvents(t, store, "a", "b", "c")
+	now := time.Now()
+
+	claimed, err := store.ClaimOutboxEvents(ctx, now, time.Minute, 2)
+	require.NoError(t, err)
+	require.Len(t, claimed, 2)
+	assert.Equal(t, "a", claimed[0].ID)
+	assert.Equal(t, "b", claimed[1].ID)
+
+	claimed, err = store.ClaimOutboxEvents(ctx, now, time.Minute, 10)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	assert.Equal(t, "c", claimed[0].ID)
+
+	require.NoError(t, store.ReleaseOutboxEvents(ctx, []string{"b"}))
+	claimed, err = store.ClaimOutboxEvents(ctx, now, time.Minute, 10)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	assert.Equal(t, "b", claimed[0].ID)
+
+	// Claims of a relay that died expire with the lease.
+	claimed, err = store.ClaimOutboxEvents(ctx, now.Add(time.Minute), time.Minute, 10)
+	require.NoError(t, err)
+	assert.Len(t, claimed, 3)
+}
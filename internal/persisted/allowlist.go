@@ -0,0 +1,75 @@
+package persisted
+
+import (
+	"context"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/errcode"
+	"github.com/go-viper/mapstructure/v2"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+const (
+	errNotAllowedCode = "OPERATION_NOT_ALLOWED"
+	errNotFoundCode   = "PERSISTED_QUERY_NOT_FOUND"
+)
+
+// AllowList is a trusted-documents extension: it only executes operations
+// listed in the manifest. Clients may send the full document, only its hash
+// in the APQ persistedQuery extension, or both.
+type AllowList struct {
+	Manifest *Manifest
+}
+
+var _ interface {
+	graphql.OperationParameterMutator
+	graphql.HandlerExtension
+} = AllowList{}
+
+func (AllowList) ExtensionName() string {
+	return "PersistedQueryAllowList"
+}
+
+func (a AllowList) Validate(graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (a AllowList) MutateOperationParameters(_ context.Context, raw *graphql.RawParams) *gqlerror.Error {
+	var ext struct {
+		Sha256 string `mapstructure:"sha256Hash"`
+	}
+	if raw.Extensions["persistedQuery"] != nil {
+		if err := mapstructure.Decode(raw.Extensions["persistedQuery"], &ext); err != nil {
+			return gqlerror.Errorf("invalid persistedQuery extension data")
+		}
+	}
+
+	if raw.Query == "" {
+		if ext.Sha256 == "" {
+			return notAllowed()
+		}
+		q, ok := a.Manifest.Lookup(ext.Sha256)
+		if !ok {
+			err := gqlerror.Errorf("PersistedQueryNotFound")
+			errcode.Set(err, errNotFoundCode)
+			return err
+		}
+		raw.Query = q
+		return nil
+	}
+
+	hash := Hash(raw.Query)
+	if ext.Sha256 != "" && ext.Sha256 != hash {
+		return gqlerror.Errorf("provided persisted query hash does not match query")
+	}
+	if _, ok := a.Manifest.Lookup(hash); !ok {
+		return notAllowed()
+	}
+	return nil
+}
+
+func notAllowed() *gqlerror.Error {
+	err := gqlerror.Errorf("operation is not in the persisted query allow-list")
+	errcode.Set(err, errNotAllowedCode)
+	return err
+}
@@ -0,0 +1,71 @@
+package persisted
+
+import (
+	"context"
+	"log/slog"
+	"ozon-comments-graphql/internal/storage"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+)
+
+// MaxSharedQueryLength is the longest query SharedCache saves to storage.
+// Anyone may register a query, so longer ones stay in the bounded local
+// cache only.
+const MaxSharedQueryLength = 64 << 10
+
+// SharedCache is an APQ cache backed by storage, so a query registered on
+// one replica is known to all of them. A local cache sits in front of
+// storage to keep hot hashes off the database. Saved queries are kept for a
+// limited time, see Prune.
+type SharedCache struct {
+	Local graphql.Cache[string]
+	Store storage.Storage
+}
+
+var _ graphql.Cache[string] = SharedCache{}
+
+func (c SharedCache) Get(ctx context.Context, hash string) (string, bool) {
+	if q, ok := c.Local.Get(ctx, hash); ok {
+		return q, true
+	}
+
+	q, err := c.Store.GetPersistedQuery(ctx, hash)
+	if err != nil {
+		return "", false
+	}
+	c.Local.Add(ctx, hash, q)
+	return q, true
+}
+
+func (c SharedCache) Add(ctx context.Context, hash, query string) {
+	c.Local.Add(ctx, hash, query)
+	if len(query) > MaxSharedQueryLength {
+		return
+	}
+	if err := c.Store.SavePersistedQuery(ctx, hash, query); err != nil {
+		slog.WarnContext(ctx, "save persisted query failed", "hash", hash, "err", err)
+	}
+}
+
+// Prune deletes shared queries older than ttl from store, checking at most
+// hourly, until ctx is done. Clients of a pruned hash are told it is unknown
+// and register it again, so only queries still in use come back.
+func Prune(ctx context.Context, store storage.Storage, ttl time.Duration) {
+	tick := time.NewTicker(min(ttl, time.Hour))
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			n, err := store.PrunePersistedQueries(ctx, time.Now().Add(-ttl))
+			if err != nil {
+				slog.WarnContext(ctx, "persisted query prune failed", "err", err)
+			} else if n > 0 {
+				slog.DebugContext(ctx, "persisted queries pruned", "queries", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package persisted
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+	"github.com/vektah/gqlparser/v2/validator"
+)
+
+// Generate builds a manifest from client .graphql/.gql files, walking
+// directories recursively. Each file is one document and is hashed exactly
+// as written, so clients must send the file contents unchanged. Documents
+// are validated against schema.
+func Generate(schema *ast.Schema, paths []string) (*Manifest, error) {
+	m := NewManifest()
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || !isDocument(path) {
+				return nil
+			}
+			return addFile(m, schema, path)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func isDocument(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".graphql" || ext == ".gql"
+}
+
+func addFile(m *Manifest, schema *ast.Schema, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	query := string(data)
+	doc, parseErr := parser.ParseQuery(&ast.Source{Name: path, Input: query})
+	if parseErr != nil {
+		return fmt.Errorf("%s: %w", path, parseErr)
+	}
+	if errs := validator.Validate(schema, doc); len(errs) > 0 {
+		return fmt.Errorf("%s: %w", path, errs)
+	}
+	if len(doc.Operations) == 0 {
+		return fmt.Errorf("%s: no operations", path)
+	}
+
+	m.Add(query)
+	return nil
+}
@@ -0,0 +1,108 @@
+package persisted
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+const manifestVersion = 1
+
+// Manifest lists the trusted operations, keyed by the SHA-256 hash of the
+// exact document text clients send.
+type Manifest struct {
+	Version    int               `json:"version"`
+	Operations map[string]string `json:"operations"`
+}
+
+func NewManifest() *Manifest {
+	return &Manifest{Version: manifestVersion, Operations: make(map[string]string)}
+}
+
+func Hash(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+func (m *Manifest) Add(query string) string {
+	h := Hash(query)
+	m.Operations[h] = query
+	return h
+}
+
+func (m *Manifest) Lookup(hash string) (string, bool) {
+	q, ok := m.Operations[hash]
+	return q, ok
+}
+
+// OperationNames returns the names of the operations in the manifest's
+// documents; anonymous operations have none. A nil manifest has no names.
+func (m *Manifest) OperationNames() []string {
+	if m == nil {
+		return nil
+	}
+	var names []string
+	for _, query := range m.Operations {
+		doc, err := parser.ParseQuery(&ast.Source{Input: query})
+		if err != nil {
+			continue
+		}
+		for _, op := range doc.Operations {
+			if op.Name != "" {
+				names = append(names, op.Name)
+			}
+		}
+	}
+	return names
+}
+
+// LoadManifest reads a manifest and checks that every entry's hash matches
+// its document.
+func LoadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("manifest: %w", err)
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("manifest %s: %w", path, err)
+	}
+	if m.Version != manifestVersion {
+		return nil, fmt.Errorf("manifest %s: unsupported version %d", path, m.Version)
+	}
+	for hash, query := range m.Operations {
+		if Hash(query) != hash {
+			return nil, fmt.Errorf("manifest %s: hash %s does not match its document", path, hash)
+		}
+	}
+	if m.Operations == nil {
+		m.Operations = make(map[string]string)
+	}
+	return &m, nil
+}
+
+func (m *Manifest) Encode(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
+
+func (m *Manifest) Write(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := m.Encode(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,191 @@
+package persisted_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ozon-comments-graphql/graph"
+	"ozon-comments-graphql/internal/persisted"
+	"ozon-comments-graphql/internal/storage"
+
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/lru"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const postsQuery = "query Posts {\n  posts { items { id title } }\n}\n"
+
+func newSchema() graph.Config {
+	return graph.Config{Resolvers: &graph.Resolver{Store: storage.NewMemoryStorage(), Broker: graph.NewBroker()}}
+}
+
+type gqlResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message    string                 `json:"message"`
+		Extensions map[string]interface{} `json:"extensions"`
+	} `json:"errors"`
+}
+
+func post(t *testing.T, h http.Handler, body map[string]interface{}) gqlResponse {
+	t.Helper()
+
+	data, err := json.Marshal(body)
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	var resp gqlResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp), rec.Body.String())
+	return resp
+}
+
+func persistedExt(hash string) map[string]interface{} {
+	return map[string]interface{}{
+		"persistedQuery": map[string]interface{}{"version": 1, "sha256Hash": hash},
+	}
+}
+
+func TestAllowList(t *testing.T) {
+	manifest := persisted.NewManifest()
+	hash := manifest.Add(postsQuery)
+
+	srv := handler.New(graph.NewExecutableSchema(newSchema()))
+	srv.AddTransport(transport.POST{})
+	srv.Use(persisted.AllowList{Manifest: manifest})
+
+	t.Run("listed query", func(t *testing.T) {
+		resp := post(t, srv, map[string]interface{}{"query": postsQuery})
+		assert.Empty(t, resp.Errors)
+		assert.JSONEq(t, `{"posts":{"items":[]}}`, string(resp.Data))
+	})
+
+	t.Run("hash only", func(t *testing.T) {
+		resp := post(t, srv, map[string]interface{}{"extensions": persistedExt(hash)})
+		assert.Empty(t, resp.Errors)
+		assert.JSONEq(t, `{"posts":{"items":[]}}`, string(resp.Data))
+	})
+
+	t.Run("unlisted query", func(t *testing.T) {
+		resp := post(t, srv, map[string]interface{}{"query": "{ posts { items { id } } }"})
+		require.Len(t, resp.Errors, 1)
+		assert.Equal(t, "OPERATION_NOT_ALLOWED", resp.Errors[0].Extensions["code"])
+	})
+
+	t.Run("unknown hash", func(t *testing.T) {
+		resp := post(t, srv, map[string]interface{}{"extensions": persistedExt(persisted.Hash("{ __typename }"))})
+		require.Len(t, resp.Errors, 1)
+		assert.Equal(t, "PERSISTED_QUERY_NOT_FOUND", resp.Errors[0].Extensions["code"])
+	})
+
+	t.Run("hash mismatch", func(t *testing.T) {
+		resp := post(t, srv, map[string]interface{}{
+			"query":      "{ posts { items { id } } }",
+			"extensions": persistedExt(hash),
+		})
+		require.Len(t, resp.Errors, 1)
+	})
+}
+
+func TestGenerate(t *testing.T) {
+	schema := graph.NewExecutableSchema(newSchema()).Schema()
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "nested"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "posts.graphql"), []byte(postsQuery), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "nested", "post.gql"),
+		[]byte("query Post($id: ID!) { post(id: $id) { title } }"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("not a document"), 0o644))
+
+	manifest, err := persisted.Generate(schema, []string{dir})
+	require.NoError(t, err)
+	assert.Len(t, manifest.Operations, 2)
+	q, ok := manifest.Lookup(persisted.Hash(postsQuery))
+	assert.True(t, ok)
+	assert.Equal(t, postsQuery, q)
+
+	path := filepath.Join(dir, "manifest.json")
+	require.NoError(t, manifest.Write(path))
+	loaded, err := persisted.LoadManifest(path)
+	require.NoError(t, err)
+	assert.Equal(t, manifest.Operations, loaded.Operations)
+	assert.ElementsMatch(t, []string{"Posts", "Post"}, loaded.OperationNames())
+
+	t.Run("invalid document", func(t *testing.T) {
+		bad := filepath.Join(t.TempDir(), "bad.graphql")
+		require.NoError(t, os.WriteFile(bad, []byte("{ posts { nope } }"), 0o644))
+		_, err := persisted.Generate(schema, []string{bad})
+		assert.ErrorContains(t, err, "bad.graphql")
+	})
+}
+
+func TestLoadManifestRejectsTamperedHash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "manifest.json")
+	data := `{"version":1,"operations":{"` + persisted.Hash("{ a }") + `":"{ b }"}}`
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+
+	_, err := persisted.LoadManifest(path)
+	assert.ErrorContains(t, err, "does not match")
+}
+
+func TestSharedCache(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	replicaA := persisted.SharedCache{Local: lru.New[string](10), Store: store}
+	replicaB := persisted.SharedCache{Local: lru.New[string](10), Store: store}
+
+	_, ok := replicaB.Get(ctx, "abc")
+	assert.False(t, ok)
+
+	replicaA.Add(ctx, "abc", "{ posts { items { id } } }")
+	q, ok := replicaB.Get(ctx, "abc")
+	assert.True(t, ok)
+	assert.Equal(t, "{ posts { items { id } } }", q)
+}
+
+func TestSharedCacheKeepsLongQueriesLocal(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	replicaA := persisted.SharedCache{Local: lru.New[string](10), Store: store}
+	replicaB := persisted.SharedCache{Local: lru.New[string](10), Store: store}
+
+	long := "{ posts { items { id } } }" + strings.Repeat(" ", persisted.MaxSharedQueryLength)
+	replicaA.Add(ctx, "long", long)
+
+	q, ok := replicaA.Get(ctx, "long")
+	assert.True(t, ok)
+	assert.Equal(t, long, q)
+	_, ok = replicaB.Get(ctx, "long")
+	assert.False(t, ok)
+}
+
+func TestPrune(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	store := storage.NewMemoryStorage()
+	require.NoError(t, store.SavePersistedQuery(ctx, "old", "{ posts { items { id } } }"))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		persisted.Prune(ctx, store, 10*time.Millisecond)
+	}()
+	assert.Eventually(t, func() bool {
+		_, err := store.GetPersistedQuery(ctx, "old")
+		return errors.Is(err, storage.ErrNotFound)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-done
+}
@@ -0,0 +1,132 @@
+// Package seed fills storage with generated posts and reply trees. The same
+// seed always produces the same data, IDs and timestamps included, so
+// benchmarks and bug reports can be reproduced.
+package seed
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"ozon-comments-graphql/internal/models"
+	"ozon-comments-graphql/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+type Options struct {
+	Seed int64
+	// Posts is the number of posts to create, each with Comments comments.
+	Posts    int
+	Comments int
+	// MaxDepth bounds reply chains; 0 creates only top-level comments.
+	MaxDepth int
+	// ReplyRatio is the share of comments that answer an earlier comment.
+	ReplyRatio float64
+	// Start is the creation time of the first post; later records are
+	// spaced a few seconds apart.
+	Start time.Time
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Seed:       1,
+		Posts:      10,
+		Comments:   50,
+		MaxDepth:   8,
+		ReplyRatio: 0.7,
+		Start:      time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+type Stats struct {
+	Posts    int
+	Comments int
+	MaxDepth int
+}
+
+var words = strings.Fields(`lorem ipsum dolor sit amet consectetur adipiscing elit sed do
+	eiusmod tempor incididunt ut labore et dolore magna aliqua enim ad minim veniam quis
+	nostrud exercitation ullamco laboris nisi aliquip ex ea commodo consequat`)
+
+type generator struct {
+	rng *rand.Rand
+	now time.Time
+}
+
+func (g *generator) id() string {
+	return uuid.Must(uuid.NewRandomFromReader(g.rng)).String()
+}
+
+func (g *generator) text(min, max int) string {
+	n := min + g.rng.Intn(max-min+1)
+	out := make([]string, n)
+	for i := range out {
+		out[i] = words[g.rng.Intn(len(words))]
+	}
+	return strings.Join(out, " ")
+}
+
+func (g *generator) tick() time.Time {
+	g.now = g.now.Add(time.Duration(1+g.rng.Intn(5)) * time.Second)
+	return g.now
+}
+
+// Run generates the data set described by opts and stores it through the
+// import API, which keeps the generated IDs and timestamps.
+func Run(ctx context.Context, store storage.Storage, opts Options) (Stats, error) {
+	g := &generator{rng: rand.New(rand.NewSource(opts.Seed)), now: opts.Start}
+	var stats Stats
+
+	for i := 0; i < opts.Posts; i++ {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		post := &models.Post{
+			ID:        g.id(),
+			Title:     g.text(2, 6),
+			Content:   g.text(20, 60),
+			CreatedAt: g.tick(),
+		}
+		if err := store.ImportPost(ctx, post); err != nil {
+			return stats, fmt.Errorf("post %s: %w", post.ID, err)
+		}
+		stats.Posts++
+
+		type node struct {
+			id    string
+			depth int
+		}
+		var tree []node
+		for j := 0; j < opts.Comments; j++ {
+			c := &models.Comment{ID: g.id(), PostID: post.ID}
+			depth := 0
+			// Favour recent comments as parents so chains grow deep.
+			if len(tree) > 0 && g.rng.Float64() < opts.ReplyRatio {
+				back := int(g.rng.ExpFloat64() * 3)
+				if back >= len(tree) {
+					back = len(tree) - 1
+				}
+				parent := tree[len(tree)-1-back]
+				if parent.depth < opts.MaxDepth {
+					c.ParentID = &parent.id
+					depth = parent.depth + 1
+				}
+			}
+			c.Content = g.text(3, 30)
+			c.CreatedAt = g.tick()
+
+			if err := store.ImportComment(ctx, c); err != nil {
+				return stats, fmt.Errorf("comment %s: %w", c.ID, err)
+			}
+			tree = append(tree, node{id: c.ID, depth: depth})
+			stats.Comments++
+			if depth > stats.MaxDepth {
+				stats.MaxDepth = depth
+			}
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,54 @@
+package seed_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"ozon-comments-graphql/internal/seed"
+	"ozon-comments-graphql/internal/storage"
+	"ozon-comments-graphql/internal/transfer"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func export(t *testing.T, opts seed.Options) (seed.Stats, string) {
+	t.Helper()
+
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	stats, err := seed.Run(ctx, store, opts)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	_, err = transfer.Export(ctx, store, &buf)
+	require.NoError(t, err)
+	return stats, buf.String()
+}
+
+func TestRunIsDeterministic(t *testing.T) {
+	opts := seed.DefaultOptions()
+	opts.Posts, opts.Comments = 3, 40
+
+	stats, first := export(t, opts)
+	assert.Equal(t, 3, stats.Posts)
+	assert.Equal(t, 120, stats.Comments)
+	assert.Greater(t, stats.MaxDepth, 2)
+	assert.LessOrEqual(t, stats.MaxDepth, opts.MaxDepth)
+
+	_, second := export(t, opts)
+	assert.Equal(t, first, second)
+
+	opts.Seed++
+	_, other := export(t, opts)
+	assert.NotEqual(t, first, other)
+}
+
+func TestRunFlat(t *testing.T) {
+	opts := seed.DefaultOptions()
+	opts.Posts, opts.Comments, opts.MaxDepth = 1, 20, 0
+
+	stats, _ := export(t, opts)
+	assert.Equal(t, 0, stats.MaxDepth)
+}
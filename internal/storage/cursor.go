@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type postCursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+func encodePostCursor(createdAt time.Time, id string) string {
+	raw := createdAt.UTC().Format(time.RFC3339Nano) + "|" + id
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodePostCursor(cursor string) (postCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return postCursor{}, ErrInvalidCursor
+	}
+
+	ts, id, ok := strings.Cut(string(raw), "|")
+	if !ok || id == "" {
+		return postCursor{}, ErrInvalidCursor
+	}
+
+	createdAt, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return postCursor{}, ErrInvalidCursor
+	}
+	return postCursor{CreatedAt: createdAt, ID: id}, nil
+}
+
+// before reports whether a post sorts strictly before the cursor position in
+// the given order. Ties on created_at are broken by id.
+func (c postCursor) before(createdAt time.Time, id string, order PostOrder) bool {
+	if order == OrderCreatedAtAsc {
+		if !createdAt.Equal(c.CreatedAt) {
+			return createdAt.Before(c.CreatedAt)
+		}
+		return id < c.ID
+	}
+	if !createdAt.Equal(c.CreatedAt) {
+		return createdAt.After(c.CreatedAt)
+	}
+	return id > c.ID
+}
@@ -3,13 +3,148 @@ package storage
 import (
 	"context"
 	"ozon-comments-graphql/internal/models"
+	"time"
 )
 
+type PostOrder int
+
+const (
+	OrderCreatedAtDesc PostOrder = iota
+	OrderCreatedAtAsc
+)
+
+type PostFilter struct {
+	CommentsDisabled *bool
+	CreatedAfter     *time.Time
+	CreatedBefore    *time.Time
+	TitleContains    string
+}
+
+type ListPostsOptions struct {
+	Filter  PostFilter
+	OrderBy PostOrder
+	First   int
+	After   *string
+}
+
+// OutboxEvent is a message waiting to be published to subscribers. It is
+// written in the same transaction as the change it describes, so it exists
+// if and only if the change was committed.
+type OutboxEvent struct {
+	// ID stays the same across redeliveries, letting consumers drop
+	// duplicates.
+	ID        string
+	Topic     string
+	Payload   []byte
+	CreatedAt time.Time
+}
+
+type ListNotificationsOptions struct {
+	First      int
+	After      *string
+	UnreadOnly bool
+}
+
+type WebhookDeliveryFilter struct {
+	WebhookID *string
+	Status    *string
+}
+
 type Storage interface {
 	CreatePost(ctx context.Context, title, content string) *models.Post
-	ToggleComments(ctx context.Context, id string, disabled bool) (*models.Post, error)
-	ListPosts(ctx context.Context) []*models.Post
+	UpdatePost(ctx context.Context, id string, title, content *string) (*models.Post, error)
+	DeletePost(ctx context.Context, id string, soft bool) (*models.Post, error)
+	// ToggleComments sets whether comments on a post are disabled and reports
+	// whether that changed anything. Of concurrent calls setting the same
+	// value, exactly one reports a change.
+	ToggleComments(ctx context.Context, id string, disabled bool) (*models.Post, bool, error)
+	ListPosts(ctx context.Context, opts ListPostsOptions) ([]*models.Post, *string, error)
 	GetPost(ctx context.Context, id string) (*models.Post, error)
-	CreateComment(ctx context.Context, postID string, parentID *string, content string) (*models.Comment, error)
-	ListComments(ctx context.Context, postID string, first int, afterID *string) ([]*models.Comment, *string)
+	// CreateComment returns ErrNotFound when the post or the parent comment
+	// is missing and ErrWrongPost when the parent is on another post.
+	CreateComment(ctx context.Context, postID string, parentID, author *string, content string) (*models.Comment, error)
+	// ListComments returns an empty page for a missing or soft-deleted post.
+	ListComments(ctx context.Context, postID string, first int, afterID *string) ([]*models.Comment, *string, error)
+	// ListPostComments returns every comment on a post, soft-deleted or not,
+	// oldest first. Inside WithTx no comment can be added to the post until
+	// the transaction ends, so a hard DeletePost that follows removes exactly
+	// these.
+	ListPostComments(ctx context.Context, postID string) ([]*models.Comment, error)
+	GetComment(ctx context.Context, id string) (*models.Comment, error)
+	// CommentAncestry returns the comment with the given ID followed by the
+	// comments above it in its thread, nearest first, at most limit in all.
+	// It returns ErrNotFound when the comment does not exist.
+	CommentAncestry(ctx context.Context, id string, limit int) ([]*models.Comment, error)
+	GetPersistedQuery(ctx context.Context, hash string) (string, error)
+	SavePersistedQuery(ctx context.Context, hash, query string) error
+	// PrunePersistedQueries deletes queries saved before the given time.
+	// Clients of a pruned hash get PersistedQueryNotFound and register it
+	// again.
+	PrunePersistedQueries(ctx context.Context, savedBefore time.Time) (int, error)
+	// ExportPosts calls fn for every post, soft-deleted ones included, oldest
+	// first. ExportComments calls fn for every comment, parents always before
+	// their replies. Both stop at the first error fn returns.
+	ExportPosts(ctx context.Context, fn func(*models.Post) error) error
+	ExportComments(ctx context.Context, fn func(*models.Comment) error) error
+	// ImportPost and ImportComment store records as they are, keeping IDs and
+	// timestamps. Comment counters are derived from imported comments.
+	// ImportComment returns ErrNotFound when the post or parent is missing and
+	// ErrConflict when the ID is taken.
+	ImportPost(ctx context.Context, p *models.Post) error
+	ImportComment(ctx context.Context, c *models.Comment) error
+	// AddOutboxEvent stores e; call it inside WithTx alongside the change.
+	AddOutboxEvent(ctx context.Context, e *OutboxEvent) error
+	// PendingOutboxEvents returns up to limit undelivered events, oldest
+	// first, whether claimed or not.
+	PendingOutboxEvents(ctx context.Context, limit int) ([]*OutboxEvent, error)
+	// ClaimOutboxEvents returns up to limit undelivered events not claimed by
+	// anyone else at now, oldest first, and claims them until now+lease, so
+	// concurrent relays never hand out the same event. A relay that dies
+	// mid-batch leaves its events to be claimed again once the lease expires.
+	ClaimOutboxEvents(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*OutboxEvent, error)
+	// ReleaseOutboxEvents drops the claim on undelivered events, making them
+	// available again at once.
+	ReleaseOutboxEvents(ctx context.Context, ids []string) error
+	MarkOutboxDelivered(ctx context.Context, ids []string) error
+	// PruneOutbox deletes events delivered before the given time.
+	PruneOutbox(ctx context.Context, deliveredBefore time.Time) (int, error)
+	AddNotification(ctx context.Context, n *models.Notification) error
+	// ListNotifications returns a user's notifications, newest first.
+	ListNotifications(ctx context.Context, user string, opts ListNotificationsOptions) ([]*models.Notification, *string, error)
+	// MarkNotificationsRead marks the given notifications of user as read,
+	// or all of them when ids is nil, and returns how many were unread.
+	MarkNotificationsRead(ctx context.Context, user string, ids []string) (int, error)
+	AddAttachment(ctx context.Context, a *models.Attachment) error
+	// ListAttachments returns the attachments of a comment in upload order.
+	ListAttachments(ctx context.Context, commentID string) ([]*models.Attachment, error)
+	// ListAttachmentsFor does the same for several comments at once, keyed by
+	// comment ID; comments without attachments are left out.
+	ListAttachmentsFor(ctx context.Context, commentIDs []string) (map[string][]*models.Attachment, error)
+	// ListPostAttachments returns the attachments of every comment on a post.
+	ListPostAttachments(ctx context.Context, postID string) ([]*models.Attachment, error)
+	CreateWebhook(ctx context.Context, w *models.Webhook) error
+	ListWebhooks(ctx context.Context) ([]*models.Webhook, error)
+	// AddWebhookDelivery queues d unless a delivery of the same event to the
+	// same webhook already exists, which makes relaying an event twice safe.
+	AddWebhookDelivery(ctx context.Context, d *models.WebhookDelivery) error
+	// ClaimWebhookDeliveries returns up to limit pending deliveries due at
+	// now and pushes their next attempt lease into the future, so no other
+	// dispatcher picks them up meanwhile. A dispatcher that dies mid-send
+	// leaves them to be retried once the lease expires.
+	ClaimWebhookDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*models.WebhookDelivery, error)
+	// UpdateWebhookDelivery saves the outcome of an attempt.
+	UpdateWebhookDelivery(ctx context.Context, d *models.WebhookDelivery) error
+	// ListWebhookDeliveries returns the newest deliveries first.
+	ListWebhookDeliveries(ctx context.Context, filter WebhookDeliveryFilter, limit int) ([]*models.WebhookDelivery, error)
+	// PruneWebhookDeliveries deletes DELIVERED deliveries delivered before
+	// the given time and DEAD ones created before it.
+	PruneWebhookDeliveries(ctx context.Context, finishedBefore time.Time) (int, error)
+	// WithTx runs fn as one unit of work: every call made with the ctx
+	// passed to fn commits when fn returns nil and rolls back when it
+	// returns an error or panics. A nested call acts as a savepoint: its
+	// failure rolls back only its own changes, which the outer call may
+	// recover from, while its success still depends on the outer commit.
+	WithTx(ctx context.Context, fn func(ctx context.Context) error) error
+	Health(ctx context.Context) error
+	Close()
 }
@@ -3,8 +3,10 @@ package storage
 import (
 	"context"
 	"errors"
+	"maps"
 	"ozon-comments-graphql/internal/models"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -12,30 +14,54 @@ import (
 )
 
 var (
-	ErrNotFound   = errors.New("not found")
-	ErrForbidden  = errors.New("comments disabled")
-	ErrTooLong    = errors.New("comment too long")
-	maxCommentLen = 2000
+	ErrNotFound  = errors.New("not found")
+	ErrForbidden = errors.New("comments disabled")
+	ErrTooLong   = errors.New("comment too long")
+	ErrConflict  = errors.New("already exists")
+	ErrWrongPost = errors.New("parent comment belongs to another post")
 )
 
+// MemoryStorage keeps everything in maps guarded by one lock. Stored records
+// are shared with callers, so they are replaced rather than modified in
+// place.
 type MemoryStorage struct {
-	mu       sync.RWMutex
-	posts    map[string]*models.Post
-	comments map[string]*models.Comment
-	byPost   map[string][]*models.Comment
+	mu            sync.RWMutex
+	posts         map[string]*models.Post
+	comments      map[string]*models.Comment
+	byPost        map[string][]*models.Comment
+	queries       map[string]persistedQuery
+	outbox        []*outboxEntry
+	notifications []*models.Notification
+	attachments   map[string][]*models.Attachment
+	webhooks      []*models.Webhook
+	deliveries    []*models.WebhookDelivery
+	maxCommentLen int
 }
 
-func NewMemoryStorage() *MemoryStorage {
+type persistedQuery struct {
+	query   string
+	savedAt time.Time
+}
+
+type outboxEntry struct {
+	event        OutboxEvent
+	deliveredAt  *time.Time
+	claimedUntil *time.Time
+}
+
+func NewMemoryStorage(opts ...Option) *MemoryStorage {
 	return &MemoryStorage{
-		posts:    make(map[string]*models.Post),
-		comments: make(map[string]*models.Comment),
-		byPost:   make(map[string][]*models.Comment),
+		maxCommentLen: newOptions(opts).maxCommentLen,
+		posts:         make(map[string]*models.Post),
+		comments:      make(map[string]*models.Comment),
+		byPost:        make(map[string][]*models.Comment),
+		queries:       make(map[string]persistedQuery),
+		attachments:   make(map[string][]*models.Attachment),
 	}
 }
 
-func (s *MemoryStorage) CreatePost(_ context.Context, title, content string) *models.Post {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+func (s *MemoryStorage) CreatePost(ctx context.Context, title, content string) *models.Post {
+	defer s.lock(ctx)()
 
 	p := &models.Post{
 		ID:        uuid.NewString(),
@@ -44,59 +70,189 @@ func (s *MemoryStorage) CreatePost(_ context.Context, title, content string) *mo
 		CreatedAt: time.Now(),
 	}
 	s.posts[p.ID] = p
+	s.record(ctx, func() { delete(s.posts, p.ID) })
 	return p
 }
 
-func (s *MemoryStorage) ToggleComments(_ context.Context, id string, d bool) (*models.Post, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+func (s *MemoryStorage) UpdatePost(ctx context.Context, id string, title, content *string) (*models.Post, error) {
+	defer s.lock(ctx)()
+
+	p, ok := s.livePost(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cp := *p
+	if title != nil {
+		cp.Title = *title
+	}
+	if content != nil {
+		cp.Content = *content
+	}
+	s.setPost(ctx, &cp)
+	return &cp, nil
+}
+
+// DeletePost removes a post together with all of its comments. A soft delete
+// only marks the post as deleted, keeping the comments in place.
+func (s *MemoryStorage) DeletePost(ctx context.Context, id string, soft bool) (*models.Post, error) {
+	defer s.lock(ctx)()
 
 	p, ok := s.posts[id]
 	if !ok {
 		return nil, ErrNotFound
 	}
-	p.CommentsDisabled = d
+
+	if soft {
+		if p.DeletedAt != nil {
+			return nil, ErrNotFound
+		}
+		cp := *p
+		now := time.Now()
+		cp.DeletedAt = &now
+		s.setPost(ctx, &cp)
+		return &cp, nil
+	}
+
+	comments := s.byPost[id]
+	attachments := make(map[string][]*models.Attachment)
+	for _, c := range comments {
+		if as, ok := s.attachments[c.ID]; ok {
+			attachments[c.ID] = as
+		}
+		delete(s.comments, c.ID)
+		delete(s.attachments, c.ID)
+	}
+	delete(s.byPost, id)
+	delete(s.posts, id)
+	s.record(ctx, func() {
+		s.posts[id] = p
+		if comments != nil {
+			s.byPost[id] = comments
+		}
+		for _, c := range comments {
+			s.comments[c.ID] = c
+		}
+		for cid, as := range attachments {
+			s.attachments[cid] = as
+		}
+	})
 	return p, nil
 }
 
-func (s *MemoryStorage) ListPosts(_ context.Context) []*models.Post {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+func (s *MemoryStorage) livePost(id string) (*models.Post, bool) {
+	p, ok := s.posts[id]
+	if !ok || p.DeletedAt != nil {
+		return nil, false
+	}
+	return p, true
+}
+
+func (s *MemoryStorage) ToggleComments(ctx context.Context, id string, d bool) (*models.Post, bool, error) {
+	defer s.lock(ctx)()
+
+	p, ok := s.livePost(id)
+	if !ok {
+		return nil, false, ErrNotFound
+	}
+	if p.CommentsDisabled == d {
+		return p, false, nil
+	}
+	cp := *p
+	cp.CommentsDisabled = d
+	s.setPost(ctx, &cp)
+	return &cp, true, nil
+}
+
+func (s *MemoryStorage) ListPosts(ctx context.Context, opts ListPostsOptions) ([]*models.Post, *string, error) {
+	var cur *postCursor
+	if opts.After != nil {
+		c, err := decodePostCursor(*opts.After)
+		if err != nil {
+			return nil, nil, err
+		}
+		cur = &c
+	}
+
+	defer s.rlock(ctx)()
 
 	out := make([]*models.Post, 0, len(s.posts))
 	for _, p := range s.posts {
+		if p.DeletedAt != nil || !matchPostFilter(p, opts.Filter) {
+			continue
+		}
+		if cur != nil && (cur.before(p.CreatedAt, p.ID, opts.OrderBy) || p.ID == cur.ID) {
+			continue
+		}
 		out = append(out, p)
 	}
-	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
-	return out
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			if opts.OrderBy == OrderCreatedAtAsc {
+				return out[i].ID < out[j].ID
+			}
+			return out[i].ID > out[j].ID
+		}
+		if opts.OrderBy == OrderCreatedAtAsc {
+			return out[i].CreatedAt.Before(out[j].CreatedAt)
+		}
+		return out[i].CreatedAt.After(out[j].CreatedAt)
+	})
+
+	if opts.First <= 0 || len(out) <= opts.First {
+		return out, nil, nil
+	}
+
+	items := out[:opts.First]
+	last := items[len(items)-1]
+	next := encodePostCursor(last.CreatedAt, last.ID)
+	return items, &next, nil
+}
+
+func matchPostFilter(p *models.Post, f PostFilter) bool {
+	if f.CommentsDisabled != nil && p.CommentsDisabled != *f.CommentsDisabled {
+		return false
+	}
+	if f.CreatedAfter != nil && !p.CreatedAt.After(*f.CreatedAfter) {
+		return false
+	}
+	if f.CreatedBefore != nil && !p.CreatedAt.Before(*f.CreatedBefore) {
+		return false
+	}
+	if f.TitleContains != "" && !strings.Contains(strings.ToLower(p.Title), strings.ToLower(f.TitleContains)) {
+		return false
+	}
+	return true
 }
 
-func (s *MemoryStorage) GetPost(_ context.Context, id string) (*models.Post, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+func (s *MemoryStorage) GetPost(ctx context.Context, id string) (*models.Post, error) {
+	defer s.rlock(ctx)()
 
-	p, ok := s.posts[id]
+	p, ok := s.livePost(id)
 	if !ok {
 		return nil, ErrNotFound
 	}
 	return p, nil
 }
 
-func (s *MemoryStorage) CreateComment(_ context.Context, postID string, parentID *string, text string) (*models.Comment, error) {
-	if len(text) > maxCommentLen {
+func (s *MemoryStorage) CreateComment(ctx context.Context, postID string, parentID, author *string, text string) (*models.Comment, error) {
+	if len(text) > s.maxCommentLen {
 		return nil, ErrTooLong
 	}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	defer s.lock(ctx)()
 
-	p, ok := s.posts[postID]
+	p, ok := s.livePost(postID)
 	if !ok {
 		return nil, ErrNotFound
 	}
 	if p.CommentsDisabled {
 		return nil, ErrForbidden
 	}
+	if parentID != nil {
+		if err := s.checkParent(postID, *parentID); err != nil {
+			return nil, err
+		}
+	}
 
 	c := &models.Comment{
 		ID:        uuid.NewString(),
@@ -104,19 +260,38 @@ func (s *MemoryStorage) CreateComment(_ context.Context, postID string, parentID
 		ParentID:  parentID,
 		Content:   text,
 		CreatedAt: time.Now(),
+		Author:    author,
 	}
 	s.comments[c.ID] = c
-	s.byPost[postID] = append(s.byPost[postID], c)
+	s.appendComment(ctx, postID, c)
+	cp := *p
+	cp.CommentCount++
+	cp.LastCommentAt = &c.CreatedAt
+	s.setPost(ctx, &cp)
 	return c, nil
 }
 
-func (s *MemoryStorage) ListComments(_ context.Context, postID string, first int, afterID *string) ([]*models.Comment, *string) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// checkParent makes sure a reply to parentID can go under postID.
+func (s *MemoryStorage) checkParent(postID, parentID string) error {
+	parent, ok := s.comments[parentID]
+	if !ok {
+		return ErrNotFound
+	}
+	if parent.PostID != postID {
+		return ErrWrongPost
+	}
+	return nil
+}
 
+func (s *MemoryStorage) ListComments(ctx context.Context, postID string, first int, afterID *string) ([]*models.Comment, *string, error) {
+	defer s.rlock(ctx)()
+
+	if _, ok := s.livePost(postID); !ok {
+		return nil, nil, nil
+	}
 	all := s.byPost[postID]
 	if len(all) == 0 {
-		return nil, nil
+		return nil, nil, nil
 	}
 
 	start := 0
@@ -139,5 +314,677 @@ func (s *MemoryStorage) ListComments(_ context.Context, postID string, first int
 	if end < len(all) {
 		next = &items[len(items)-1].ID
 	}
-	return items, next
+	return items, next, nil
+}
+
+func (s *MemoryStorage) ListPostComments(ctx context.Context, postID string) ([]*models.Comment, error) {
+	defer s.rlock(ctx)()
+
+	return append([]*models.Comment(nil), s.byPost[postID]...), nil
+}
+
+func (s *MemoryStorage) GetComment(ctx context.Context, id string) (*models.Comment, error) {
+	defer s.rlock(ctx)()
+
+	c, ok := s.comments[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return c, nil
+}
+
+func (s *MemoryStorage) CommentAncestry(ctx context.Context, id string, limit int) ([]*models.Comment, error) {
+	defer s.rlock(ctx)()
+
+	var res []*models.Comment
+	for next := &id; next != nil && len(res) < limit; {
+		c, ok := s.comments[*next]
+		if !ok {
+			break
+		}
+		res = append(res, c)
+		next = c.ParentID
+	}
+	if len(res) == 0 {
+		return nil, ErrNotFound
+	}
+	return res, nil
+}
+
+func (s *MemoryStorage) ExportPosts(ctx context.Context, fn func(*models.Post) error) error {
+	unlock := s.rlock(ctx)
+	posts := make([]*models.Post, 0, len(s.posts))
+	for _, p := range s.posts {
+		cp := *p
+		posts = append(posts, &cp)
+	}
+	unlock()
+
+	sort.Slice(posts, func(i, j int) bool {
+		a, b := posts[i], posts[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+	for _, p := range posts {
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportComments orders comments by depth in their reply tree, so every
+// parent precedes its replies whatever their timestamps.
+func (s *MemoryStorage) ExportComments(ctx context.Context, fn func(*models.Comment) error) error {
+	unlock := s.rlock(ctx)
+	comments := make([]*models.Comment, 0, len(s.comments))
+	depth := make(map[string]int, len(s.comments))
+	for _, c := range s.comments {
+		cp := *c
+		comments = append(comments, &cp)
+		d := 0
+		for parent := c.ParentID; parent != nil; {
+			d++
+			p, ok := s.comments[*parent]
+			if !ok {
+				break
+			}
+			parent = p.ParentID
+		}
+		depth[c.ID] = d
+	}
+	unlock()
+
+	sort.Slice(comments, func(i, j int) bool {
+		a, b := comments[i], comments[j]
+		if depth[a.ID] != depth[b.ID] {
+			return depth[a.ID] < depth[b.ID]
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+	for _, c := range comments {
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStorage) ImportPost(ctx context.Context, p *models.Post) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.posts[p.ID]; ok {
+		return ErrConflict
+	}
+	cp := *p
+	cp.CommentCount = 0
+	cp.LastCommentAt = nil
+	s.posts[cp.ID] = &cp
+	s.record(ctx, func() { delete(s.posts, cp.ID) })
+	return nil
+}
+
+func (s *MemoryStorage) ImportComment(ctx context.Context, c *models.Comment) error {
+	defer s.lock(ctx)()
+
+	p, ok := s.posts[c.PostID]
+	if !ok {
+		return ErrNotFound
+	}
+	if c.ParentID != nil {
+		parent, ok := s.comments[*c.ParentID]
+		if !ok || parent.PostID != c.PostID {
+			return ErrNotFound
+		}
+	}
+	if _, ok := s.comments[c.ID]; ok {
+		return ErrConflict
+	}
+
+	cp := *c
+	s.comments[cp.ID] = &cp
+	s.record(ctx, func() { delete(s.comments, cp.ID) })
+
+	// Keep byPost in creation order, which ListComments pages through.
+	all, existed := s.byPost[cp.PostID]
+	i := sort.Search(len(all), func(i int) bool { return all[i].CreatedAt.After(cp.CreatedAt) })
+	all = append(all, nil)
+	copy(all[i+1:], all[i:])
+	all[i] = &cp
+	s.byPost[cp.PostID] = all
+	s.record(ctx, func() {
+		all := s.byPost[cp.PostID]
+		copy(all[i:], all[i+1:])
+		all[len(all)-1] = nil
+		if all = all[:len(all)-1]; len(all) == 0 && !existed {
+			delete(s.byPost, cp.PostID)
+		} else {
+			s.byPost[cp.PostID] = all
+		}
+	})
+
+	post := *p
+	post.CommentCount++
+	if post.LastCommentAt == nil || cp.CreatedAt.After(*post.LastCommentAt) {
+		post.LastCommentAt = &cp.CreatedAt
+	}
+	s.setPost(ctx, &post)
+	return nil
+}
+
+func (s *MemoryStorage) AddOutboxEvent(ctx context.Context, e *OutboxEvent) error {
+	defer s.lock(ctx)()
+
+	n := len(s.outbox)
+	s.outbox = append(s.outbox, &outboxEntry{event: *e})
+	s.record(ctx, func() { s.outbox = truncate(s.outbox, n) })
+	return nil
+}
+
+func (s *MemoryStorage) PendingOutboxEvents(ctx context.Context, limit int) ([]*OutboxEvent, error) {
+	defer s.rlock(ctx)()
+
+	var events []*OutboxEvent
+	for _, entry := range s.outbox {
+		if len(events) == limit {
+			break
+		}
+		if entry.deliveredAt == nil {
+			e := entry.event
+			events = append(events, &e)
+		}
+	}
+	return events, nil
+}
+
+func (s *MemoryStorage) ClaimOutboxEvents(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*OutboxEvent, error) {
+	defer s.lock(ctx)()
+
+	leased := now.Add(lease)
+	var events []*OutboxEvent
+	for _, entry := range s.outbox {
+		if len(events) == limit {
+			break
+		}
+		if entry.deliveredAt != nil || (entry.claimedUntil != nil && entry.claimedUntil.After(now)) {
+			continue
+		}
+		prev := entry.claimedUntil
+		entry.claimedUntil = &leased
+		s.record(ctx, func() { entry.claimedUntil = prev })
+		e := entry.event
+		events = append(events, &e)
+	}
+	return events, nil
+}
+
+func (s *MemoryStorage) ReleaseOutboxEvents(ctx context.Context, ids []string) error {
+	defer s.lock(ctx)()
+
+	claimed := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		claimed[id] = struct{}{}
+	}
+	for _, entry := range s.outbox {
+		if _, ok := claimed[entry.event.ID]; ok && entry.deliveredAt == nil && entry.claimedUntil != nil {
+			prev := entry.claimedUntil
+			entry.claimedUntil = nil
+			s.record(ctx, func() { entry.claimedUntil = prev })
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStorage) MarkOutboxDelivered(ctx context.Context, ids []string) error {
+	defer s.lock(ctx)()
+
+	now := time.Now()
+	pending := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		pending[id] = struct{}{}
+	}
+	var marked []*outboxEntry
+	for _, entry := range s.outbox {
+		if _, ok := pending[entry.event.ID]; ok && entry.deliveredAt == nil {
+			entry.deliveredAt = &now
+			marked = append(marked, entry)
+		}
+	}
+	s.record(ctx, func() {
+		for _, entry := range marked {
+			entry.deliveredAt = nil
+		}
+	})
+	return nil
+}
+
+func (s *MemoryStorage) PruneOutbox(ctx context.Context, deliveredBefore time.Time) (int, error) {
+	defer s.lock(ctx)()
+
+	var kept []*outboxEntry
+	for _, entry := range s.outbox {
+		if entry.deliveredAt == nil || !entry.deliveredAt.Before(deliveredBefore) {
+			kept = append(kept, entry)
+		}
+	}
+	pruned := len(s.outbox) - len(kept)
+	prev := s.outbox
+	s.outbox = kept
+	s.record(ctx, func() { s.outbox = prev })
+	return pruned, nil
+}
+
+// Notifications, like webhook deliveries, are replaced rather than modified
+// in place, so callers holding an earlier copy never see it change.
+
+func (s *MemoryStorage) AddNotification(ctx context.Context, n *models.Notification) error {
+	defer s.lock(ctx)()
+
+	cp := *n
+	count := len(s.notifications)
+	s.notifications = append(s.notifications, &cp)
+	s.record(ctx, func() { s.notifications = truncate(s.notifications, count) })
+	return nil
+}
+
+func (s *MemoryStorage) ListNotifications(ctx context.Context, user string, opts ListNotificationsOptions) ([]*models.Notification, *string, error) {
+	defer s.rlock(ctx)()
+
+	var cur *postCursor
+	if opts.After != nil {
+		c, err := decodePostCursor(*opts.After)
+		if err != nil {
+			return nil, nil, err
+		}
+		cur = &c
+	}
+
+	var res []*models.Notification
+	for _, n := range s.notifications {
+		if n.User != user || (opts.UnreadOnly && n.ReadAt != nil) {
+			continue
+		}
+		if cur != nil && (cur.before(n.CreatedAt, n.ID, OrderCreatedAtDesc) || n.ID == cur.ID) {
+			continue
+		}
+		cp := *n
+		res = append(res, &cp)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.ID > b.ID
+	})
+
+	if len(res) <= opts.First {
+		return res, nil, nil
+	}
+	res = res[:opts.First]
+	last := res[len(res)-1]
+	next := encodePostCursor(last.CreatedAt, last.ID)
+	return res, &next, nil
+}
+
+func (s *MemoryStorage) MarkNotificationsRead(ctx context.Context, user string, ids []string) (int, error) {
+	defer s.lock(ctx)()
+
+	var wanted map[string]struct{}
+	if ids != nil {
+		wanted = make(map[string]struct{}, len(ids))
+		for _, id := range ids {
+			wanted[id] = struct{}{}
+		}
+	}
+
+	now := time.Now()
+	marked := 0
+	for i, n := range s.notifications {
+		if n.User != user || n.ReadAt != nil {
+			continue
+		}
+		if _, ok := wanted[n.ID]; wanted != nil && !ok {
+			continue
+		}
+		cp := *n
+		cp.ReadAt = &now
+		s.notifications[i] = &cp
+		s.record(ctx, func() { s.notifications[i] = n })
+		marked++
+	}
+	return marked, nil
+}
+
+func (s *MemoryStorage) AddAttachment(ctx context.Context, a *models.Attachment) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.comments[a.CommentID]; !ok {
+		return ErrNotFound
+	}
+	cp := *a
+	as, existed := s.attachments[a.CommentID]
+	s.attachments[a.CommentID] = append(as, &cp)
+	s.record(ctx, func() {
+		if !existed {
+			delete(s.attachments, cp.CommentID)
+		} else {
+			s.attachments[cp.CommentID] = truncate(s.attachments[cp.CommentID], len(as))
+		}
+	})
+	return nil
+}
+
+func (s *MemoryStorage) ListAttachments(ctx context.Context, commentID string) ([]*models.Attachment, error) {
+	defer s.rlock(ctx)()
+
+	res := make([]*models.Attachment, 0, len(s.attachments[commentID]))
+	for _, a := range s.attachments[commentID] {
+		cp := *a
+		res = append(res, &cp)
+	}
+	return res, nil
+}
+
+func (s *MemoryStorage) ListAttachmentsFor(ctx context.Context, commentIDs []string) (map[string][]*models.Attachment, error) {
+	defer s.rlock(ctx)()
+
+	res := make(map[string][]*models.Attachment)
+	for _, id := range commentIDs {
+		for _, a := range s.attachments[id] {
+			cp := *a
+			res[id] = append(res[id], &cp)
+		}
+	}
+	return res, nil
+}
+
+func (s *MemoryStorage) ListPostAttachments(ctx context.Context, postID string) ([]*models.Attachment, error) {
+	defer s.rlock(ctx)()
+
+	var res []*models.Attachment
+	for _, c := range s.byPost[postID] {
+		for _, a := range s.attachments[c.ID] {
+			cp := *a
+			res = append(res, &cp)
+		}
+	}
+	return res, nil
+}
+
+func (s *MemoryStorage) CreateWebhook(ctx context.Context, w *models.Webhook) error {
+	defer s.lock(ctx)()
+
+	cp := *w
+	cp.Events = append([]string(nil), w.Events...)
+	n := len(s.webhooks)
+	s.webhooks = append(s.webhooks, &cp)
+	s.record(ctx, func() { s.webhooks = truncate(s.webhooks, n) })
+	return nil
+}
+
+func (s *MemoryStorage) ListWebhooks(ctx context.Context) ([]*models.Webhook, error) {
+	defer s.rlock(ctx)()
+
+	res := make([]*models.Webhook, len(s.webhooks))
+	for i, w := range s.webhooks {
+		cp := *w
+		cp.Events = append([]string(nil), w.Events...)
+		res[i] = &cp
+	}
+	return res, nil
+}
+
+// Deliveries are never modified in place: updates store a fresh copy.
+// Callers only ever get copies.
+
+func (s *MemoryStorage) AddWebhookDelivery(ctx context.Context, d *models.WebhookDelivery) error {
+	defer s.lock(ctx)()
+
+	for _, existing := range s.deliveries {
+		if existing.WebhookID == d.WebhookID && existing.EventID == d.EventID {
+			return nil
+		}
+	}
+	cp := *d
+	n := len(s.deliveries)
+	s.deliveries = append(s.deliveries, &cp)
+	s.record(ctx, func() { s.deliveries = truncate(s.deliveries, n) })
+	return nil
+}
+
+func (s *MemoryStorage) ClaimWebhookDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*models.WebhookDelivery, error) {
+	defer s.lock(ctx)()
+
+	leased := now.Add(lease)
+	var res []*models.WebhookDelivery
+	for i, d := range s.deliveries {
+		if len(res) == limit {
+			break
+		}
+		if d.Status != models.DeliveryPending || (d.NextAttemptAt != nil && d.NextAttemptAt.After(now)) {
+			continue
+		}
+		cp := *d
+		cp.NextAttemptAt = &leased
+		s.deliveries[i] = &cp
+		s.record(ctx, func() { s.deliveries[i] = d })
+		out := cp
+		res = append(res, &out)
+	}
+	return res, nil
 }
+
+func (s *MemoryStorage) UpdateWebhookDelivery(ctx context.Context, d *models.WebhookDelivery) error {
+	defer s.lock(ctx)()
+
+	for i, existing := range s.deliveries {
+		if existing.ID == d.ID {
+			cp := *d
+			s.deliveries[i] = &cp
+			s.record(ctx, func() { s.deliveries[i] = existing })
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *MemoryStorage) ListWebhookDeliveries(ctx context.Context, filter WebhookDeliveryFilter, limit int) ([]*models.WebhookDelivery, error) {
+	defer s.rlock(ctx)()
+
+	var res []*models.WebhookDelivery
+	for i := len(s.deliveries) - 1; i >= 0 && len(res) < limit; i-- {
+		d := s.deliveries[i]
+		if filter.WebhookID != nil && d.WebhookID != *filter.WebhookID {
+			continue
+		}
+		if filter.Status != nil && d.Status != *filter.Status {
+			continue
+		}
+		cp := *d
+		res = append(res, &cp)
+	}
+	return res, nil
+}
+
+func (s *MemoryStorage) PruneWebhookDeliveries(ctx context.Context, finishedBefore time.Time) (int, error) {
+	defer s.lock(ctx)()
+
+	var kept []*models.WebhookDelivery
+	for _, d := range s.deliveries {
+		if !deliveryFinishedBefore(d, finishedBefore) {
+			kept = append(kept, d)
+		}
+	}
+	pruned := len(s.deliveries) - len(kept)
+	prev := s.deliveries
+	s.deliveries = kept
+	s.record(ctx, func() { s.deliveries = prev })
+	return pruned, nil
+}
+
+func deliveryFinishedBefore(d *models.WebhookDelivery, t time.Time) bool {
+	switch d.Status {
+	case models.DeliveryDelivered:
+		return d.DeliveredAt != nil && d.DeliveredAt.Before(t)
+	case models.DeliveryDead:
+		return d.CreatedAt.Before(t)
+	}
+	return false
+}
+
+func (s *MemoryStorage) GetPersistedQuery(ctx context.Context, hash string) (string, error) {
+	defer s.rlock(ctx)()
+
+	q, ok := s.queries[hash]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return q.query, nil
+}
+
+func (s *MemoryStorage) SavePersistedQuery(ctx context.Context, hash, query string) error {
+	defer s.lock(ctx)()
+
+	if _, ok := s.queries[hash]; ok {
+		return nil
+	}
+	s.queries[hash] = persistedQuery{query: query, savedAt: time.Now()}
+	s.record(ctx, func() { delete(s.queries, hash) })
+	return nil
+}
+
+func (s *MemoryStorage) PrunePersistedQueries(ctx context.Context, savedBefore time.Time) (int, error) {
+	defer s.lock(ctx)()
+
+	pruned := make(map[string]persistedQuery)
+	for hash, q := range s.queries {
+		if q.savedAt.Before(savedBefore) {
+			pruned[hash] = q
+			delete(s.queries, hash)
+		}
+	}
+	s.record(ctx, func() { maps.Copy(s.queries, pruned) })
+	return len(pruned), nil
+}
+
+type memoryTxKey struct{}
+
+// memoryTx is the undo log of a transaction: one function per change,
+// reverting it given the state right after the change.
+type memoryTx struct {
+	owner *MemoryStorage
+	undo  []func()
+}
+
+// WithTx runs fn holding the storage's write lock, so other callers never
+// see its intermediate state, and undoes fn's changes if it fails or
+// panics. Calls made with the ctx passed to fn skip locking; calls made with
+// any other ctx block until fn returns. A nested call works like a
+// savepoint: when it fails, only its own changes are undone.
+func (s *MemoryStorage) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	tx := s.tx(ctx)
+	if tx == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		tx = &memoryTx{owner: s}
+		ctx = context.WithValue(ctx, memoryTxKey{}, tx)
+	}
+
+	mark := len(tx.undo)
+	defer func() {
+		if p := recover(); p != nil {
+			tx.rollback(mark)
+			panic(p)
+		}
+	}()
+	if err := fn(ctx); err != nil {
+		tx.rollback(mark)
+		return err
+	}
+	return nil
+}
+
+// rollback undoes the changes recorded after mark, newest first.
+func (tx *memoryTx) rollback(mark int) {
+	for i := len(tx.undo) - 1; i >= mark; i-- {
+		tx.undo[i]()
+		tx.undo[i] = nil
+	}
+	tx.undo = tx.undo[:mark]
+}
+
+func (s *MemoryStorage) tx(ctx context.Context) *memoryTx {
+	tx, _ := ctx.Value(memoryTxKey{}).(*memoryTx)
+	if tx == nil || tx.owner != s {
+		return nil
+	}
+	return tx
+}
+
+// record registers how to revert a change just made with ctx. Outside of a
+// transaction there is nothing to revert to.
+func (s *MemoryStorage) record(ctx context.Context, undo func()) {
+	if tx := s.tx(ctx); tx != nil {
+		tx.undo = append(tx.undo, undo)
+	}
+}
+
+// setPost replaces the stored version of a post. Posts are never modified
+// in place: the previous version may still be read, without the lock, by
+// whoever it was handed to.
+func (s *MemoryStorage) setPost(ctx context.Context, p *models.Post) {
+	prev := s.posts[p.ID]
+	s.posts[p.ID] = p
+	s.record(ctx, func() { s.posts[p.ID] = prev })
+}
+
+// appendComment adds c to the end of its post's comment list.
+func (s *MemoryStorage) appendComment(ctx context.Context, postID string, c *models.Comment) {
+	all, existed := s.byPost[postID]
+	s.byPost[postID] = append(all, c)
+	s.record(ctx, func() {
+		delete(s.comments, c.ID)
+		if !existed {
+			delete(s.byPost, postID)
+		} else {
+			s.byPost[postID] = truncate(s.byPost[postID], len(all))
+		}
+	})
+}
+
+// truncate shortens list to n elements, clearing the rest for the garbage
+// collector.
+func truncate[T any](list []*T, n int) []*T {
+	clear(list[n:])
+	return list[:n]
+}
+
+// lock and rlock take the storage lock unless ctx belongs to a transaction,
+// which already holds it. They return the matching unlock.
+func (s *MemoryStorage) lock(ctx context.Context) func() {
+	if s.tx(ctx) != nil {
+		return func() {}
+	}
+	s.mu.Lock()
+	return s.mu.Unlock
+}
+
+func (s *MemoryStorage) rlock(ctx context.Context) func() {
+	if s.tx(ctx) != nil {
+		return func() {}
+	}
+	s.mu.RLock()
+	return s.mu.RUnlock
+}
+
+func (s *MemoryStorage) Health(_ context.Context) error {
+	return nil
+}
+
+func (s *MemoryStorage) Close() {}
@@ -2,9 +2,14 @@ package storage_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"ozon-comments-graphql/internal/models"
 	"ozon-comments-graphql/internal/storage"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -17,7 +22,9 @@ func TestMemoryStorage_Posts(t *testing.T) {
 	assert.NotEmpty(t, post.ID)
 	assert.Equal(t, "Test Post", post.Title)
 
-	posts := s.ListPosts(ctx)
+	posts, next, err := s.ListPosts(ctx, storage.ListPostsOptions{})
+	assert.NoError(t, err)
+	assert.Nil(t, next)
 	assert.Len(t, posts, 1)
 	assert.Equal(t, post.ID, posts[0].ID)
 
@@ -32,20 +39,20 @@ func TestMemoryStorage_Comments(t *testing.T) {
 
 	post := s.CreatePost(ctx, "Test Post", "Test Content")
 
-	comment, err := s.CreateComment(ctx, post.ID, nil, "Test Comment")
+	comment, err := s.CreateComment(ctx, post.ID, nil, nil, "Test Comment")
 	assert.NoError(t, err)
 	assert.NotEmpty(t, comment.ID)
 	assert.Equal(t, "Test Comment", comment.Content)
 
-	comments, next := s.ListComments(ctx, post.ID, 10, nil)
+	comments, next, _ := s.ListComments(ctx, post.ID, 10, nil)
 	assert.Len(t, comments, 1)
 	assert.Equal(t, comment.ID, comments[0].ID)
 	assert.Nil(t, next)
 
-	childComment, err := s.CreateComment(ctx, post.ID, &comment.ID, "Child Comment")
+	childComment, err := s.CreateComment(ctx, post.ID, &comment.ID, nil, "Child Comment")
 	assert.NoError(t, err)
 
-	comments, _ = s.ListComments(ctx, post.ID, 10, nil)
+	comments, _, _ = s.ListComments(ctx, post.ID, 10, nil)
 	assert.Len(t, comments, 2)
 	assert.Equal(t, comment.ID, *childComment.ParentID)
 }
@@ -57,16 +64,37 @@ func TestMemoryStorage_CommentErrors(t *testing.T) {
 	post := s.CreatePost(ctx, "Test Post", "Test Content")
 
 	longText := string(make([]byte, 2001))
-	_, err := s.CreateComment(ctx, post.ID, nil, longText)
+	_, err := s.CreateComment(ctx, post.ID, nil, nil, longText)
 	assert.ErrorIs(t, err, storage.ErrTooLong)
 
-	_, err = s.ToggleComments(ctx, post.ID, true)
+	_, _, err = s.ToggleComments(ctx, post.ID, true)
 	assert.NoError(t, err)
 
-	_, err = s.CreateComment(ctx, post.ID, nil, "Should fail")
+	_, err = s.CreateComment(ctx, post.ID, nil, nil, "Should fail")
 	assert.ErrorIs(t, err, storage.ErrForbidden)
 }
 
+func TestMemoryStorage_CommentParent(t *testing.T) {
+	s := storage.NewMemoryStorage()
+	ctx := context.Background()
+
+	post := s.CreatePost(ctx, "Test Post", "Test Content")
+	other := s.CreatePost(ctx, "Other Post", "Test Content")
+	foreign, err := s.CreateComment(ctx, other.ID, nil, nil, "Elsewhere")
+	assert.NoError(t, err)
+
+	missing := "missing"
+	_, err = s.CreateComment(ctx, post.ID, &missing, nil, "Orphan")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	_, err = s.CreateComment(ctx, post.ID, &foreign.ID, nil, "Cross-post")
+	assert.ErrorIs(t, err, storage.ErrWrongPost)
+
+	got, err := s.GetPost(ctx, post.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, got.CommentCount)
+}
+
 func TestMemoryStorage_Pagination(t *testing.T) {
 	s := storage.NewMemoryStorage()
 	ctx := context.Background()
@@ -75,22 +103,481 @@ func TestMemoryStorage_Pagination(t *testing.T) {
 
 	var comments []*models.Comment
 	for i := 0; i < 15; i++ {
-		c, err := s.CreateComment(ctx, post.ID, nil, "Comment")
+		c, err := s.CreateComment(ctx, post.ID, nil, nil, "Comment")
 		assert.NoError(t, err)
 		comments = append(comments, c)
 	}
 
-	page1, next1 := s.ListComments(ctx, post.ID, 5, nil)
+	page1, next1, _ := s.ListComments(ctx, post.ID, 5, nil)
 	assert.Len(t, page1, 5)
 	assert.NotNil(t, next1)
 	assert.Equal(t, comments[4].ID, *next1)
 
-	page2, next2 := s.ListComments(ctx, post.ID, 5, next1)
+	page2, next2, _ := s.ListComments(ctx, post.ID, 5, next1)
 	assert.Len(t, page2, 5)
 	assert.NotNil(t, next2)
 	assert.Equal(t, comments[9].ID, *next2)
 
-	page3, next3 := s.ListComments(ctx, post.ID, 5, next2)
+	page3, next3, _ := s.ListComments(ctx, post.ID, 5, next2)
 	assert.Len(t, page3, 5)
 	assert.Nil(t, next3)
 }
+
+func TestMemoryStorage_CommentCounters(t *testing.T) {
+	s := storage.NewMemoryStorage()
+	ctx := context.Background()
+
+	post := s.CreatePost(ctx, "Test Post", "Test Content")
+	assert.Equal(t, 0, post.CommentCount)
+	assert.Nil(t, post.LastCommentAt)
+
+	_, err := s.CreateComment(ctx, post.ID, nil, nil, "First")
+	assert.NoError(t, err)
+	last, err := s.CreateComment(ctx, post.ID, nil, nil, "Second")
+	assert.NoError(t, err)
+
+	got, err := s.GetPost(ctx, post.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, got.CommentCount)
+	assert.NotNil(t, got.LastCommentAt)
+	assert.Equal(t, last.CreatedAt, *got.LastCommentAt)
+	assert.Equal(t, 0, post.CommentCount, "a post handed out earlier does not change")
+}
+
+// Run with -race: posts handed out are read without the storage lock while
+// comments keep updating the counters.
+func TestMemoryStorage_ConcurrentCommentsAndReads(t *testing.T) {
+	s := storage.NewMemoryStorage()
+	ctx := context.Background()
+	post := s.CreatePost(ctx, "Test Post", "Test Content")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				_, err := s.CreateComment(ctx, post.ID, nil, nil, "Comment")
+				assert.NoError(t, err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				got, err := s.GetPost(ctx, post.ID)
+				assert.NoError(t, err)
+				for k := 0; k < 10; k++ {
+					assert.GreaterOrEqual(t, got.CommentCount, 0)
+					_ = post.LastCommentAt
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := s.GetPost(ctx, post.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, got.CommentCount)
+}
+
+func TestMemoryStorage_PostsPagination(t *testing.T) {
+	s := storage.NewMemoryStorage()
+	ctx := context.Background()
+
+	var posts []*models.Post
+	for i := 0; i < 5; i++ {
+		posts = append(posts, s.CreatePost(ctx, "Post", "Content"))
+	}
+
+	var seen []string
+	var after *string
+	for {
+		page, next, err := s.ListPosts(ctx, storage.ListPostsOptions{First: 2, After: after})
+		assert.NoError(t, err)
+		for _, p := range page {
+			seen = append(seen, p.ID)
+		}
+		if next == nil {
+			break
+		}
+		after = next
+	}
+	assert.Len(t, seen, 5)
+	assert.Equal(t, posts[4].ID, seen[0])
+	assert.Equal(t, posts[0].ID, seen[4])
+
+	asc, _, err := s.ListPosts(ctx, storage.ListPostsOptions{First: 1, OrderBy: storage.OrderCreatedAtAsc})
+	assert.NoError(t, err)
+	assert.Equal(t, posts[0].ID, asc[0].ID)
+
+	bad := "not-a-cursor"
+	_, _, err = s.ListPosts(ctx, storage.ListPostsOptions{First: 1, After: &bad})
+	assert.ErrorIs(t, err, storage.ErrInvalidCursor)
+}
+
+func TestMemoryStorage_PostsFilter(t *testing.T) {
+	s := storage.NewMemoryStorage()
+	ctx := context.Background()
+
+	golang := s.CreatePost(ctx, "Learning Go", "Content")
+	rust := s.CreatePost(ctx, "Learning Rust", "Content")
+	_, _, err := s.ToggleComments(ctx, rust.ID, true)
+	assert.NoError(t, err)
+
+	posts, _, err := s.ListPosts(ctx, storage.ListPostsOptions{Filter: storage.PostFilter{TitleContains: "go"}})
+	assert.NoError(t, err)
+	assert.Len(t, posts, 1)
+	assert.Equal(t, golang.ID, posts[0].ID)
+
+	disabled := true
+	posts, _, err = s.ListPosts(ctx, storage.ListPostsOptions{Filter: storage.PostFilter{CommentsDisabled: &disabled}})
+	assert.NoError(t, err)
+	assert.Len(t, posts, 1)
+	assert.Equal(t, rust.ID, posts[0].ID)
+
+	posts, _, err = s.ListPosts(ctx, storage.ListPostsOptions{Filter: storage.PostFilter{CreatedAfter: &golang.CreatedAt}})
+	assert.NoError(t, err)
+	assert.Len(t, posts, 1)
+	assert.Equal(t, rust.ID, posts[0].ID)
+}
+
+func TestMemoryStorage_UpdateAndDeletePost(t *testing.T) {
+	s := storage.NewMemoryStorage()
+	ctx := context.Background()
+
+	post := s.CreatePost(ctx, "Title", "Content")
+
+	title := "New title"
+	updated, err := s.UpdatePost(ctx, post.ID, &title, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "New title", updated.Title)
+	assert.Equal(t, "Content", updated.Content)
+
+	_, err = s.CreateComment(ctx, post.ID, nil, nil, "Comment")
+	assert.NoError(t, err)
+
+	_, err = s.DeletePost(ctx, post.ID, false)
+	assert.NoError(t, err)
+
+	_, err = s.GetPost(ctx, post.ID)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	comments, _, _ := s.ListComments(ctx, post.ID, 10, nil)
+	assert.Empty(t, comments)
+
+	_, err = s.DeletePost(ctx, post.ID, false)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestMemoryStorage_SoftDeletePost(t *testing.T) {
+	s := storage.NewMemoryStorage()
+	ctx := context.Background()
+
+	post := s.CreatePost(ctx, "Title", "Content")
+	_, err := s.CreateComment(ctx, post.ID, nil, nil, "Comment")
+	assert.NoError(t, err)
+
+	deleted, err := s.DeletePost(ctx, post.ID, true)
+	assert.NoError(t, err)
+	assert.NotNil(t, deleted.DeletedAt)
+
+	_, err = s.GetPost(ctx, post.ID)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	posts, _, err := s.ListPosts(ctx, storage.ListPostsOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, posts)
+
+	_, err = s.CreateComment(ctx, post.ID, nil, nil, "Too late")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	comments, next, err := s.ListComments(ctx, post.ID, 10, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, comments, "comments of a deleted post are hidden like the post")
+	assert.Nil(t, next)
+
+	all, err := s.ListPostComments(ctx, post.ID)
+	assert.NoError(t, err)
+	assert.Len(t, all, 1, "a hard delete still has the comments to remove")
+}
+
+func TestMemoryStorage_WithTx(t *testing.T) {
+	s := storage.NewMemoryStorage()
+	ctx := context.Background()
+	post := s.CreatePost(ctx, "Post", "Content")
+
+	err := s.WithTx(ctx, func(ctx context.Context) error {
+		_, err := s.CreateComment(ctx, post.ID, nil, nil, "kept")
+		return err
+	})
+	assert.NoError(t, err)
+
+	failure := errors.New("boom")
+	err = s.WithTx(ctx, func(ctx context.Context) error {
+		if _, err := s.CreateComment(ctx, post.ID, nil, nil, "rolled back"); err != nil {
+			return err
+		}
+		if _, _, err := s.ToggleComments(ctx, post.ID, true); err != nil {
+			return err
+		}
+		got, err := s.GetPost(ctx, post.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, got.CommentCount, "a transaction sees its own writes")
+		return failure
+	})
+	assert.ErrorIs(t, err, failure)
+
+	got, err := s.GetPost(ctx, post.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, got.CommentCount)
+	assert.False(t, got.CommentsDisabled)
+	comments, _, _ := s.ListComments(ctx, post.ID, 10, nil)
+	assert.Len(t, comments, 1)
+}
+
+func TestMemoryStorage_WithTxNested(t *testing.T) {
+	s := storage.NewMemoryStorage()
+	ctx := context.Background()
+	post := s.CreatePost(ctx, "Post", "Content")
+	failure := errors.New("boom")
+
+	err := s.WithTx(ctx, func(ctx context.Context) error {
+		if _, err := s.CreateComment(ctx, post.ID, nil, nil, "outer"); err != nil {
+			return err
+		}
+		err := s.WithTx(ctx, func(ctx context.Context) error {
+			if _, err := s.CreateComment(ctx, post.ID, nil, nil, "inner"); err != nil {
+				return err
+			}
+			return failure
+		})
+		assert.ErrorIs(t, err, failure)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	comments, _, _ := s.ListComments(ctx, post.ID, 10, nil)
+	if assert.Len(t, comments, 1, "a failed nested call undoes only its own changes") {
+		assert.Equal(t, "outer", comments[0].Content)
+	}
+	got, _ := s.GetPost(ctx, post.ID)
+	assert.Equal(t, 1, got.CommentCount)
+}
+
+func TestMemoryStorage_WithTxRollsBackEverything(t *testing.T) {
+	s := storage.NewMemoryStorage()
+	ctx := context.Background()
+	post := s.CreatePost(ctx, "Post", "Content")
+	c, _ := s.CreateComment(ctx, post.ID, nil, nil, "kept")
+	assert.NoError(t, s.AddAttachment(ctx, &models.Attachment{ID: "a1", CommentID: c.ID}))
+	assert.NoError(t, s.AddNotification(ctx, &models.Notification{ID: "n1", User: "alice", CreatedAt: time.Now()}))
+	assert.NoError(t, s.AddOutboxEvent(ctx, &storage.OutboxEvent{ID: "e1", CreatedAt: time.Now()}))
+
+	assert.Panics(t, func() {
+		_ = s.WithTx(ctx, func(ctx context.Context) error {
+			_, _ = s.MarkNotificationsRead(ctx, "alice", nil)
+			_ = s.MarkOutboxDelivered(ctx, []string{"e1"})
+			_, _ = s.PruneOutbox(ctx, time.Now().Add(time.Hour))
+			_ = s.AddOutboxEvent(ctx, &storage.OutboxEvent{ID: "e2", CreatedAt: time.Now()})
+			_ = s.SavePersistedQuery(ctx, "h", "{ posts { items { id } } }")
+			s.CreatePost(ctx, "Other", "Content")
+			_, _ = s.DeletePost(ctx, post.ID, false)
+			panic("boom")
+		})
+	})
+
+	got, err := s.GetPost(ctx, post.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, got.CommentCount)
+	comments, _, _ := s.ListComments(ctx, post.ID, 10, nil)
+	assert.Len(t, comments, 1)
+	attachments, _ := s.ListAttachments(ctx, c.ID)
+	assert.Len(t, attachments, 1)
+	unread, _, _ := s.ListNotifications(ctx, "alice", storage.ListNotificationsOptions{First: 10, UnreadOnly: true})
+	assert.Len(t, unread, 1)
+	pending, _ := s.PendingOutboxEvents(ctx, 10)
+	if assert.Len(t, pending, 1) {
+		assert.Equal(t, "e1", pending[0].ID)
+	}
+	_, err = s.GetPersistedQuery(ctx, "h")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	posts, _, _ := s.ListPosts(ctx, storage.ListPostsOptions{First: 10})
+	assert.Len(t, posts, 1)
+
+	// The lock is released after the panic.
+	s.CreatePost(ctx, "After", "Content")
+}
+
+func TestMemoryStorage_Notifications(t *testing.T) {
+	s := storage.NewMemoryStorage()
+	ctx := context.Background()
+	start := time.Now()
+
+	for i := 0; i < 5; i++ {
+		err := s.AddNotification(ctx, &models.Notification{
+			ID:        fmt.Sprintf("n%d", i),
+			User:      "alice",
+			Type:      models.NotificationMention,
+			CreatedAt: start.Add(time.Duration(i) * time.Second),
+		})
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, s.AddNotification(ctx, &models.Notification{ID: "other", User: "bob", CreatedAt: start}))
+
+	page, next, err := s.ListNotifications(ctx, "alice", storage.ListNotificationsOptions{First: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"n4", "n3", "n2"}, notificationIDs(page))
+	if assert.NotNil(t, next) {
+		page, next, err = s.ListNotifications(ctx, "alice", storage.ListNotificationsOptions{First: 3, After: next})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"n1", "n0"}, notificationIDs(page))
+		assert.Nil(t, next)
+	}
+
+	n, err := s.MarkNotificationsRead(ctx, "alice", []string{"n4", "n3", "other"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	page, _, err = s.ListNotifications(ctx, "alice", storage.ListNotificationsOptions{First: 10, UnreadOnly: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"n2", "n1", "n0"}, notificationIDs(page))
+
+	n, err = s.MarkNotificationsRead(ctx, "alice", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	page, _, err = s.ListNotifications(ctx, "bob", storage.ListNotificationsOptions{First: 10, UnreadOnly: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"other"}, notificationIDs(page))
+}
+
+func notificationIDs(ns []*models.Notification) []string {
+	ids := make([]string, len(ns))
+	for i, n := range ns {
+		ids[i] = n.ID
+	}
+	return ids
+}
+
+func TestMemoryStorage_Attachments(t *testing.T) {
+	s := storage.NewMemoryStorage()
+	ctx := context.Background()
+
+	post := s.CreatePost(ctx, "Post", "Content")
+	c, err := s.CreateComment(ctx, post.ID, nil, nil, "With files")
+	assert.NoError(t, err)
+
+	for _, name := range []string{"b.png", "a.txt"} {
+		err := s.AddAttachment(ctx, &models.Attachment{ID: name, CommentID: c.ID, Filename: name, CreatedAt: time.Now()})
+		assert.NoError(t, err)
+	}
+	err = s.AddAttachment(ctx, &models.Attachment{ID: "x", CommentID: "missing"})
+	assert.True(t, errors.Is(err, storage.ErrNotFound))
+
+	list, err := s.ListAttachments(ctx, c.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, list, 2) {
+		assert.Equal(t, "b.png", list[0].Filename, "kept in upload order")
+		list[0].Filename = "changed"
+	}
+	list, _ = s.ListAttachments(ctx, c.ID)
+	assert.Equal(t, "b.png", list[0].Filename, "callers get copies")
+
+	_, err = s.DeletePost(ctx, post.ID, false)
+	assert.NoError(t, err)
+	list, err = s.ListAttachments(ctx, c.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, list)
+}
+
+func TestMemoryStorage_PrunePersistedQueries(t *testing.T) {
+	s := storage.NewMemoryStorage()
+	ctx := context.Background()
+
+	assert.NoError(t, s.SavePersistedQuery(ctx, "old", "{ posts { items { id } } }"))
+	cutoff := time.Now()
+	time.Sleep(time.Millisecond)
+	assert.NoError(t, s.SavePersistedQuery(ctx, "new", "{ posts { items { title } } }"))
+
+	n, err := s.PrunePersistedQueries(ctx, cutoff.Add(time.Nanosecond))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	_, err = s.GetPersistedQuery(ctx, "old")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	q, err := s.GetPersistedQuery(ctx, "new")
+	assert.NoError(t, err)
+	assert.Equal(t, "{ posts { items { title } } }", q)
+}
+
+func TestMemoryStorage_PruneWebhookDeliveries(t *testing.T) {
+	s := storage.NewMemoryStorage()
+	ctx := context.Background()
+
+	old := time.Now().Add(-2 * time.Hour)
+	for _, d := range []*models.WebhookDelivery{
+		{ID: "delivered", EventID: "e1", Status: models.DeliveryDelivered, CreatedAt: old, DeliveredAt: &old},
+		{ID: "dead", EventID: "e2", Status: models.DeliveryDead, CreatedAt: old},
+		{ID: "pending", EventID: "e3", Status: models.DeliveryPending, CreatedAt: old},
+		{ID: "recent", EventID: "e4", Status: models.DeliveryDead, CreatedAt: time.Now()},
+	} {
+		assert.NoError(t, s.AddWebhookDelivery(ctx, d))
+	}
+
+	n, err := s.PruneWebhookDeliveries(ctx, time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	left, err := s.ListWebhookDeliveries(ctx, storage.WebhookDeliveryFilter{}, 10)
+	assert.NoError(t, err)
+	var ids []string
+	for _, d := range left {
+		ids = append(ids, d.ID)
+	}
+	assert.Equal(t, []string{"recent", "pending"}, ids)
+}
+
+func TestMemoryStorage_ToggleCommentsReportsChangeOnce(t *testing.T) {
+	s := storage.NewMemoryStorage()
+	ctx := context.Background()
+	post := s.CreatePost(ctx, "Post", "Content")
+
+	var changes atomic.Int32
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, changed, err := s.ToggleComments(ctx, post.ID, true)
+			assert.NoError(t, err)
+			if changed {
+				changes.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), changes.Load())
+
+	_, changed, err := s.ToggleComments(ctx, post.ID, false)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+}
+
+func TestMemoryStorage_CommentAncestry(t *testing.T) {
+	s := storage.NewMemoryStorage()
+	ctx := context.Background()
+	post := s.CreatePost(ctx, "Post", "Content")
+
+	root, _ := s.CreateComment(ctx, post.ID, nil, nil, "root")
+	child, _ := s.CreateComment(ctx, post.ID, &root.ID, nil, "child")
+	leaf, _ := s.CreateComment(ctx, post.ID, &child.ID, nil, "leaf")
+
+	chain, err := s.CommentAncestry(ctx, leaf.ID, 10)
+	assert.NoError(t, err)
+	var ids []string
+	for _, c := range chain {
+		ids = append(ids, c.ID)
+	}
+	assert.Equal(t, []string{leaf.ID, child.ID, root.ID}, ids)
+
+	chain, err = s.CommentAncestry(ctx, leaf.ID, 2)
+	assert.NoError(t, err)
+	assert.Len(t, chain, 2)
+
+	_, err = s.CommentAncestry(ctx, "missing", 10)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
@@ -0,0 +1,46 @@
+package storage
+
+import "github.com/jackc/pgx/v5"
+
+const defaultMaxCommentLen = 2000
+
+type options struct {
+	maxCommentLen int
+	poolMinConns  int32
+	poolMaxConns  int32
+	queryTracer   pgx.QueryTracer
+}
+
+// Option tunes a storage backend. Options that do not apply to a backend,
+// such as pool sizes for MemoryStorage, are ignored by it.
+type Option func(*options)
+
+func newOptions(opts []Option) options {
+	o := options{maxCommentLen: defaultMaxCommentLen}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithMaxCommentLength limits comment content to n bytes.
+func WithMaxCommentLength(n int) Option {
+	return func(o *options) {
+		o.maxCommentLen = n
+	}
+}
+
+// WithPoolSize bounds the PostgreSQL connection pool.
+func WithPoolSize(minConns, maxConns int32) Option {
+	return func(o *options) {
+		o.poolMinConns = minConns
+		o.poolMaxConns = maxConns
+	}
+}
+
+// WithQueryTracer installs a pgx tracer on every pooled connection.
+func WithQueryTracer(t pgx.QueryTracer) Option {
+	return func(o *options) {
+		o.queryTracer = t
+	}
+}
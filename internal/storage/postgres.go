@@ -4,20 +4,47 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"ozon-comments-graphql/internal/models"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// SQLSTATEs of a duplicate primary key and of a missing referenced row.
+const (
+	uniqueViolation     = "23505"
+	foreignKeyViolation = "23503"
+)
+
+const postColumns = "id, title, content, comments_disabled, created_at, comment_count, last_comment_at, deleted_at"
+
 type PostgresStorage struct {
-	db *pgxpool.Pool
+	db            *pgxpool.Pool
+	maxCommentLen int
 }
 
-func NewPostgresStorage(ctx context.Context, connString string) (*PostgresStorage, error) {
-	pool, err := pgxpool.New(ctx, connString)
+func NewPostgresStorage(ctx context.Context, connString string, opts ...Option) (*PostgresStorage, error) {
+	cfg, err := pgxpool.ParseConfig(connString)
+	if err != nil {
+		return nil, fmt.Errorf("invalid connection string: %w", err)
+	}
+
+	o := newOptions(opts)
+	if o.poolMaxConns > 0 {
+		cfg.MaxConns = o.poolMaxConns
+	}
+	if o.poolMinConns > 0 {
+		cfg.MinConns = o.poolMinConns
+	}
+	if o.queryTracer != nil {
+		cfg.ConnConfig.Tracer = o.queryTracer
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create connection pool: %w", err)
 	}
@@ -30,7 +57,7 @@ func NewPostgresStorage(ctx context.Context, connString string) (*PostgresStorag
 		return nil, fmt.Errorf("failed to create tables: %w", err)
 	}
 
-	return &PostgresStorage{db: pool}, nil
+	return &PostgresStorage{db: pool, maxCommentLen: o.maxCommentLen}, nil
 }
 
 func createTables(ctx context.Context, db *pgxpool.Pool) error {
@@ -58,6 +85,93 @@ func createTables(ctx context.Context, db *pgxpool.Pool) error {
 		
 		CREATE INDEX IF NOT EXISTS comments_post_id_idx ON comments (post_id);
 		CREATE INDEX IF NOT EXISTS comments_created_at_idx ON comments (created_at);
+		CREATE INDEX IF NOT EXISTS posts_created_at_id_idx ON posts (created_at, id);
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(ctx, `
+		ALTER TABLE posts ADD COLUMN IF NOT EXISTS comment_count INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE posts ADD COLUMN IF NOT EXISTS last_comment_at TIMESTAMP WITH TIME ZONE;
+		ALTER TABLE posts ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+		ALTER TABLE comments ADD COLUMN IF NOT EXISTS author TEXT;
+
+		CREATE TABLE IF NOT EXISTS outbox (
+			id UUID PRIMARY KEY,
+			seq BIGSERIAL NOT NULL,
+			topic TEXT NOT NULL,
+			payload BYTEA NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			delivered_at TIMESTAMP WITH TIME ZONE
+		);
+		ALTER TABLE outbox ADD COLUMN IF NOT EXISTS claimed_until TIMESTAMP WITH TIME ZONE;
+		CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox (seq) WHERE delivered_at IS NULL;
+
+		CREATE TABLE IF NOT EXISTS notifications (
+			id UUID PRIMARY KEY,
+			user_name TEXT NOT NULL,
+			type TEXT NOT NULL,
+			post_id UUID NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+			comment_id UUID NOT NULL REFERENCES comments(id) ON DELETE CASCADE,
+			actor TEXT,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			read_at TIMESTAMP WITH TIME ZONE
+		);
+		CREATE INDEX IF NOT EXISTS notifications_user_idx ON notifications (user_name, created_at DESC, id DESC);
+
+		CREATE TABLE IF NOT EXISTS attachments (
+			id UUID PRIMARY KEY,
+			comment_id UUID NOT NULL REFERENCES comments(id) ON DELETE CASCADE,
+			filename TEXT NOT NULL,
+			content_type TEXT NOT NULL,
+			size BIGINT NOT NULL,
+			blob_key TEXT NOT NULL,
+			thumbnail_key TEXT,
+			width INTEGER,
+			height INTEGER,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			seq BIGSERIAL NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS attachments_comment_idx ON attachments (comment_id, seq);
+
+		CREATE TABLE IF NOT EXISTS webhooks (
+			id UUID PRIMARY KEY,
+			url TEXT NOT NULL,
+			events TEXT[] NOT NULL,
+			secret TEXT NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id UUID PRIMARY KEY,
+			seq BIGSERIAL NOT NULL,
+			webhook_id UUID NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+			event_id UUID NOT NULL,
+			event TEXT NOT NULL,
+			payload BYTEA NOT NULL,
+			status TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			response_status INTEGER,
+			last_error TEXT,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			next_attempt_at TIMESTAMP WITH TIME ZONE,
+			delivered_at TIMESTAMP WITH TIME ZONE,
+			UNIQUE (webhook_id, event_id)
+		);
+		CREATE INDEX IF NOT EXISTS webhook_deliveries_pending_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'PENDING';
+		CREATE INDEX IF NOT EXISTS webhook_deliveries_seq_idx ON webhook_deliveries (webhook_id, seq);
+
+		CREATE TABLE IF NOT EXISTS persisted_queries (
+			hash TEXT PRIMARY KEY,
+			query TEXT NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+		);
+		CREATE INDEX IF NOT EXISTS persisted_queries_created_idx ON persisted_queries (created_at);
+
+		UPDATE posts p SET comment_count = c.cnt, last_comment_at = c.last_at
+		FROM (SELECT post_id, COUNT(*) AS cnt, MAX(created_at) AS last_at FROM comments GROUP BY post_id) c
+		WHERE p.id = c.post_id AND p.comment_count = 0;
 	`)
 	return err
 }
@@ -66,12 +180,12 @@ func (s *PostgresStorage) CreatePost(ctx context.Context, title, content string)
 	id := uuid.NewString()
 	now := time.Now()
 
-	_, err := s.db.Exec(ctx,
+	_, err := s.q(ctx).Exec(ctx,
 		`INSERT INTO posts (id, title, content, comments_disabled, created_at) VALUES ($1, $2, $3, $4, $5)`,
 		id, title, content, false, now,
 	)
 	if err != nil {
-		fmt.Printf("CreatePost error: %v\n", err)
+		slog.ErrorContext(ctx, "create post failed", "post_id", id, "err", err)
 	}
 
 	return &models.Post{
@@ -83,38 +197,138 @@ func (s *PostgresStorage) CreatePost(ctx context.Context, title, content string)
 	}
 }
 
-func (s *PostgresStorage) ToggleComments(ctx context.Context, id string, disabled bool) (*models.Post, error) {
-	_, err := s.db.Exec(ctx, "UPDATE posts SET comments_disabled = $1 WHERE id = $2", disabled, id)
-	if err != nil {
-		return nil, err
+func (s *PostgresStorage) UpdatePost(ctx context.Context, id string, title, content *string) (*models.Post, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrNotFound
+	}
+
+	row := s.q(ctx).QueryRow(ctx,
+		`UPDATE posts SET title = COALESCE($1, title), content = COALESCE($2, content)
+		 WHERE id = $3 AND deleted_at IS NULL
+		 RETURNING `+postColumns,
+		title, content, id,
+	)
+	return scanPost(row)
+}
+
+// DeletePost removes a post; comments are dropped by ON DELETE CASCADE. A soft
+// delete only sets deleted_at, keeping the comments in place.
+func (s *PostgresStorage) DeletePost(ctx context.Context, id string, soft bool) (*models.Post, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrNotFound
 	}
 
-	return s.GetPost(ctx, id)
+	query := `DELETE FROM posts WHERE id = $1 RETURNING ` + postColumns
+	if soft {
+		query = `UPDATE posts SET deleted_at = now() WHERE id = $1 AND deleted_at IS NULL RETURNING ` + postColumns
+	}
+	return scanPost(s.q(ctx).QueryRow(ctx, query, id))
 }
 
-func (s *PostgresStorage) ListPosts(ctx context.Context) []*models.Post {
-	rows, err := s.db.Query(ctx, "SELECT id, title, content, comments_disabled, created_at FROM posts ORDER BY created_at DESC")
+// ToggleComments only updates a post whose flag differs. A concurrent call
+// setting the same value waits for the row lock, then re-checks the flag and
+// updates nothing, so only one of them reports a change.
+func (s *PostgresStorage) ToggleComments(ctx context.Context, id string, disabled bool) (*models.Post, bool, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, false, ErrNotFound
+	}
+
+	row := s.q(ctx).QueryRow(ctx,
+		`UPDATE posts SET comments_disabled = $1
+		 WHERE id = $2 AND deleted_at IS NULL AND comments_disabled <> $1
+		 RETURNING `+postColumns,
+		disabled, id,
+	)
+	p, err := scanPost(row)
+	if errors.Is(err, ErrNotFound) {
+		p, err = s.GetPost(ctx, id)
+		return p, false, err
+	}
+	return p, err == nil, err
+}
+
+func (s *PostgresStorage) ListPosts(ctx context.Context, opts ListPostsOptions) ([]*models.Post, *string, error) {
+	query := `SELECT ` + postColumns + `
+			  FROM posts
+			  WHERE deleted_at IS NULL `
+	var params []interface{}
+	arg := func(v interface{}) string {
+		params = append(params, v)
+		return fmt.Sprintf("$%d", len(params))
+	}
+
+	f := opts.Filter
+	if f.CommentsDisabled != nil {
+		query += ` AND comments_disabled = ` + arg(*f.CommentsDisabled)
+	}
+	if f.CreatedAfter != nil {
+		query += ` AND created_at > ` + arg(*f.CreatedAfter)
+	}
+	if f.CreatedBefore != nil {
+		query += ` AND created_at < ` + arg(*f.CreatedBefore)
+	}
+	if f.TitleContains != "" {
+		query += ` AND strpos(lower(title), lower(` + arg(f.TitleContains) + `)) > 0`
+	}
+
+	cmp, dir := "<", "DESC"
+	if opts.OrderBy == OrderCreatedAtAsc {
+		cmp, dir = ">", "ASC"
+	}
+
+	if opts.After != nil {
+		cur, err := decodePostCursor(*opts.After)
+		if err != nil {
+			return nil, nil, err
+		}
+		query += ` AND (created_at, id) ` + cmp + ` (` + arg(cur.CreatedAt) + `, ` + arg(cur.ID) + `)`
+	}
+
+	query += ` ORDER BY created_at ` + dir + `, id ` + dir
+	if opts.First > 0 {
+		query += ` LIMIT ` + arg(opts.First+1)
+	}
+
+	rows, err := s.q(ctx).Query(ctx, query, params...)
 	if err != nil {
-		return nil
+		return nil, nil, err
 	}
 	defer rows.Close()
 
 	var posts []*models.Post
 	for rows.Next() {
-		var p models.Post
-		if err := rows.Scan(&p.ID, &p.Title, &p.Content, &p.CommentsDisabled, &p.CreatedAt); err != nil {
-			continue
+		p, err := scanPost(rows)
+		if err != nil {
+			return nil, nil, err
 		}
-		posts = append(posts, &p)
+		posts = append(posts, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if opts.First <= 0 || len(posts) <= opts.First {
+		return posts, nil, nil
 	}
 
-	return posts
+	posts = posts[:opts.First]
+	last := posts[len(posts)-1]
+	next := encodePostCursor(last.CreatedAt, last.ID)
+	return posts, &next, nil
 }
 
 func (s *PostgresStorage) GetPost(ctx context.Context, id string) (*models.Post, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrNotFound
+	}
+
+	return scanPost(s.q(ctx).QueryRow(ctx, `SELECT `+postColumns+` FROM posts WHERE id = $1 AND deleted_at IS NULL`, id))
+}
+
+func scanPost(row pgx.Row) (*models.Post, error) {
 	var p models.Post
-	err := s.db.QueryRow(ctx, "SELECT id, title, content, comments_disabled, created_at FROM posts WHERE id = $1", id).Scan(
-		&p.ID, &p.Title, &p.Content, &p.CommentsDisabled, &p.CreatedAt,
+	err := row.Scan(
+		&p.ID, &p.Title, &p.Content, &p.CommentsDisabled, &p.CreatedAt, &p.CommentCount, &p.LastCommentAt, &p.DeletedAt,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -125,47 +339,115 @@ func (s *PostgresStorage) GetPost(ctx context.Context, id string) (*models.Post,
 	return &p, nil
 }
 
-func (s *PostgresStorage) CreateComment(ctx context.Context, postID string, parentID *string, content string) (*models.Comment, error) {
-	if len(content) > maxCommentLen {
+func (s *PostgresStorage) CreateComment(ctx context.Context, postID string, parentID, author *string, content string) (*models.Comment, error) {
+	if len(content) > s.maxCommentLen {
 		return nil, ErrTooLong
 	}
 
-	var commentsDisabled bool
-	err := s.db.QueryRow(ctx, "SELECT comments_disabled FROM posts WHERE id = $1", postID).Scan(&commentsDisabled)
-	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, ErrNotFound
+	c := &models.Comment{
+		ID:        uuid.NewString(),
+		PostID:    postID,
+		ParentID:  parentID,
+		Content:   content,
+		CreatedAt: time.Now(),
+		Author:    author,
+	}
+	err := s.WithTx(ctx, func(ctx context.Context) error {
+		disabled, err := s.lockPost(ctx, postID)
+		if err != nil {
+			return err
+		}
+		if disabled {
+			return ErrForbidden
 		}
+		if parentID != nil {
+			if err := s.checkParent(ctx, postID, *parentID); err != nil {
+				return err
+			}
+		}
+		return s.insertComment(ctx, c)
+	})
+	if err != nil {
 		return nil, err
 	}
-	if commentsDisabled {
-		return nil, ErrForbidden
+	return c, nil
+}
+
+// lockPost locks a live post row until the transaction ends and reports
+// whether its comments are disabled. Holding the lock keeps ToggleComments
+// and DeletePost from slipping in between the check and the insert. It is
+// FOR NO KEY UPDATE rather than FOR SHARE because the transaction goes on to
+// update the post's counters: two writers holding share locks on the same
+// row would deadlock upgrading them.
+func (s *PostgresStorage) lockPost(ctx context.Context, postID string) (commentsDisabled bool, err error) {
+	if _, err := uuid.Parse(postID); err != nil {
+		return false, ErrNotFound
+	}
+	err = s.q(ctx).QueryRow(ctx,
+		`SELECT comments_disabled FROM posts WHERE id = $1 AND deleted_at IS NULL FOR NO KEY UPDATE`,
+		postID,
+	).Scan(&commentsDisabled)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, ErrNotFound
 	}
+	return commentsDisabled, err
+}
 
-	id := uuid.NewString()
-	now := time.Now()
+// checkParent makes sure a reply to parentID can go under postID. Comments
+// only disappear along with their post, so the caller's post lock keeps the
+// parent in place until the reply is inserted.
+func (s *PostgresStorage) checkParent(ctx context.Context, postID, parentID string) error {
+	if _, err := uuid.Parse(parentID); err != nil {
+		return ErrNotFound
+	}
+	var samePost bool
+	err := s.q(ctx).QueryRow(ctx, `SELECT post_id = $2 FROM comments WHERE id = $1`, parentID, postID).Scan(&samePost)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !samePost {
+		return ErrWrongPost
+	}
+	return nil
+}
 
-	_, err = s.db.Exec(ctx,
-		`INSERT INTO comments (id, post_id, parent_id, content, created_at) VALUES ($1, $2, $3, $4, $5)`,
-		id, postID, parentID, content, now,
+// insertComment stores c and updates its post's counters. The caller must
+// hold the post lock.
+func (s *PostgresStorage) insertComment(ctx context.Context, c *models.Comment) error {
+	_, err := s.q(ctx).Exec(ctx,
+		`INSERT INTO comments (id, post_id, parent_id, content, created_at, author) VALUES ($1, $2, $3, $4, $5, $6)`,
+		c.ID, c.PostID, c.ParentID, c.Content, c.CreatedAt, c.Author,
 	)
 	if err != nil {
-		return nil, err
+		return importErr(err)
 	}
 
-	return &models.Comment{
-		ID:        id,
-		PostID:    postID,
-		ParentID:  parentID,
-		Content:   content,
-		CreatedAt: now,
-	}, nil
+	_, err = s.q(ctx).Exec(ctx,
+		`UPDATE posts SET comment_count = comment_count + 1, last_comment_at = GREATEST(last_comment_at, $1) WHERE id = $2`,
+		c.CreatedAt, c.PostID,
+	)
+	return err
 }
 
-func (s *PostgresStorage) ListComments(ctx context.Context, postID string, first int, afterID *string) ([]*models.Comment, *string) {
-	query := `SELECT id, post_id, parent_id, content, created_at
+// ListComments treats malformed IDs like unknown ones: no post has them, and
+// no comment to continue after.
+func (s *PostgresStorage) ListComments(ctx context.Context, postID string, first int, afterID *string) ([]*models.Comment, *string, error) {
+	if _, err := uuid.Parse(postID); err != nil {
+		return nil, nil, nil
+	}
+	if afterID != nil {
+		if _, err := uuid.Parse(*afterID); err != nil {
+			return nil, nil, nil
+		}
+	}
+
+	query := `SELECT id, post_id, parent_id, content, created_at, author
 			  FROM comments
-			  WHERE post_id = $1 `
+			  WHERE post_id = $1
+			  AND EXISTS (SELECT 1 FROM posts WHERE id = $1 AND deleted_at IS NULL) `
 	params := []interface{}{postID}
 
 	if afterID != nil {
@@ -177,9 +459,9 @@ func (s *PostgresStorage) ListComments(ctx context.Context, postID string, first
 		params = append(params, first)
 	}
 
-	rows, err := s.db.Query(ctx, query, params...)
+	rows, err := s.q(ctx).Query(ctx, query, params...)
 	if err != nil {
-		return nil, nil
+		return nil, nil, err
 	}
 	defer rows.Close()
 
@@ -187,12 +469,15 @@ func (s *PostgresStorage) ListComments(ctx context.Context, postID string, first
 	for rows.Next() {
 		var c models.Comment
 		var parentID *string
-		if err := rows.Scan(&c.ID, &c.PostID, &parentID, &c.Content, &c.CreatedAt); err != nil {
-			continue
+		if err := rows.Scan(&c.ID, &c.PostID, &parentID, &c.Content, &c.CreatedAt, &c.Author); err != nil {
+			return nil, nil, err
 		}
 		c.ParentID = parentID
 		comments = append(comments, &c)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
 
 	var nextCursor *string
 	if len(comments) > 0 {
@@ -200,5 +485,635 @@ func (s *PostgresStorage) ListComments(ctx context.Context, postID string, first
 		nextCursor = &lastID
 	}
 
-	return comments, nextCursor
+	return comments, nextCursor, nil
+}
+
+func (s *PostgresStorage) GetComment(ctx context.Context, id string) (*models.Comment, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrNotFound
+	}
+
+	var c models.Comment
+	err := s.q(ctx).QueryRow(ctx,
+		`SELECT id, post_id, parent_id, content, created_at, author FROM comments WHERE id = $1`, id,
+	).Scan(&c.ID, &c.PostID, &c.ParentID, &c.Content, &c.CreatedAt, &c.Author)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// CommentAncestry walks up the thread in a single recursive query.
+func (s *PostgresStorage) CommentAncestry(ctx context.Context, id string, limit int) ([]*models.Comment, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrNotFound
+	}
+
+	rows, err := s.q(ctx).Query(ctx,
+		`WITH RECURSIVE chain AS (
+			SELECT id, post_id, parent_id, content, created_at, author, 1 AS depth
+			FROM comments WHERE id = $1
+			UNION ALL
+			SELECT c.id, c.post_id, c.parent_id, c.content, c.created_at, c.author, chain.depth + 1
+			FROM comments c JOIN chain ON c.id = chain.parent_id
+			WHERE chain.depth < $2
+		)
+		SELECT id, post_id, parent_id, content, created_at, author FROM chain ORDER BY depth`,
+		id, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []*models.Comment
+	for rows.Next() {
+		var c models.Comment
+		if err := rows.Scan(&c.ID, &c.PostID, &c.ParentID, &c.Content, &c.CreatedAt, &c.Author); err != nil {
+			return nil, err
+		}
+		res = append(res, &c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, ErrNotFound
+	}
+	return res, nil
+}
+
+func (s *PostgresStorage) ExportPosts(ctx context.Context, fn func(*models.Post) error) error {
+	rows, err := s.q(ctx).Query(ctx, `SELECT `+postColumns+` FROM posts ORDER BY created_at, id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanPost(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ExportComments walks reply trees breadth-first, so every parent precedes
+// its replies whatever their timestamps.
+// ListPostComments first locks the post row as a DELETE would, which keeps
+// CreateComment from adding to the post until the caller's transaction ends.
+func (s *PostgresStorage) ListPostComments(ctx context.Context, postID string) ([]*models.Comment, error) {
+	if _, err := uuid.Parse(postID); err != nil {
+		return nil, nil
+	}
+	if _, err := s.q(ctx).Exec(ctx, `SELECT 1 FROM posts WHERE id = $1 FOR UPDATE`, postID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.q(ctx).Query(ctx,
+		`SELECT id, post_id, parent_id, content, created_at, author FROM comments
+		 WHERE post_id = $1 ORDER BY created_at, id`,
+		postID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []*models.Comment
+	for rows.Next() {
+		var c models.Comment
+		if err := rows.Scan(&c.ID, &c.PostID, &c.ParentID, &c.Content, &c.CreatedAt, &c.Author); err != nil {
+			return nil, err
+		}
+		comments = append(comments, &c)
+	}
+	return comments, rows.Err()
+}
+
+func (s *PostgresStorage) ExportComments(ctx context.Context, fn func(*models.Comment) error) error {
+	rows, err := s.q(ctx).Query(ctx, `
+		WITH RECURSIVE tree AS (
+			SELECT id, post_id, parent_id, content, created_at, author, 0 AS depth
+			FROM comments WHERE parent_id IS NULL
+			UNION ALL
+			SELECT c.id, c.post_id, c.parent_id, c.content, c.created_at, c.author, t.depth + 1
+			FROM comments c JOIN tree t ON c.parent_id = t.id
+		)
+		SELECT id, post_id, parent_id, content, created_at, author FROM tree
+		ORDER BY depth, created_at, id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c models.Comment
+		if err := rows.Scan(&c.ID, &c.PostID, &c.ParentID, &c.Content, &c.CreatedAt, &c.Author); err != nil {
+			return err
+		}
+		if err := fn(&c); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *PostgresStorage) ImportPost(ctx context.Context, p *models.Post) error {
+	_, err := s.q(ctx).Exec(ctx,
+		`INSERT INTO posts (id, title, content, comments_disabled, created_at, deleted_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+		p.ID, p.Title, p.Content, p.CommentsDisabled, p.CreatedAt, p.DeletedAt,
+	)
+	return importErr(err)
+}
+
+func (s *PostgresStorage) ImportComment(ctx context.Context, c *models.Comment) error {
+	return s.WithTx(ctx, func(ctx context.Context) error {
+		var exists bool
+		err := s.q(ctx).QueryRow(ctx, `SELECT true FROM posts WHERE id = $1 FOR NO KEY UPDATE`, c.PostID).Scan(&exists)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if c.ParentID != nil {
+			var parentPost string
+			err = s.q(ctx).QueryRow(ctx, `SELECT post_id FROM comments WHERE id = $1`, *c.ParentID).Scan(&parentPost)
+			if errors.Is(err, pgx.ErrNoRows) || err == nil && parentPost != c.PostID {
+				return ErrNotFound
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return s.insertComment(ctx, c)
+	})
+}
+
+func importErr(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return ErrConflict
+	}
+	return err
+}
+
+// querier is what pgxpool.Pool and pgx.Tx have in common.
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+type pgTxKey struct{}
+
+// q returns the transaction ctx belongs to, or the pool outside of one.
+func (s *PostgresStorage) q(ctx context.Context) querier {
+	if tx, ok := ctx.Value(pgTxKey{}).(pgx.Tx); ok {
+		return tx
+	}
+	return s.db
+}
+
+// WithTx runs fn in a transaction. Nested calls open a savepoint, so an
+// inner failure the caller recovers from does not doom the outer one.
+func (s *PostgresStorage) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	tx, err := s.q(ctx).Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := fn(context.WithValue(ctx, pgTxKey{}, tx)); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (s *PostgresStorage) AddOutboxEvent(ctx context.Context, e *OutboxEvent) error {
+	_, err := s.q(ctx).Exec(ctx,
+		`INSERT INTO outbox (id, topic, payload, created_at) VALUES ($1, $2, $3, $4)`,
+		e.ID, e.Topic, e.Payload, e.CreatedAt,
+	)
+	return err
+}
+
+func (s *PostgresStorage) PendingOutboxEvents(ctx context.Context, limit int) ([]*OutboxEvent, error) {
+	rows, err := s.q(ctx).Query(ctx,
+		`SELECT id, topic, payload, created_at FROM outbox
+		 WHERE delivered_at IS NULL
+		 ORDER BY seq LIMIT $1`,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanOutboxEvents(rows)
+}
+
+func (s *PostgresStorage) ClaimOutboxEvents(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*OutboxEvent, error) {
+	rows, err := s.q(ctx).Query(ctx,
+		`WITH claimed AS (
+			UPDATE outbox SET claimed_until = $2
+			WHERE id IN (
+				SELECT id FROM outbox
+				WHERE delivered_at IS NULL AND (claimed_until IS NULL OR claimed_until <= $1)
+				ORDER BY seq LIMIT $3
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING id, seq, topic, payload, created_at
+		 )
+		 SELECT id, topic, payload, created_at FROM claimed ORDER BY seq`,
+		now, now.Add(lease), limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanOutboxEvents(rows)
+}
+
+func (s *PostgresStorage) ReleaseOutboxEvents(ctx context.Context, ids []string) error {
+	_, err := s.q(ctx).Exec(ctx,
+		`UPDATE outbox SET claimed_until = NULL WHERE id = ANY($1) AND delivered_at IS NULL`,
+		ids,
+	)
+	return err
+}
+
+func scanOutboxEvents(rows pgx.Rows) ([]*OutboxEvent, error) {
+	defer rows.Close()
+
+	var events []*OutboxEvent
+	for rows.Next() {
+		var e OutboxEvent
+		if err := rows.Scan(&e.ID, &e.Topic, &e.Payload, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, &e)
+	}
+	return events, rows.Err()
+}
+
+func (s *PostgresStorage) MarkOutboxDelivered(ctx context.Context, ids []string) error {
+	_, err := s.q(ctx).Exec(ctx,
+		`UPDATE outbox SET delivered_at = now() WHERE id = ANY($1) AND delivered_at IS NULL`,
+		ids,
+	)
+	return err
+}
+
+func (s *PostgresStorage) PruneOutbox(ctx context.Context, deliveredBefore time.Time) (int, error) {
+	tag, err := s.q(ctx).Exec(ctx, `DELETE FROM outbox WHERE delivered_at < $1`, deliveredBefore)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+func (s *PostgresStorage) AddNotification(ctx context.Context, n *models.Notification) error {
+	_, err := s.q(ctx).Exec(ctx,
+		`INSERT INTO notifications (id, user_name, type, post_id, comment_id, actor, created_at, read_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		n.ID, n.User, n.Type, n.PostID, n.CommentID, n.Actor, n.CreatedAt, n.ReadAt,
+	)
+	return err
+}
+
+func (s *PostgresStorage) ListNotifications(ctx context.Context, user string, opts ListNotificationsOptions) ([]*models.Notification, *string, error) {
+	var params []interface{}
+	arg := func(v interface{}) string {
+		params = append(params, v)
+		return fmt.Sprintf("$%d", len(params))
+	}
+
+	query := `SELECT id, user_name, type, post_id, comment_id, actor, created_at, read_at
+			  FROM notifications
+			  WHERE user_name = ` + arg(user)
+	if opts.UnreadOnly {
+		query += ` AND read_at IS NULL`
+	}
+	if opts.After != nil {
+		cur, err := decodePostCursor(*opts.After)
+		if err != nil {
+			return nil, nil, err
+		}
+		query += ` AND (created_at, id) < (` + arg(cur.CreatedAt) + `, ` + arg(cur.ID) + `)`
+	}
+	query += ` ORDER BY created_at DESC, id DESC LIMIT ` + arg(opts.First+1)
+
+	rows, err := s.q(ctx).Query(ctx, query, params...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var res []*models.Notification
+	for rows.Next() {
+		var n models.Notification
+		if err := rows.Scan(&n.ID, &n.User, &n.Type, &n.PostID, &n.CommentID, &n.Actor, &n.CreatedAt, &n.ReadAt); err != nil {
+			return nil, nil, err
+		}
+		res = append(res, &n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(res) <= opts.First {
+		return res, nil, nil
+	}
+	res = res[:opts.First]
+	last := res[len(res)-1]
+	next := encodePostCursor(last.CreatedAt, last.ID)
+	return res, &next, nil
+}
+
+func (s *PostgresStorage) MarkNotificationsRead(ctx context.Context, user string, ids []string) (int, error) {
+	query := `UPDATE notifications SET read_at = now() WHERE user_name = $1 AND read_at IS NULL`
+	args := []interface{}{user}
+	if ids != nil {
+		valid := make([]string, 0, len(ids))
+		for _, id := range ids {
+			if _, err := uuid.Parse(id); err == nil {
+				valid = append(valid, id)
+			}
+		}
+		query += ` AND id = ANY($2)`
+		args = append(args, valid)
+	}
+
+	tag, err := s.q(ctx).Exec(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+func (s *PostgresStorage) CreateWebhook(ctx context.Context, w *models.Webhook) error {
+	_, err := s.q(ctx).Exec(ctx,
+		`INSERT INTO webhooks (id, url, events, secret, created_at) VALUES ($1, $2, $3, $4, $5)`,
+		w.ID, w.URL, w.Events, w.Secret, w.CreatedAt,
+	)
+	return err
+}
+
+func (s *PostgresStorage) AddAttachment(ctx context.Context, a *models.Attachment) error {
+	_, err := s.q(ctx).Exec(ctx,
+		`INSERT INTO attachments (id, comment_id, filename, content_type, size, blob_key, thumbnail_key, width, height, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		a.ID, a.CommentID, a.Filename, a.ContentType, a.Size, a.Key, a.ThumbnailKey, a.Width, a.Height, a.CreatedAt,
+	)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *PostgresStorage) ListAttachments(ctx context.Context, commentID string) ([]*models.Attachment, error) {
+	if _, err := uuid.Parse(commentID); err != nil {
+		return nil, nil
+	}
+
+	rows, err := s.q(ctx).Query(ctx,
+		`SELECT `+attachmentColumns+` FROM attachments WHERE comment_id = $1 ORDER BY seq`, commentID)
+	if err != nil {
+		return nil, err
+	}
+	return scanAttachments(rows)
+}
+
+func (s *PostgresStorage) ListAttachmentsFor(ctx context.Context, commentIDs []string) (map[string][]*models.Attachment, error) {
+	ids := make([]string, 0, len(commentIDs))
+	for _, id := range commentIDs {
+		if _, err := uuid.Parse(id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	res := make(map[string][]*models.Attachment)
+	if len(ids) == 0 {
+		return res, nil
+	}
+
+	rows, err := s.q(ctx).Query(ctx,
+		`SELECT `+attachmentColumns+` FROM attachments WHERE comment_id = ANY($1) ORDER BY seq`, ids)
+	if err != nil {
+		return nil, err
+	}
+	attachments, err := scanAttachments(rows)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range attachments {
+		res[a.CommentID] = append(res[a.CommentID], a)
+	}
+	return res, nil
+}
+
+func (s *PostgresStorage) ListPostAttachments(ctx context.Context, postID string) ([]*models.Attachment, error) {
+	if _, err := uuid.Parse(postID); err != nil {
+		return nil, nil
+	}
+
+	rows, err := s.q(ctx).Query(ctx,
+		`SELECT `+attachmentColumns+` FROM attachments
+		 WHERE comment_id IN (SELECT id FROM comments WHERE post_id = $1) ORDER BY seq`, postID)
+	if err != nil {
+		return nil, err
+	}
+	return scanAttachments(rows)
+}
+
+const attachmentColumns = `id, comment_id, filename, content_type, size, blob_key, thumbnail_key, width, height, created_at`
+
+func scanAttachments(rows pgx.Rows) ([]*models.Attachment, error) {
+	defer rows.Close()
+
+	var res []*models.Attachment
+	for rows.Next() {
+		var a models.Attachment
+		err := rows.Scan(&a.ID, &a.CommentID, &a.Filename, &a.ContentType, &a.Size, &a.Key,
+			&a.ThumbnailKey, &a.Width, &a.Height, &a.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, &a)
+	}
+	return res, rows.Err()
+}
+
+func (s *PostgresStorage) ListWebhooks(ctx context.Context) ([]*models.Webhook, error) {
+	rows, err := s.q(ctx).Query(ctx, `SELECT id, url, events, secret, created_at FROM webhooks ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []*models.Webhook
+	for rows.Next() {
+		var w models.Webhook
+		if err := rows.Scan(&w.ID, &w.URL, &w.Events, &w.Secret, &w.CreatedAt); err != nil {
+			return nil, err
+		}
+		res = append(res, &w)
+	}
+	return res, rows.Err()
+}
+
+const webhookDeliveryColumns = `id, webhook_id, event_id, event, payload, status, attempts,
+	response_status, last_error, created_at, next_attempt_at, delivered_at`
+
+func scanWebhookDeliveries(rows pgx.Rows) ([]*models.WebhookDelivery, error) {
+	defer rows.Close()
+
+	var res []*models.WebhookDelivery
+	for rows.Next() {
+		var d models.WebhookDelivery
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.Event, &d.Payload, &d.Status, &d.Attempts,
+			&d.ResponseStatus, &d.LastError, &d.CreatedAt, &d.NextAttemptAt, &d.DeliveredAt)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, &d)
+	}
+	return res, rows.Err()
+}
+
+func (s *PostgresStorage) AddWebhookDelivery(ctx context.Context, d *models.WebhookDelivery) error {
+	_, err := s.q(ctx).Exec(ctx,
+		`INSERT INTO webhook_deliveries (id, webhook_id, event_id, event, payload, status, attempts, created_at, next_attempt_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		 ON CONFLICT (webhook_id, event_id) DO NOTHING`,
+		d.ID, d.WebhookID, d.EventID, d.Event, d.Payload, d.Status, d.Attempts, d.CreatedAt, d.NextAttemptAt,
+	)
+	return err
+}
+
+func (s *PostgresStorage) ClaimWebhookDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*models.WebhookDelivery, error) {
+	rows, err := s.q(ctx).Query(ctx,
+		`UPDATE webhook_deliveries SET next_attempt_at = $2
+		 WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'PENDING' AND (next_attempt_at IS NULL OR next_attempt_at <= $1)
+			ORDER BY next_attempt_at NULLS FIRST, seq LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		 )
+		 RETURNING `+webhookDeliveryColumns,
+		now, now.Add(lease), limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanWebhookDeliveries(rows)
+}
+
+func (s *PostgresStorage) UpdateWebhookDelivery(ctx context.Context, d *models.WebhookDelivery) error {
+	tag, err := s.q(ctx).Exec(ctx,
+		`UPDATE webhook_deliveries
+		 SET status = $2, attempts = $3, response_status = $4, last_error = $5, next_attempt_at = $6, delivered_at = $7
+		 WHERE id = $1`,
+		d.ID, d.Status, d.Attempts, d.ResponseStatus, d.LastError, d.NextAttemptAt, d.DeliveredAt,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStorage) ListWebhookDeliveries(ctx context.Context, filter WebhookDeliveryFilter, limit int) ([]*models.WebhookDelivery, error) {
+	if filter.WebhookID != nil {
+		if _, err := uuid.Parse(*filter.WebhookID); err != nil {
+			return nil, nil
+		}
+	}
+
+	rows, err := s.q(ctx).Query(ctx,
+		`SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries
+		 WHERE ($1::uuid IS NULL OR webhook_id = $1) AND ($2::text IS NULL OR status = $2)
+		 ORDER BY seq DESC LIMIT $3`,
+		filter.WebhookID, filter.Status, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanWebhookDeliveries(rows)
+}
+
+func (s *PostgresStorage) PruneWebhookDeliveries(ctx context.Context, finishedBefore time.Time) (int, error) {
+	tag, err := s.q(ctx).Exec(ctx,
+		`DELETE FROM webhook_deliveries
+		 WHERE (status = 'DELIVERED' AND delivered_at < $1) OR (status = 'DEAD' AND created_at < $1)`,
+		finishedBefore,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+func (s *PostgresStorage) GetPersistedQuery(ctx context.Context, hash string) (string, error) {
+	var query string
+	err := s.q(ctx).QueryRow(ctx, "SELECT query FROM persisted_queries WHERE hash = $1", hash).Scan(&query)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+	return query, nil
+}
+
+func (s *PostgresStorage) SavePersistedQuery(ctx context.Context, hash, query string) error {
+	_, err := s.q(ctx).Exec(ctx,
+		`INSERT INTO persisted_queries (hash, query) VALUES ($1, $2) ON CONFLICT (hash) DO NOTHING`,
+		hash, query,
+	)
+	return err
+}
+
+func (s *PostgresStorage) PrunePersistedQueries(ctx context.Context, savedBefore time.Time) (int, error) {
+	tag, err := s.q(ctx).Exec(ctx, `DELETE FROM persisted_queries WHERE created_at < $1`, savedBefore)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// Health checks that the database is reachable and that the schema created by
+// createTables is in place.
+func (s *PostgresStorage) Health(ctx context.Context) error {
+	if err := s.db.Ping(ctx); err != nil {
+		return fmt.Errorf("ping: %w", err)
+	}
+
+	_, err := s.q(ctx).Exec(ctx, `SELECT id, comment_count, deleted_at FROM posts LIMIT 0`)
+	if err != nil {
+		return fmt.Errorf("schema not migrated: %w", err)
+	}
+	_, err = s.q(ctx).Exec(ctx, `SELECT id FROM comments LIMIT 0`)
+	if err != nil {
+		return fmt.Errorf("schema not migrated: %w", err)
+	}
+	return nil
+}
+
+// Stat exposes connection pool statistics for metrics.
+func (s *PostgresStorage) Stat() *pgxpool.Stat {
+	return s.db.Stat()
+}
+
+func (s *PostgresStorage) Close() {
+	s.db.Close()
 }
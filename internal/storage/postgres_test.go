@@ -0,0 +1,107 @@
+package storage_test
+
+import (
+	"context"
+	"os"
+	"ozon-comments-graphql/internal/storage"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newPostgresStorage connects to the database in TEST_DATABASE_URL and skips
+// the test when it is not set. Tests share the database, so they only look
+// at the rows they create.
+func newPostgresStorage(t *testing.T) *storage.PostgresStorage {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	s, err := storage.NewPostgresStorage(context.Background(), url)
+	require.NoError(t, err)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestPostgresStorage_MalformedIDs(t *testing.T) {
+	s := newPostgresStorage(t)
+	ctx := context.Background()
+	const id = "not-a-uuid"
+
+	_, err := s.GetPost(ctx, id)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	title := "Title"
+	_, err = s.UpdatePost(ctx, id, &title, nil)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	_, err = s.DeletePost(ctx, id, false)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = s.DeletePost(ctx, id, true)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	_, _, err = s.ToggleComments(ctx, id, true)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	_, err = s.CreateComment(ctx, id, nil, nil, "Comment")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	comments, next, err := s.ListComments(ctx, id, 10, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, comments)
+	assert.Nil(t, next)
+
+	post := s.CreatePost(ctx, "Title", "Content")
+	_, err = s.CreateComment(ctx, post.ID, nil, nil, "Comment")
+	require.NoError(t, err)
+	after := id
+	comments, _, err = s.ListComments(ctx, post.ID, 10, &after)
+	assert.NoError(t, err)
+	assert.Empty(t, comments)
+}
+
+func TestPostgresStorage_SoftDeletePost(t *testing.T) {
+	s := newPostgresStorage(t)
+	ctx := context.Background()
+
+	post := s.CreatePost(ctx, "Title", "Content")
+	_, err := s.CreateComment(ctx, post.ID, nil, nil, "Comment")
+	require.NoError(t, err)
+
+	_, err = s.DeletePost(ctx, post.ID, true)
+	require.NoError(t, err)
+
+	comments, next, err := s.ListComments(ctx, post.ID, 10, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, comments)
+	assert.Nil(t, next)
+
+	all, err := s.ListPostComments(ctx, post.ID)
+	assert.NoError(t, err)
+	assert.Len(t, all, 1)
+}
+
+func TestPostgresStorage_CommentParent(t *testing.T) {
+	s := newPostgresStorage(t)
+	ctx := context.Background()
+
+	post := s.CreatePost(ctx, "Test Post", "Test Content")
+	other := s.CreatePost(ctx, "Other Post", "Test Content")
+	foreign, err := s.CreateComment(ctx, other.ID, nil, nil, "Elsewhere")
+	require.NoError(t, err)
+
+	for _, missing := range []string{"missing", uuid.NewString()} {
+		_, err = s.CreateComment(ctx, post.ID, &missing, nil, "Orphan")
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+	}
+
+	_, err = s.CreateComment(ctx, post.ID, &foreign.ID, nil, "Cross-post")
+	assert.ErrorIs(t, err, storage.ErrWrongPost)
+
+	got, err := s.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 0, got.CommentCount)
+}
@@ -0,0 +1,79 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/99designs/gqlgen/graphql"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type graphqlExtension struct{}
+
+var (
+	_ graphql.HandlerExtension    = graphqlExtension{}
+	_ graphql.ResponseInterceptor = graphqlExtension{}
+	_ graphql.FieldInterceptor    = graphqlExtension{}
+)
+
+// GraphQL returns a gqlgen extension that opens a span per operation response
+// and a child span per field backed by a resolver.
+func GraphQL() graphql.HandlerExtension {
+	return graphqlExtension{}
+}
+
+func (graphqlExtension) ExtensionName() string {
+	return "OpenTelemetryTracing"
+}
+
+func (graphqlExtension) Validate(graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (graphqlExtension) InterceptResponse(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	if !graphql.HasOperationContext(ctx) {
+		return next(ctx)
+	}
+
+	oc := graphql.GetOperationContext(ctx)
+	opType := "unknown"
+	if oc.Operation != nil {
+		opType = string(oc.Operation.Operation)
+	}
+	name := oc.OperationName
+	if name == "" {
+		name = "anonymous"
+	}
+
+	ctx, span := tracer().Start(ctx, "graphql."+opType+" "+name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("graphql.operation.type", opType),
+			attribute.String("graphql.operation.name", name),
+		),
+	)
+	defer span.End()
+
+	resp := next(ctx)
+	if resp != nil && len(resp.Errors) > 0 {
+		span.SetStatus(codes.Error, resp.Errors.Error())
+	}
+	return resp
+}
+
+func (graphqlExtension) InterceptField(ctx context.Context, next graphql.Resolver) (interface{}, error) {
+	fc := graphql.GetFieldContext(ctx)
+	if fc == nil || !fc.IsResolver {
+		return next(ctx)
+	}
+
+	ctx, span := tracer().Start(ctx, fc.Object+"."+fc.Field.Name,
+		trace.WithAttributes(
+			attribute.String("graphql.field.path", fc.Path().String()),
+		),
+	)
+	res, err := next(ctx)
+	endSpan(span, err)
+	return res, err
+}
@@ -0,0 +1,266 @@
+package tracing
+
+import (
+	"context"
+	"ozon-comments-graphql/internal/models"
+	"ozon-comments-graphql/internal/storage"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type tracedStorage struct {
+	next storage.Storage
+}
+
+// Storage wraps s so that every call opens a child span.
+func Storage(s storage.Storage) storage.Storage {
+	return &tracedStorage{next: s}
+}
+
+func (s *tracedStorage) start(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer().Start(ctx, "storage."+method, trace.WithAttributes(attrs...))
+}
+
+func (s *tracedStorage) CreatePost(ctx context.Context, title, content string) *models.Post {
+	ctx, span := s.start(ctx, "CreatePost")
+	defer span.End()
+	return s.next.CreatePost(ctx, title, content)
+}
+
+func (s *tracedStorage) UpdatePost(ctx context.Context, id string, title, content *string) (p *models.Post, err error) {
+	ctx, span := s.start(ctx, "UpdatePost", attribute.String("post.id", id))
+	defer func() { endSpan(span, err) }()
+	return s.next.UpdatePost(ctx, id, title, content)
+}
+
+func (s *tracedStorage) DeletePost(ctx context.Context, id string, soft bool) (p *models.Post, err error) {
+	ctx, span := s.start(ctx, "DeletePost", attribute.String("post.id", id), attribute.Bool("soft", soft))
+	defer func() { endSpan(span, err) }()
+	return s.next.DeletePost(ctx, id, soft)
+}
+
+func (s *tracedStorage) ToggleComments(ctx context.Context, id string, disabled bool) (p *models.Post, changed bool, err error) {
+	ctx, span := s.start(ctx, "ToggleComments", attribute.String("post.id", id))
+	defer func() { endSpan(span, err) }()
+	return s.next.ToggleComments(ctx, id, disabled)
+}
+
+func (s *tracedStorage) ListPosts(ctx context.Context, opts storage.ListPostsOptions) (posts []*models.Post, next *string, err error) {
+	ctx, span := s.start(ctx, "ListPosts", attribute.Int("first", opts.First))
+	defer func() { endSpan(span, err) }()
+	return s.next.ListPosts(ctx, opts)
+}
+
+func (s *tracedStorage) GetPost(ctx context.Context, id string) (p *models.Post, err error) {
+	ctx, span := s.start(ctx, "GetPost", attribute.String("post.id", id))
+	defer func() { endSpan(span, err) }()
+	return s.next.GetPost(ctx, id)
+}
+
+func (s *tracedStorage) CreateComment(ctx context.Context, postID string, parentID, author *string, content string) (c *models.Comment, err error) {
+	ctx, span := s.start(ctx, "CreateComment", attribute.String("post.id", postID))
+	defer func() { endSpan(span, err) }()
+	return s.next.CreateComment(ctx, postID, parentID, author, content)
+}
+
+func (s *tracedStorage) ListComments(ctx context.Context, postID string, first int, afterID *string) (c []*models.Comment, next *string, err error) {
+	ctx, span := s.start(ctx, "ListComments", attribute.String("post.id", postID), attribute.Int("first", first))
+	defer func() { endSpan(span, err) }()
+	return s.next.ListComments(ctx, postID, first, afterID)
+}
+
+func (s *tracedStorage) GetComment(ctx context.Context, id string) (c *models.Comment, err error) {
+	ctx, span := s.start(ctx, "GetComment", attribute.String("comment.id", id))
+	defer func() { endSpan(span, err) }()
+	return s.next.GetComment(ctx, id)
+}
+
+func (s *tracedStorage) CommentAncestry(ctx context.Context, id string, limit int) (c []*models.Comment, err error) {
+	ctx, span := s.start(ctx, "CommentAncestry", attribute.String("comment.id", id), attribute.Int("limit", limit))
+	defer func() { endSpan(span, err) }()
+	return s.next.CommentAncestry(ctx, id, limit)
+}
+
+func (s *tracedStorage) GetPersistedQuery(ctx context.Context, hash string) (q string, err error) {
+	ctx, span := s.start(ctx, "GetPersistedQuery")
+	defer func() { endSpan(span, err) }()
+	return s.next.GetPersistedQuery(ctx, hash)
+}
+
+func (s *tracedStorage) SavePersistedQuery(ctx context.Context, hash, query string) (err error) {
+	ctx, span := s.start(ctx, "SavePersistedQuery")
+	defer func() { endSpan(span, err) }()
+	return s.next.SavePersistedQuery(ctx, hash, query)
+}
+
+func (s *tracedStorage) PrunePersistedQueries(ctx context.Context, savedBefore time.Time) (n int, err error) {
+	ctx, span := s.start(ctx, "PrunePersistedQueries")
+	defer func() { endSpan(span, err) }()
+	return s.next.PrunePersistedQueries(ctx, savedBefore)
+}
+
+func (s *tracedStorage) ExportPosts(ctx context.Context, fn func(*models.Post) error) (err error) {
+	ctx, span := s.start(ctx, "ExportPosts")
+	defer func() { endSpan(span, err) }()
+	return s.next.ExportPosts(ctx, fn)
+}
+
+func (s *tracedStorage) ExportComments(ctx context.Context, fn func(*models.Comment) error) (err error) {
+	ctx, span := s.start(ctx, "ExportComments")
+	defer func() { endSpan(span, err) }()
+	return s.next.ExportComments(ctx, fn)
+}
+
+func (s *tracedStorage) ImportPost(ctx context.Context, p *models.Post) (err error) {
+	ctx, span := s.start(ctx, "ImportPost", attribute.String("post.id", p.ID))
+	defer func() { endSpan(span, err) }()
+	return s.next.ImportPost(ctx, p)
+}
+
+func (s *tracedStorage) ImportComment(ctx context.Context, c *models.Comment) (err error) {
+	ctx, span := s.start(ctx, "ImportComment", attribute.String("post.id", c.PostID))
+	defer func() { endSpan(span, err) }()
+	return s.next.ImportComment(ctx, c)
+}
+
+func (s *tracedStorage) AddOutboxEvent(ctx context.Context, e *storage.OutboxEvent) (err error) {
+	ctx, span := s.start(ctx, "AddOutboxEvent", attribute.String("outbox.topic", e.Topic))
+	defer func() { endSpan(span, err) }()
+	return s.next.AddOutboxEvent(ctx, e)
+}
+
+func (s *tracedStorage) PendingOutboxEvents(ctx context.Context, limit int) (events []*storage.OutboxEvent, err error) {
+	ctx, span := s.start(ctx, "PendingOutboxEvents", attribute.Int("limit", limit))
+	defer func() { endSpan(span, err) }()
+	return s.next.PendingOutboxEvents(ctx, limit)
+}
+
+func (s *tracedStorage) ClaimOutboxEvents(ctx context.Context, now time.Time, lease time.Duration, limit int) (events []*storage.OutboxEvent, err error) {
+	ctx, span := s.start(ctx, "ClaimOutboxEvents", attribute.Int("limit", limit))
+	defer func() { endSpan(span, err) }()
+	return s.next.ClaimOutboxEvents(ctx, now, lease, limit)
+}
+
+func (s *tracedStorage) ReleaseOutboxEvents(ctx context.Context, ids []string) (err error) {
+	ctx, span := s.start(ctx, "ReleaseOutboxEvents", attribute.Int("count", len(ids)))
+	defer func() { endSpan(span, err) }()
+	return s.next.ReleaseOutboxEvents(ctx, ids)
+}
+
+func (s *tracedStorage) MarkOutboxDelivered(ctx context.Context, ids []string) (err error) {
+	ctx, span := s.start(ctx, "MarkOutboxDelivered", attribute.Int("count", len(ids)))
+	defer func() { endSpan(span, err) }()
+	return s.next.MarkOutboxDelivered(ctx, ids)
+}
+
+func (s *tracedStorage) PruneOutbox(ctx context.Context, deliveredBefore time.Time) (n int, err error) {
+	ctx, span := s.start(ctx, "PruneOutbox")
+	defer func() { endSpan(span, err) }()
+	return s.next.PruneOutbox(ctx, deliveredBefore)
+}
+
+func (s *tracedStorage) AddNotification(ctx context.Context, n *models.Notification) (err error) {
+	ctx, span := s.start(ctx, "AddNotification", attribute.String("comment.id", n.CommentID))
+	defer func() { endSpan(span, err) }()
+	return s.next.AddNotification(ctx, n)
+}
+
+func (s *tracedStorage) ListNotifications(ctx context.Context, user string, opts storage.ListNotificationsOptions) (ns []*models.Notification, next *string, err error) {
+	ctx, span := s.start(ctx, "ListNotifications", attribute.Int("first", opts.First))
+	defer func() { endSpan(span, err) }()
+	return s.next.ListNotifications(ctx, user, opts)
+}
+
+func (s *tracedStorage) MarkNotificationsRead(ctx context.Context, user string, ids []string) (n int, err error) {
+	ctx, span := s.start(ctx, "MarkNotificationsRead")
+	defer func() { endSpan(span, err) }()
+	return s.next.MarkNotificationsRead(ctx, user, ids)
+}
+
+func (s *tracedStorage) AddAttachment(ctx context.Context, a *models.Attachment) (err error) {
+	ctx, span := s.start(ctx, "AddAttachment", attribute.String("comment.id", a.CommentID))
+	defer func() { endSpan(span, err) }()
+	return s.next.AddAttachment(ctx, a)
+}
+
+func (s *tracedStorage) ListAttachments(ctx context.Context, commentID string) (as []*models.Attachment, err error) {
+	ctx, span := s.start(ctx, "ListAttachments", attribute.String("comment.id", commentID))
+	defer func() { endSpan(span, err) }()
+	return s.next.ListAttachments(ctx, commentID)
+}
+
+func (s *tracedStorage) ListAttachmentsFor(ctx context.Context, commentIDs []string) (as map[string][]*models.Attachment, err error) {
+	ctx, span := s.start(ctx, "ListAttachmentsFor", attribute.Int("comments", len(commentIDs)))
+	defer func() { endSpan(span, err) }()
+	return s.next.ListAttachmentsFor(ctx, commentIDs)
+}
+
+func (s *tracedStorage) ListPostComments(ctx context.Context, postID string) (cs []*models.Comment, err error) {
+	ctx, span := s.start(ctx, "ListPostComments", attribute.String("post.id", postID))
+	defer func() { endSpan(span, err) }()
+	return s.next.ListPostComments(ctx, postID)
+}
+
+func (s *tracedStorage) ListPostAttachments(ctx context.Context, postID string) (as []*models.Attachment, err error) {
+	ctx, span := s.start(ctx, "ListPostAttachments", attribute.String("post.id", postID))
+	defer func() { endSpan(span, err) }()
+	return s.next.ListPostAttachments(ctx, postID)
+}
+
+func (s *tracedStorage) CreateWebhook(ctx context.Context, w *models.Webhook) (err error) {
+	ctx, span := s.start(ctx, "CreateWebhook", attribute.String("webhook.id", w.ID))
+	defer func() { endSpan(span, err) }()
+	return s.next.CreateWebhook(ctx, w)
+}
+
+func (s *tracedStorage) ListWebhooks(ctx context.Context) (hooks []*models.Webhook, err error) {
+	ctx, span := s.start(ctx, "ListWebhooks")
+	defer func() { endSpan(span, err) }()
+	return s.next.ListWebhooks(ctx)
+}
+
+func (s *tracedStorage) AddWebhookDelivery(ctx context.Context, d *models.WebhookDelivery) (err error) {
+	ctx, span := s.start(ctx, "AddWebhookDelivery", attribute.String("webhook.id", d.WebhookID), attribute.String("webhook.event", d.Event))
+	defer func() { endSpan(span, err) }()
+	return s.next.AddWebhookDelivery(ctx, d)
+}
+
+func (s *tracedStorage) ClaimWebhookDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) (ds []*models.WebhookDelivery, err error) {
+	ctx, span := s.start(ctx, "ClaimWebhookDeliveries", attribute.Int("limit", limit))
+	defer func() { endSpan(span, err) }()
+	return s.next.ClaimWebhookDeliveries(ctx, now, lease, limit)
+}
+
+func (s *tracedStorage) UpdateWebhookDelivery(ctx context.Context, d *models.WebhookDelivery) (err error) {
+	ctx, span := s.start(ctx, "UpdateWebhookDelivery", attribute.String("webhook.id", d.WebhookID), attribute.String("status", d.Status))
+	defer func() { endSpan(span, err) }()
+	return s.next.UpdateWebhookDelivery(ctx, d)
+}
+
+func (s *tracedStorage) ListWebhookDeliveries(ctx context.Context, filter storage.WebhookDeliveryFilter, limit int) (ds []*models.WebhookDelivery, err error) {
+	ctx, span := s.start(ctx, "ListWebhookDeliveries", attribute.Int("limit", limit))
+	defer func() { endSpan(span, err) }()
+	return s.next.ListWebhookDeliveries(ctx, filter, limit)
+}
+
+func (s *tracedStorage) PruneWebhookDeliveries(ctx context.Context, finishedBefore time.Time) (n int, err error) {
+	ctx, span := s.start(ctx, "PruneWebhookDeliveries")
+	defer func() { endSpan(span, err) }()
+	return s.next.PruneWebhookDeliveries(ctx, finishedBefore)
+}
+
+func (s *tracedStorage) WithTx(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	ctx, span := s.start(ctx, "WithTx")
+	defer func() { endSpan(span, err) }()
+	return s.next.WithTx(ctx, fn)
+}
+
+func (s *tracedStorage) Health(ctx context.Context) error {
+	return s.next.Health(ctx)
+}
+
+func (s *tracedStorage) Close() {
+	s.next.Close()
+}
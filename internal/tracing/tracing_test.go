@@ -0,0 +1,116 @@
+package tracing_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ozon-comments-graphql/graph"
+	"ozon-comments-graphql/internal/storage"
+	"ozon-comments-graphql/internal/tracing"
+
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// Package-level tracers bind to the first global provider, so every test
+// shares one recorder.
+var rec = tracetest.NewSpanRecorder()
+
+func TestMain(m *testing.M) {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	os.Exit(m.Run())
+}
+
+func TestGraphQLAndStorageSpans(t *testing.T) {
+	store := tracing.Storage(storage.NewMemoryStorage())
+	srv := handler.New(graph.NewExecutableSchema(graph.Config{Resolvers: &graph.Resolver{
+		Store:  store,
+		Broker: graph.NewBroker(),
+	}}))
+	srv.AddTransport(transport.POST{})
+	srv.Use(tracing.GraphQL())
+
+	body := `{"query":"query ListPosts { posts { items { id } } }","operationName":"ListPosts"}`
+	req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range rec.Ended() {
+		spans[s.Name()] = s
+	}
+
+	op, ok := spans["graphql.query ListPosts"]
+	assert.True(t, ok)
+	field, ok := spans["Query.posts"]
+	assert.True(t, ok)
+	call, ok := spans["storage.ListPosts"]
+	assert.True(t, ok)
+
+	assert.Equal(t, op.SpanContext().TraceID(), call.SpanContext().TraceID())
+	assert.Equal(t, op.SpanContext().SpanID(), field.Parent().SpanID())
+	assert.Equal(t, field.SpanContext().SpanID(), call.Parent().SpanID())
+}
+
+func TestBrokerPublishSpanCountsSubscribers(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	r := &graph.Resolver{Store: store, Broker: graph.NewBroker()}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	post := store.CreatePost(ctx, "Title", "Content")
+	for i := 0; i < 3; i++ {
+		_, err := r.Subscription().CommentAdded(ctx, post.ID, nil, nil)
+		assert.NoError(t, err)
+	}
+	reqCtx, req := otel.Tracer("test").Start(ctx, "request")
+	_, err := r.Mutation().CreateComment(reqCtx, post.ID, nil, "Hello", nil, nil)
+	assert.NoError(t, err)
+	req.End()
+
+	var publish []sdktrace.ReadOnlySpan
+	for _, s := range rec.Ended() {
+		switch {
+		case s.Name() == "broker.publish commentAdded" && s.SpanContext().TraceID() == req.SpanContext().TraceID():
+			publish = append(publish, s)
+		case strings.HasPrefix(s.Name(), "broker.deliver"):
+			t.Errorf("unexpected span per delivery: %s", s.Name())
+		}
+	}
+	if assert.Len(t, publish, 1) {
+		assert.Equal(t, req.SpanContext().SpanID(), publish[0].Parent().SpanID())
+		assert.Contains(t, publish[0].Attributes(), attribute.Int("subscribers", 3))
+		assert.Contains(t, publish[0].Attributes(), attribute.Int("dropped", 0))
+	}
+}
+
+func TestFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.json")
+	shutdown, err := tracing.Setup(context.Background(), tracing.Config{
+		ServiceName: "test",
+		Exporter:    tracing.ExporterFile,
+		File:        path,
+		SampleRatio: 1,
+	})
+	assert.NoError(t, err)
+
+	_, span := otel.Tracer("test").Start(context.Background(), "exported")
+	span.End()
+	assert.NoError(t, shutdown(context.Background()))
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"Name":"exported"`)
+}
@@ -0,0 +1,54 @@
+package transfer
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"ozon-comments-graphql/internal/admin"
+	"ozon-comments-graphql/internal/storage"
+)
+
+// Handler exports and imports the data of a running server: GET streams an
+// export, POST imports the NDJSON request body, only validating it with
+// ?dry-run=true. In-memory storage is private to the server process, so this
+// is the only way to move its data. Both require the admin token.
+func Handler(store storage.Storage) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := admin.Require(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.Header().Set("Cache-Control", "no-store")
+			if _, err := Export(r.Context(), store, w); err != nil {
+				// Part of the export may already be sent: abort the response
+				// so the client cannot mistake it for a complete one.
+				slog.ErrorContext(r.Context(), "export failed", "err", err)
+				panic(http.ErrAbortHandler)
+			}
+
+		case http.MethodPost:
+			dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry-run"))
+			stats, err := Import(r.Context(), store, r.Body, dryRun)
+			verb := "imported"
+			if dryRun {
+				verb = "validated"
+			}
+			msg := fmt.Sprintf("%s %d posts and %d comments", verb, stats.Posts, stats.Comments)
+			if err != nil {
+				http.Error(w, msg+": "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			fmt.Fprintln(w, msg)
+
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
@@ -0,0 +1,64 @@
+package transfer_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ozon-comments-graphql/internal/admin"
+	"ozon-comments-graphql/internal/storage"
+	"ozon-comments-graphql/internal/transfer"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	ctx := context.Background()
+	src := storage.NewMemoryStorage()
+	post := src.CreatePost(ctx, "Title", "Content")
+	root, err := src.CreateComment(ctx, post.ID, nil, nil, "root")
+	require.NoError(t, err)
+	_, err = src.CreateComment(ctx, post.ID, &root.ID, nil, "reply")
+	require.NoError(t, err)
+
+	serve := func(store storage.Storage, method, target, body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		admin.Middleware("s3cret", transfer.Handler(store)).ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, serve(src, http.MethodGet, "/admin/data", "", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(src, http.MethodGet, "/admin/data", "", "wrong").Code)
+
+	export := serve(src, http.MethodGet, "/admin/data", "", "s3cret")
+	require.Equal(t, http.StatusOK, export.Code)
+	assert.Equal(t, "application/x-ndjson", export.Header().Get("Content-Type"))
+	assert.Equal(t, 3, strings.Count(export.Body.String(), "\n"))
+
+	dst := storage.NewMemoryStorage()
+	rec := serve(dst, http.MethodPost, "/admin/data?dry-run=true", export.Body.String(), "s3cret")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "validated 1 posts and 2 comments\n", rec.Body.String())
+	_, err = dst.GetPost(ctx, post.ID)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	rec = serve(dst, http.MethodPost, "/admin/data", export.Body.String(), "s3cret")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "imported 1 posts and 2 comments\n", rec.Body.String())
+	got, err := dst.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, got.CommentCount)
+
+	rec = serve(dst, http.MethodPost, "/admin/data", export.Body.String(), "s3cret")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "already exists")
+
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(dst, http.MethodDelete, "/admin/data", "", "s3cret").Code)
+}
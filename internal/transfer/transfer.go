@@ -0,0 +1,195 @@
+// Package transfer moves posts and comments in and out of storage as NDJSON:
+// one JSON object per line, each tagged with its type. Posts come first and
+// comments follow with every parent before its replies, so a file can be
+// imported in a single streaming pass.
+package transfer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"ozon-comments-graphql/internal/models"
+	"ozon-comments-graphql/internal/notify"
+	"ozon-comments-graphql/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+const (
+	typePost    = "post"
+	typeComment = "comment"
+)
+
+type record struct {
+	Type             string     `json:"type"`
+	ID               string     `json:"id"`
+	PostID           string     `json:"postID,omitempty"`
+	ParentID         *string    `json:"parentID,omitempty"`
+	Author           *string    `json:"author,omitempty"`
+	Title            string     `json:"title,omitempty"`
+	Content          string     `json:"content"`
+	CommentsDisabled bool       `json:"commentsDisabled,omitempty"`
+	CreatedAt        time.Time  `json:"createdAt"`
+	DeletedAt        *time.Time `json:"deletedAt,omitempty"`
+}
+
+// Stats counts the records written or read.
+type Stats struct {
+	Posts    int
+	Comments int
+}
+
+// Export writes every post, then every comment, to w.
+func Export(ctx context.Context, store storage.Storage, w io.Writer) (Stats, error) {
+	var stats Stats
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+
+	err := store.ExportPosts(ctx, func(p *models.Post) error {
+		stats.Posts++
+		return enc.Encode(record{
+			Type:             typePost,
+			ID:               p.ID,
+			Title:            p.Title,
+			Content:          p.Content,
+			CommentsDisabled: p.CommentsDisabled,
+			CreatedAt:        p.CreatedAt,
+			DeletedAt:        p.DeletedAt,
+		})
+	})
+	if err != nil {
+		return stats, fmt.Errorf("export posts: %w", err)
+	}
+
+	err = store.ExportComments(ctx, func(c *models.Comment) error {
+		stats.Comments++
+		return enc.Encode(record{
+			Type:      typeComment,
+			ID:        c.ID,
+			PostID:    c.PostID,
+			ParentID:  c.ParentID,
+			Author:    c.Author,
+			Content:   c.Content,
+			CreatedAt: c.CreatedAt,
+		})
+	})
+	if err != nil {
+		return stats, fmt.Errorf("export comments: %w", err)
+	}
+	return stats, bw.Flush()
+}
+
+// Import reads records from r and stores them. The file must be
+// self-contained: a comment may only reference posts and parent comments
+// that appear on earlier lines, and the parent must belong to the same post.
+// With dryRun the file is only validated. Import stops at the first invalid
+// line; records before it stay imported.
+func Import(ctx context.Context, store storage.Storage, r io.Reader, dryRun bool) (Stats, error) {
+	var stats Stats
+	posts := make(map[string]struct{})
+	comments := make(map[string]string) // comment ID -> post ID
+
+	br := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		data, err := br.ReadBytes('\n')
+		if len(bytes.TrimSpace(data)) > 0 {
+			if err := importLine(ctx, store, data, posts, comments, &stats, dryRun); err != nil {
+				return stats, fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return stats, nil
+		}
+		if err != nil {
+			return stats, err
+		}
+	}
+}
+
+func importLine(ctx context.Context, store storage.Storage, data []byte,
+	posts map[string]struct{}, comments map[string]string, stats *Stats, dryRun bool) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var rec record
+	if err := dec.Decode(&rec); err != nil {
+		return fmt.Errorf("invalid record: %w", err)
+	}
+	if err := uuid.Validate(rec.ID); err != nil {
+		return fmt.Errorf("invalid id %q", rec.ID)
+	}
+	if rec.CreatedAt.IsZero() {
+		return fmt.Errorf("%s %s: createdAt is required", rec.Type, rec.ID)
+	}
+
+	switch rec.Type {
+	case typePost:
+		if _, ok := posts[rec.ID]; ok {
+			return fmt.Errorf("post %s: duplicate id", rec.ID)
+		}
+		posts[rec.ID] = struct{}{}
+		if dryRun {
+			stats.Posts++
+			return nil
+		}
+		err := store.ImportPost(ctx, &models.Post{
+			ID:               rec.ID,
+			Title:            rec.Title,
+			Content:          rec.Content,
+			CommentsDisabled: rec.CommentsDisabled,
+			CreatedAt:        rec.CreatedAt,
+			DeletedAt:        rec.DeletedAt,
+		})
+		if err != nil {
+			return fmt.Errorf("post %s: %w", rec.ID, err)
+		}
+		stats.Posts++
+
+	case typeComment:
+		if _, ok := comments[rec.ID]; ok {
+			return fmt.Errorf("comment %s: duplicate id", rec.ID)
+		}
+		if _, ok := posts[rec.PostID]; !ok {
+			return fmt.Errorf("comment %s: post %q does not precede it", rec.ID, rec.PostID)
+		}
+		if rec.ParentID != nil {
+			parentPost, ok := comments[*rec.ParentID]
+			if !ok {
+				return fmt.Errorf("comment %s: parent %q does not precede it", rec.ID, *rec.ParentID)
+			}
+			if parentPost != rec.PostID {
+				return fmt.Errorf("comment %s: parent %q belongs to another post", rec.ID, *rec.ParentID)
+			}
+		}
+		if rec.Author != nil && !notify.ValidUsername(*rec.Author) {
+			return fmt.Errorf("comment %s: invalid author %q", rec.ID, *rec.Author)
+		}
+		comments[rec.ID] = rec.PostID
+		if dryRun {
+			stats.Comments++
+			return nil
+		}
+		err := store.ImportComment(ctx, &models.Comment{
+			ID:        rec.ID,
+			PostID:    rec.PostID,
+			ParentID:  rec.ParentID,
+			Content:   rec.Content,
+			CreatedAt: rec.CreatedAt,
+			Author:    rec.Author,
+		})
+		if err != nil {
+			return fmt.Errorf("comment %s: %w", rec.ID, err)
+		}
+		stats.Comments++
+
+	default:
+		return fmt.Errorf("unknown record type %q", rec.Type)
+	}
+	return nil
+}
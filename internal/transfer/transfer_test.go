@@ -0,0 +1,123 @@
+package transfer_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"ozon-comments-graphql/internal/storage"
+	"ozon-comments-graphql/internal/transfer"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	src := storage.NewMemoryStorage()
+
+	post := src.CreatePost(ctx, "Title", "Content")
+	root, err := src.CreateComment(ctx, post.ID, nil, nil, "root")
+	require.NoError(t, err)
+	reply, err := src.CreateComment(ctx, post.ID, &root.ID, nil, "reply")
+	require.NoError(t, err)
+	_, err = src.CreateComment(ctx, post.ID, &reply.ID, nil, "nested")
+	require.NoError(t, err)
+	_, _, err = src.ToggleComments(ctx, post.ID, true)
+	require.NoError(t, err)
+	deleted := src.CreatePost(ctx, "Deleted", "Content")
+	_, err = src.DeletePost(ctx, deleted.ID, true)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	stats, err := transfer.Export(ctx, src, &buf)
+	require.NoError(t, err)
+	assert.Equal(t, transfer.Stats{Posts: 2, Comments: 3}, stats)
+	assert.Equal(t, 5, strings.Count(buf.String(), "\n"))
+
+	dst := storage.NewMemoryStorage()
+	stats, err = transfer.Import(ctx, dst, &buf, false)
+	require.NoError(t, err)
+	assert.Equal(t, transfer.Stats{Posts: 2, Comments: 3}, stats)
+
+	got, err := dst.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	want, err := src.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	assert.True(t, want.CreatedAt.Equal(got.CreatedAt))
+	assert.True(t, got.CommentsDisabled)
+	assert.Equal(t, 3, got.CommentCount)
+	assert.True(t, want.LastCommentAt.Equal(*got.LastCommentAt))
+
+	_, err = dst.GetPost(ctx, deleted.ID)
+	assert.ErrorIs(t, err, storage.ErrNotFound, "soft-deleted posts stay deleted")
+
+	srcComments, _, _ := src.ListComments(ctx, post.ID, 10, nil)
+	dstComments, _, _ := dst.ListComments(ctx, post.ID, 10, nil)
+	require.Len(t, dstComments, 3)
+	for i := range srcComments {
+		assert.Equal(t, srcComments[i].ID, dstComments[i].ID)
+		assert.Equal(t, srcComments[i].ParentID, dstComments[i].ParentID)
+		assert.True(t, srcComments[i].CreatedAt.Equal(dstComments[i].CreatedAt))
+	}
+}
+
+func TestImportValidation(t *testing.T) {
+	const (
+		postA    = `{"type":"post","id":"9b1d0c4e-5d7a-4f5e-8a39-7d1c0b4f8e01","title":"A","content":"","createdAt":"2024-01-01T00:00:00Z"}`
+		postB    = `{"type":"post","id":"9b1d0c4e-5d7a-4f5e-8a39-7d1c0b4f8e02","title":"B","content":"","createdAt":"2024-01-01T00:00:00Z"}`
+		commentA = `{"type":"comment","id":"9b1d0c4e-5d7a-4f5e-8a39-7d1c0b4f8e03","postID":"9b1d0c4e-5d7a-4f5e-8a39-7d1c0b4f8e01","content":"x","createdAt":"2024-01-01T00:00:01Z"}`
+		replyB   = `{"type":"comment","id":"9b1d0c4e-5d7a-4f5e-8a39-7d1c0b4f8e04","postID":"9b1d0c4e-5d7a-4f5e-8a39-7d1c0b4f8e02","parentID":"9b1d0c4e-5d7a-4f5e-8a39-7d1c0b4f8e03","content":"y","createdAt":"2024-01-01T00:00:02Z"}`
+	)
+
+	tests := []struct {
+		name  string
+		lines []string
+		err   string
+	}{
+		{"comment before post", []string{commentA, postA}, "line 1: comment 9b1d0c4e-5d7a-4f5e-8a39-7d1c0b4f8e03: post"},
+		{"parent in another post", []string{postA, postB, commentA, replyB}, "line 4: comment 9b1d0c4e-5d7a-4f5e-8a39-7d1c0b4f8e04: parent"},
+		{"duplicate post", []string{postA, postA}, "line 2: post 9b1d0c4e-5d7a-4f5e-8a39-7d1c0b4f8e01: duplicate id"},
+		{"invalid id", []string{`{"type":"post","id":"1","createdAt":"2024-01-01T00:00:00Z"}`}, `line 1: invalid id "1"`},
+		{"unknown type", []string{`{"type":"user","id":"9b1d0c4e-5d7a-4f5e-8a39-7d1c0b4f8e05","createdAt":"2024-01-01T00:00:00Z"}`}, "unknown record type"},
+		{"unknown field", []string{`{"type":"post","id":"9b1d0c4e-5d7a-4f5e-8a39-7d1c0b4f8e05","likes":1}`}, "invalid record"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := transfer.Import(context.Background(), storage.NewMemoryStorage(),
+				strings.NewReader(strings.Join(tt.lines, "\n")), true)
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func TestImportDryRun(t *testing.T) {
+	ctx := context.Background()
+	src := storage.NewMemoryStorage()
+	src.CreatePost(ctx, "Title", "Content")
+	var buf bytes.Buffer
+	_, err := transfer.Export(ctx, src, &buf)
+	require.NoError(t, err)
+
+	dst := storage.NewMemoryStorage()
+	stats, err := transfer.Import(ctx, dst, &buf, true)
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Posts)
+
+	posts, _, err := dst.ListPosts(ctx, storage.ListPostsOptions{First: 10})
+	require.NoError(t, err)
+	assert.Empty(t, posts)
+}
+
+func TestImportConflict(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	store.CreatePost(ctx, "Title", "Content")
+	var buf bytes.Buffer
+	_, err := transfer.Export(ctx, store, &buf)
+	require.NoError(t, err)
+
+	_, err = transfer.Import(ctx, store, &buf, false)
+	assert.ErrorIs(t, err, storage.ErrConflict)
+}
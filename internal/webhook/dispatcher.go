@@ -0,0 +1,316 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"ozon-comments-graphql/internal/models"
+	"ozon-comments-graphql/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+const pruneInterval = time.Hour
+
+type Dispatcher struct {
+	store        storage.Storage
+	client       *http.Client
+	timeout      time.Duration
+	allowPrivate bool
+	interval     time.Duration
+	batchSize    int
+	maxAttempts  int
+	backoff      time.Duration
+	maxBackoff   time.Duration
+	retention    time.Duration
+	wake         chan struct{}
+}
+
+type Option func(*Dispatcher)
+
+// WithPollInterval sets how often due deliveries are looked for when nobody
+// calls Notify, which is how retries get picked up.
+func WithPollInterval(d time.Duration) Option {
+	return func(disp *Dispatcher) {
+		disp.interval = d
+	}
+}
+
+func WithBatchSize(n int) Option {
+	return func(disp *Dispatcher) {
+		disp.batchSize = n
+	}
+}
+
+// WithMaxAttempts sets how many times a delivery is tried before it is
+// marked DEAD.
+func WithMaxAttempts(n int) Option {
+	return func(disp *Dispatcher) {
+		disp.maxAttempts = n
+	}
+}
+
+// WithBackoff sets the delay before the first retry, doubled after every
+// further failure up to max.
+func WithBackoff(base, max time.Duration) Option {
+	return func(disp *Dispatcher) {
+		disp.backoff = base
+		disp.maxBackoff = max
+	}
+}
+
+// WithTimeout bounds a single delivery attempt.
+func WithTimeout(d time.Duration) Option {
+	return func(disp *Dispatcher) {
+		disp.timeout = d
+	}
+}
+
+// WithRetention sets how long delivered and dead deliveries stay in the log
+// before pruning.
+func WithRetention(d time.Duration) Option {
+	return func(disp *Dispatcher) {
+		disp.retention = d
+	}
+}
+
+// WithAllowPrivate permits deliveries to loopback and private addresses,
+// e.g. for receivers running next to the server in development.
+func WithAllowPrivate(allow bool) Option {
+	return func(disp *Dispatcher) {
+		disp.allowPrivate = allow
+	}
+}
+
+func NewDispatcher(store storage.Storage, opts ...Option) *Dispatcher {
+	d := &Dispatcher{
+		store:       store,
+		timeout:     10 * time.Second,
+		interval:    time.Second,
+		batchSize:   20,
+		maxAttempts: 8,
+		backoff:     time.Second,
+		maxBackoff:  time.Hour,
+		retention:   7 * 24 * time.Hour,
+		wake:        make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	d.client = newClient(d.timeout, d.allowPrivate)
+	return d
+}
+
+// HandleOutboxEvent queues a delivery of e to every webhook subscribed to its
+// topic. Deliveries are keyed by event ID, so a redelivered event is queued
+// once. It is an outbox.Handler.
+func (d *Dispatcher) HandleOutboxEvent(ctx context.Context, e *storage.OutboxEvent) error {
+	event, ok := topicEvents[e.Topic]
+	if !ok {
+		return nil
+	}
+
+	hooks, err := d.store.ListWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+
+	var body []byte
+	for _, hook := range hooks {
+		if !slices.Contains(hook.Events, event) {
+			continue
+		}
+		if body == nil {
+			body, err = json.Marshal(Envelope{ID: e.ID, Event: event, CreatedAt: e.CreatedAt, Data: e.Payload})
+			if err != nil {
+				slog.ErrorContext(ctx, "invalid outbox event dropped", "event_id", e.ID, "topic", e.Topic, "err", err)
+				return nil
+			}
+		}
+		err := d.store.AddWebhookDelivery(ctx, &models.WebhookDelivery{
+			ID:        uuid.NewString(),
+			WebhookID: hook.ID,
+			EventID:   e.ID,
+			Event:     event,
+			Payload:   body,
+			Status:    models.DeliveryPending,
+			CreatedAt: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if body != nil {
+		d.Notify()
+	}
+	return nil
+}
+
+// Notify wakes the dispatcher without waiting for the next poll.
+func (d *Dispatcher) Notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers due deliveries until ctx is done. Attempts interrupted by
+// shutdown are not counted and are retried once their lease expires.
+func (d *Dispatcher) Run(ctx context.Context) {
+	poll := time.NewTicker(d.interval)
+	defer poll.Stop()
+	prune := time.NewTicker(pruneInterval)
+	defer prune.Stop()
+
+	for {
+		d.drain(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+		case <-d.wake:
+		case <-prune.C:
+			n, err := d.store.PruneWebhookDeliveries(ctx, time.Now().Add(-d.retention))
+			if err != nil {
+				slog.WarnContext(ctx, "webhook delivery prune failed", "err", err)
+			} else if n > 0 {
+				slog.DebugContext(ctx, "webhook deliveries pruned", "deliveries", n)
+			}
+		}
+	}
+}
+
+func (d *Dispatcher) drain(ctx context.Context) {
+	for ctx.Err() == nil {
+		n, err := d.dispatchBatch(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.WarnContext(ctx, "webhook dispatch failed", "err", err)
+			}
+			return
+		}
+		if n < d.batchSize {
+			return
+		}
+	}
+}
+
+// dispatchBatch claims due deliveries and attempts them concurrently. The
+// claim lease outlasts an attempt, so a delivery is never sent twice at once.
+func (d *Dispatcher) dispatchBatch(ctx context.Context) (int, error) {
+	lease := 2 * d.client.Timeout
+	if lease <= 0 {
+		lease = time.Minute
+	}
+	deliveries, err := d.store.ClaimWebhookDeliveries(ctx, time.Now(), lease, d.batchSize)
+	if err != nil || len(deliveries) == 0 {
+		return 0, err
+	}
+	hooks, err := d.store.ListWebhooks(ctx)
+	if err != nil {
+		return 0, err
+	}
+	byID := make(map[string]*models.Webhook, len(hooks))
+	for _, hook := range hooks {
+		byID[hook.ID] = hook
+	}
+
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.attempt(ctx, byID[delivery.WebhookID], delivery)
+		}()
+	}
+	wg.Wait()
+	return len(deliveries), nil
+}
+
+func (d *Dispatcher) attempt(ctx context.Context, hook *models.Webhook, delivery *models.WebhookDelivery) {
+	var status int
+	var err error
+	if hook == nil {
+		err = fmt.Errorf("webhook %s not found", delivery.WebhookID)
+	} else {
+		status, err = d.send(ctx, hook, delivery)
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	now := time.Now()
+	delivery.Attempts++
+	if status != 0 {
+		delivery.ResponseStatus = &status
+	}
+	switch {
+	case err == nil:
+		delivery.Status = models.DeliveryDelivered
+		delivery.DeliveredAt = &now
+		delivery.NextAttemptAt = nil
+		delivery.LastError = nil
+	case delivery.Attempts >= d.maxAttempts || hook == nil:
+		msg := err.Error()
+		delivery.Status = models.DeliveryDead
+		delivery.NextAttemptAt = nil
+		delivery.LastError = &msg
+		slog.WarnContext(ctx, "webhook delivery dead", "delivery_id", delivery.ID, "webhook_id", delivery.WebhookID,
+			"attempts", delivery.Attempts, "err", err)
+	default:
+		msg := err.Error()
+		next := now.Add(d.retryDelay(delivery.Attempts))
+		delivery.NextAttemptAt = &next
+		delivery.LastError = &msg
+		slog.DebugContext(ctx, "webhook delivery failed", "delivery_id", delivery.ID, "webhook_id", delivery.WebhookID,
+			"attempts", delivery.Attempts, "retry_at", next, "err", err)
+	}
+
+	if err := d.store.UpdateWebhookDelivery(ctx, delivery); err != nil {
+		slog.WarnContext(ctx, "webhook delivery not saved", "delivery_id", delivery.ID, "err", err)
+	}
+}
+
+// retryDelay returns the backoff after the given number of failed attempts.
+func (d *Dispatcher) retryDelay(attempts int) time.Duration {
+	delay := d.backoff
+	for i := 1; i < attempts && delay < d.maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, d.maxBackoff)
+}
+
+// send POSTs the delivery and returns the response status, or 0 if there was
+// no response. Any status outside 2xx is an error. The response body is never
+// kept: it may come from a service the caller could not reach otherwise.
+func (d *Dispatcher) send(ctx context.Context, hook *models.Webhook, delivery *models.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderID, delivery.EventID)
+	req.Header.Set(HeaderSignature, Sign(hook.Secret, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
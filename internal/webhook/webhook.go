@@ -0,0 +1,112 @@
+// Package webhook delivers outbox events to registered HTTP endpoints.
+//
+// Every delivery is a POST of a JSON envelope signed with the webhook's
+// secret. Failed attempts are retried with exponential backoff; a delivery
+// that runs out of attempts is marked DEAD and stays in the delivery log,
+// which doubles as the dead-letter list.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"time"
+
+	"ozon-comments-graphql/internal/models"
+	"ozon-comments-graphql/internal/outbox"
+
+	"github.com/google/uuid"
+)
+
+// Events a webhook can subscribe to.
+const (
+	EventCommentCreated = "COMMENT_CREATED"
+	EventCommentDeleted = "COMMENT_DELETED"
+	EventPostToggled    = "POST_TOGGLED"
+	EventPostUpdated    = "POST_UPDATED"
+	EventPostDeleted    = "POST_DELETED"
+)
+
+// Request headers sent with every delivery.
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderID        = "X-Webhook-ID"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// topicEvents maps outbox topics to the webhook events they produce.
+var topicEvents = map[string]string{
+	outbox.TopicCommentCreated: EventCommentCreated,
+	outbox.TopicCommentDeleted: EventCommentDeleted,
+	outbox.TopicPostToggled:    EventPostToggled,
+	outbox.TopicPostUpdated:    EventPostUpdated,
+	outbox.TopicPostDeleted:    EventPostDeleted,
+}
+
+var ErrInvalid = errors.New("invalid webhook")
+
+// New validates a registration and returns the webhook to store.
+func New(rawURL string, events []string, secret string) (*models.Webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalid)
+	}
+	if len(events) == 0 {
+		return nil, fmt.Errorf("%w: at least one event is required", ErrInvalid)
+	}
+	for _, e := range events {
+		if !knownEvent(e) {
+			return nil, fmt.Errorf("%w: unknown event %q", ErrInvalid, e)
+		}
+	}
+	if secret == "" {
+		return nil, fmt.Errorf("%w: secret is required", ErrInvalid)
+	}
+
+	events = slices.Clone(events)
+	slices.Sort(events)
+	return &models.Webhook{
+		ID:        uuid.NewString(),
+		URL:       u.String(),
+		Events:    slices.Compact(events),
+		Secret:    secret,
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+func knownEvent(event string) bool {
+	for _, e := range topicEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Sign returns the X-Webhook-Signature value for body: "sha256=" followed by
+// the hex HMAC-SHA256 of the body keyed with the webhook's secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is a valid Sign result for body. Receivers
+// written in Go can use it as is.
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// Envelope is the body of every delivery. Data is the comment or post the
+// event is about, in the shape the GraphQL API returns it.
+type Envelope struct {
+	ID        string          `json:"id"`
+	Event     string          `json:"event"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Data      json.RawMessage `json:"data"`
+}
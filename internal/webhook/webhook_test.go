@@ -0,0 +1,247 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"ozon-comments-graphql/internal/models"
+	"ozon-comments-graphql/internal/outbox"
+	"ozon-comments-graphql/internal/storage"
+	"ozon-comments-graphql/internal/webhook"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const secret = "s3cret"
+
+// receiver is a webhook endpoint that fails the first failures requests.
+type receiver struct {
+	mu       sync.Mutex
+	failures int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, body)
+	if rc.failures > 0 {
+		rc.failures--
+		http.Error(w, "try later", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (rc *receiver) count() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return len(rc.requests)
+}
+
+func setup(t *testing.T, rc *receiver, opts ...webhook.Option) (storage.Storage, *webhook.Dispatcher, *models.Webhook) {
+	t.Helper()
+	srv := httptest.NewServer(rc)
+	t.Cleanup(srv.Close)
+
+	store := storage.NewMemoryStorage()
+	hook, err := webhook.New(srv.URL+"/hook", []string{webhook.EventCommentCreated}, secret)
+	require.NoError(t, err)
+	require.NoError(t, store.CreateWebhook(context.Background(), hook))
+
+	opts = append([]webhook.Option{
+		webhook.WithPollInterval(5 * time.Millisecond),
+		webhook.WithAllowPrivate(true),
+	}, opts...)
+	disp := webhook.NewDispatcher(store, opts...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		disp.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return store, disp, hook
+}
+
+func commentEvent(id string) *storage.OutboxEvent {
+	return &storage.OutboxEvent{
+		ID:        id,
+		Topic:     outbox.TopicCommentCreated,
+		Payload:   []byte(`{"id":"c1","content":"hi"}`),
+		CreatedAt: time.Now(),
+	}
+}
+
+func deliveries(t *testing.T, store storage.Storage, hook *models.Webhook) []*models.WebhookDelivery {
+	t.Helper()
+	ds, err := store.ListWebhookDeliveries(context.Background(), storage.WebhookDeliveryFilter{WebhookID: &hook.ID}, 100)
+	require.NoError(t, err)
+	return ds
+}
+
+func TestNew(t *testing.T) {
+	hook, err := webhook.New("https://example.com/hook",
+		[]string{webhook.EventPostToggled, webhook.EventCommentCreated, webhook.EventPostToggled}, secret)
+	require.NoError(t, err)
+	assert.Equal(t, []string{webhook.EventCommentCreated, webhook.EventPostToggled}, hook.Events)
+	assert.NotEmpty(t, hook.ID)
+
+	for name, args := range map[string]struct {
+		url    string
+		events []string
+		secret string
+	}{
+		"relative url":  {"/hook", []string{webhook.EventCommentCreated}, secret},
+		"ftp url":       {"ftp://example.com", []string{webhook.EventCommentCreated}, secret},
+		"no events":     {"https://example.com", nil, secret},
+		"unknown event": {"https://example.com", []string{"COMMENT_EDITED"}, secret},
+		"no secret":     {"https://example.com", []string{webhook.EventCommentCreated}, ""},
+	} {
+		_, err := webhook.New(args.url, args.events, args.secret)
+		assert.ErrorIs(t, err, webhook.ErrInvalid, name)
+	}
+}
+
+func TestDispatcherSignsDeliveries(t *testing.T) {
+	rc := &receiver{}
+	store, disp, hook := setup(t, rc)
+
+	require.NoError(t, disp.HandleOutboxEvent(context.Background(), commentEvent("e1")))
+	// Redelivered and unsubscribed events queue nothing.
+	require.NoError(t, disp.HandleOutboxEvent(context.Background(), commentEvent("e1")))
+	require.NoError(t, disp.HandleOutboxEvent(context.Background(), &storage.OutboxEvent{
+		ID: "e2", Topic: outbox.TopicPostToggled, Payload: []byte(`{}`), CreatedAt: time.Now(),
+	}))
+
+	assert.Eventually(t, func() bool {
+		ds := deliveries(t, store, hook)
+		return len(ds) == 1 && ds[0].Status == models.DeliveryDelivered
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, 1, rc.count())
+
+	rc.mu.Lock()
+	req, body := rc.requests[0], rc.bodies[0]
+	rc.mu.Unlock()
+	assert.Equal(t, "/hook", req.URL.Path)
+	assert.Equal(t, webhook.EventCommentCreated, req.Header.Get(webhook.HeaderEvent))
+	assert.Equal(t, "e1", req.Header.Get(webhook.HeaderID))
+	assert.True(t, webhook.Verify(secret, body, req.Header.Get(webhook.HeaderSignature)))
+	assert.False(t, webhook.Verify("other", body, req.Header.Get(webhook.HeaderSignature)))
+
+	var env webhook.Envelope
+	require.NoError(t, json.Unmarshal(body, &env))
+	assert.Equal(t, "e1", env.ID)
+	assert.Equal(t, webhook.EventCommentCreated, env.Event)
+	assert.JSONEq(t, `{"id":"c1","content":"hi"}`, string(env.Data))
+
+	d := deliveries(t, store, hook)[0]
+	assert.Equal(t, 1, d.Attempts)
+	require.NotNil(t, d.ResponseStatus)
+	assert.Equal(t, http.StatusNoContent, *d.ResponseStatus)
+	assert.NotNil(t, d.DeliveredAt)
+}
+
+func TestDispatcherRetries(t *testing.T) {
+	rc := &receiver{failures: 2}
+	store, disp, hook := setup(t, rc,
+		webhook.WithMaxAttempts(5),
+		webhook.WithBackoff(10*time.Millisecond, 20*time.Millisecond),
+	)
+
+	require.NoError(t, disp.HandleOutboxEvent(context.Background(), commentEvent("e1")))
+
+	assert.Eventually(t, func() bool {
+		ds := deliveries(t, store, hook)
+		return len(ds) == 1 && ds[0].Status == models.DeliveryDelivered
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, 3, rc.count())
+	d := deliveries(t, store, hook)[0]
+	assert.Equal(t, 3, d.Attempts)
+	assert.Nil(t, d.LastError)
+
+	// Every attempt carries the same body, so the signature stays valid.
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	assert.Equal(t, rc.bodies[0], rc.bodies[2])
+}
+
+func TestDispatcherDeadLetters(t *testing.T) {
+	rc := &receiver{failures: 100}
+	store, disp, hook := setup(t, rc,
+		webhook.WithMaxAttempts(3),
+		webhook.WithBackoff(time.Millisecond, 5*time.Millisecond),
+	)
+
+	require.NoError(t, disp.HandleOutboxEvent(context.Background(), commentEvent("e1")))
+
+	dead := models.DeliveryDead
+	assert.Eventually(t, func() bool {
+		ds, err := store.ListWebhookDeliveries(context.Background(), storage.WebhookDeliveryFilter{Status: &dead}, 10)
+		return err == nil && len(ds) == 1
+	}, time.Second, 5*time.Millisecond)
+
+	d := deliveries(t, store, hook)[0]
+	assert.Equal(t, 3, d.Attempts)
+	assert.Nil(t, d.NextAttemptAt)
+	require.NotNil(t, d.ResponseStatus)
+	assert.Equal(t, http.StatusServiceUnavailable, *d.ResponseStatus)
+	require.NotNil(t, d.LastError)
+	assert.Contains(t, *d.LastError, "503")
+	assert.NotContains(t, *d.LastError, "try later", "response bodies are not recorded")
+
+	// Dead deliveries are not retried.
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, 3, rc.count())
+}
+
+func TestCheckURL(t *testing.T) {
+	ctx := context.Background()
+	assert.NoError(t, webhook.CheckURL(ctx, "https://93.184.215.14/hook"))
+
+	for _, u := range []string{
+		"http://127.0.0.1/hook",
+		"http://localhost:8080/hook",
+		"http://10.1.2.3/hook",
+		"http://192.168.0.1/hook",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://[::1]/hook",
+		"http://[::ffff:10.0.0.1]/hook",
+		"http://[fd00::1]/hook",
+		"http://0.0.0.0/hook",
+		"http://100.64.0.1/hook",
+	} {
+		assert.ErrorIs(t, webhook.CheckURL(ctx, u), webhook.ErrPrivateAddress, u)
+	}
+}
+
+func TestDispatcherRefusesPrivateAddresses(t *testing.T) {
+	rc := &receiver{}
+	store, disp, hook := setup(t, rc, webhook.WithAllowPrivate(false), webhook.WithMaxAttempts(1))
+
+	require.NoError(t, disp.HandleOutboxEvent(context.Background(), commentEvent("e1")))
+
+	assert.Eventually(t, func() bool {
+		ds := deliveries(t, store, hook)
+		return len(ds) == 1 && ds[0].Status == models.DeliveryDead
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, 0, rc.count())
+	d := deliveries(t, store, hook)[0]
+	require.NotNil(t, d.LastError)
+	assert.Contains(t, *d.LastError, webhook.ErrPrivateAddress.Error())
+}
@@ -2,75 +2,337 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/99designs/gqlgen/graphql"
 	"github.com/99designs/gqlgen/graphql/handler"
 	"github.com/99designs/gqlgen/graphql/handler/extension"
 	"github.com/99designs/gqlgen/graphql/handler/lru"
 	"github.com/99designs/gqlgen/graphql/handler/transport"
 	"github.com/99designs/gqlgen/graphql/playground"
 	"github.com/gorilla/websocket"
-	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/vektah/gqlparser/v2/ast"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"ozon-comments-graphql/graph"
+	"ozon-comments-graphql/internal/admin"
+	"ozon-comments-graphql/internal/blob"
+	"ozon-comments-graphql/internal/cache"
+	"ozon-comments-graphql/internal/config"
+	"ozon-comments-graphql/internal/cors"
+	"ozon-comments-graphql/internal/health"
+	"ozon-comments-graphql/internal/limits"
+	"ozon-comments-graphql/internal/logging"
+	"ozon-comments-graphql/internal/metrics"
+	"ozon-comments-graphql/internal/outbox"
+	"ozon-comments-graphql/internal/persisted"
 	"ozon-comments-graphql/internal/storage"
+	"ozon-comments-graphql/internal/tracing"
+	"ozon-comments-graphql/internal/transfer"
+	"ozon-comments-graphql/internal/webhook"
+	"syscall"
 	"time"
 )
 
-const defaultPort = "8080"
+// typingBurst is how many startTyping calls a client may make at once, e.g.
+// when switching threads, before broker-typing-interval applies.
+const typingBurst = 5
 
 func main() {
-	if err := godotenv.Load(); err != nil {
-		log.Printf("Warning: .env file not loaded: %v", err)
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			if err := cmd(os.Args[2:]); err != nil && !errors.Is(err, flag.ErrHelp) {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	cfg, err := config.Load(os.Args[0], os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	logger, err := logging.New(os.Stderr, cfg.Log.Level, cfg.Log.Format)
+	if err != nil {
+		fatal("logger init failed", err)
+	}
+	slog.SetDefault(logger)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		ServiceName: "ozon-comments-graphql",
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.OTLPEndpoint,
+		File:        cfg.Tracing.File,
+		SampleRatio: cfg.Tracing.SampleRatio,
+	})
+	if err != nil {
+		fatal("tracing init failed", err)
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	m := metrics.New(reg)
+
+	store, err := openStorage(context.Background(), cfg, storage.WithQueryTracer(tracing.QueryTracer{}))
+	if err != nil {
+		fatal("storage init failed", err)
+	}
+	if pgStore, ok := store.(*storage.PostgresStorage); ok {
+		metrics.RegisterPool(reg, pgStore.Stat)
+	}
+	store = m.Storage(tracing.Storage(store))
+	if cfg.Cache.Enabled {
+		store = cache.Storage(store, cfg.Cache.Size, cfg.Cache.TTL)
 	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = defaultPort
+	broker := graph.NewBroker(
+		graph.WithBufferSize(cfg.Broker.BufferSize),
+		graph.WithCommentStore(store),
+		graph.WithActivityInterval(cfg.Broker.ActivityInterval),
+		graph.WithTypingTTL(cfg.Broker.TypingTTL),
+	)
+	metrics.RegisterBroker(reg, broker)
+	dispatcher := webhook.NewDispatcher(store,
+		webhook.WithMaxAttempts(cfg.Webhooks.MaxAttempts),
+		webhook.WithBackoff(cfg.Webhooks.Backoff, cfg.Webhooks.BackoffMax),
+		webhook.WithTimeout(cfg.Webhooks.Timeout),
+		webhook.WithRetention(cfg.Webhooks.Retention),
+		webhook.WithAllowPrivate(cfg.Webhooks.AllowPrivate),
+	)
+	relay := outbox.NewRelay(store, outbox.Fanout(broker.HandleOutboxEvent, dispatcher.HandleOutboxEvent),
+		outbox.WithPollInterval(cfg.Outbox.PollInterval),
+		outbox.WithBatchSize(cfg.Outbox.BatchSize),
+		outbox.WithRetention(cfg.Outbox.Retention),
+	)
+	blobs, err := blob.NewFS(cfg.Attachments.Dir, "/attachments/")
+	if err != nil {
+		fatal("attachment store init failed", err)
+	}
+	resolver := &graph.Resolver{
+		Store:               store,
+		Broker:              broker,
+		Outbox:              relay,
+		WebhookAllowPrivate: cfg.Webhooks.AllowPrivate,
+		Blobs:               blobs,
+		MaxAttachments:      cfg.Attachments.MaxPerComment,
+		MaxAttachmentSize:   cfg.Attachments.MaxSize,
+		DefaultPageSize:     cfg.Comments.DefaultPageSize,
+		MaxPageSize:         cfg.Comments.MaxPageSize,
+		TypingThrottle:      limits.NewThrottle(cfg.Broker.TypingInterval, typingBurst),
 	}
 
-	var store storage.Storage
-	if os.Getenv("STORAGE_TYPE") == "postgres" {
-		pgStore, err := storage.NewPostgresStorage(context.Background(), os.Getenv("DATABASE_URL"))
+	origins := cors.New(cfg.CORS.AllowedOrigins)
+	var manifest *persisted.Manifest
+	if cfg.GraphQL.PersistedQueries == config.PersistedQueriesAllowList {
+		manifest, err = persisted.LoadManifest(cfg.GraphQL.Manifest)
 		if err != nil {
-			log.Fatal("Postgres init failed:", err)
+			fatal("persisted query manifest load failed", err)
 		}
-		store = pgStore
-	} else {
-		store = storage.NewMemoryStorage()
+		slog.Info("persisted query allow-list loaded", "operations", len(manifest.Operations))
+	}
+	srv, err := newGraphQLServer(cfg, resolver, origins, manifest)
+	if err != nil {
+		fatal("graphql server init failed", err)
 	}
+	srv.Use(m.GraphQL(manifest.OperationNames()...))
+	srv.Use(tracing.GraphQL())
+	srv.Use(logging.GraphQL(logger))
+	srv.Use(cache.GraphQL())
+	srv.Use(graph.Loaders(store))
 
-	broker := graph.NewCommentBroker()
-	resolver := &graph.Resolver{
-		Store:  store,
-		Broker: broker,
+	mux := http.NewServeMux()
+	mux.Handle("/", playground.Handler("GraphQL playground", "/query"))
+	mux.Handle("/query", limits.Middleware(origins.Middleware(admin.Middleware(cfg.Server.AdminToken, cache.Middleware(srv)))))
+	mux.Handle("/attachments/", http.StripPrefix("/attachments", blob.Handler(blobs)))
+	mux.Handle("/admin/data", admin.Middleware(cfg.Server.AdminToken, transfer.Handler(store)))
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	mux.Handle("/healthz", health.Liveness())
+	mux.Handle("/readyz", health.Readiness(
+		health.Check{Name: "storage", Check: store.Health},
+		health.Check{Name: "broker", Check: broker.Health},
+	))
+
+	// Websocket connections are hijacked and outlive http.Server.Shutdown, so
+	// they are bound to baseCtx and closed explicitly once draining is over.
+	baseCtx, closeConns := context.WithCancel(context.Background())
+	httpSrv := newHTTPServer(baseCtx, ":"+cfg.Server.Port, tracing.Middleware(logging.Middleware(logger, mux)), broker)
+	httpSrv.ErrorLog = slog.NewLogLogger(logger.Handler(), slog.LevelWarn)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	relayDone := make(chan struct{})
+	go func() {
+		defer close(relayDone)
+		relay.Run(relayCtx)
+	}()
+	dispatcherDone := make(chan struct{})
+	go func() {
+		defer close(dispatcherDone)
+		dispatcher.Run(relayCtx)
+	}()
+	if cfg.GraphQL.SharedAPQ && cfg.GraphQL.PersistedQueries == config.PersistedQueriesAPQ {
+		go persisted.Prune(relayCtx, store, cfg.GraphQL.SharedAPQTTL)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		slog.Info("server started", "addr", httpSrv.Addr, "storage", cfg.Storage.Type,
+			"playground", "http://localhost:"+cfg.Server.Port+"/")
+		errCh <- httpSrv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		fatal("http server failed", err)
+	case <-ctx.Done():
+	}
+
+	timeout, drain := cfg.Server.ShutdownTimeout, cfg.Server.ShutdownDrain
+	slog.Info("shutting down", "timeout", timeout, "drain", drain)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Stop accepting connections, complete subscriptions and let in-flight
+	// queries and mutations finish.
+	if err := httpSrv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("http shutdown failed", "err", err)
+	}
+
+	// Events the relay has not published yet stay in the outbox, and
+	// webhook deliveries interrupted mid-attempt are retried, on the next
+	// start.
+	stopRelay()
+	<-relayDone
+	<-dispatcherDone
+
+	// Give websocket clients the drain period to receive the completed
+	// subscriptions, then send close frames.
+	select {
+	case <-time.After(drain):
+	case <-shutdownCtx.Done():
+	}
+	closeConns()
+
+	store.Close()
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("tracing shutdown failed", "err", err)
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("http server failed", "err", err)
+	}
+	slog.Info("shutdown complete")
+}
+
+// newHTTPServer serves handler on connections bound to baseCtx. Server-Sent
+// Events subscriptions are in-flight requests that Shutdown would wait for
+// until its timeout, so the broker completes every subscription as soon as
+// shutdown begins; clients get a complete event and resubscribe elsewhere.
+func newHTTPServer(baseCtx context.Context, addr string, handler http.Handler, broker *graph.Broker) *http.Server {
+	srv := &http.Server{
+		Addr:        addr,
+		Handler:     handler,
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
+	}
+	srv.RegisterOnShutdown(broker.Close)
+	return srv
+}
+
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
+}
+
+// openStorage opens the backend selected by cfg.Storage.Type.
+func openStorage(ctx context.Context, cfg *config.Config, opts ...storage.Option) (storage.Storage, error) {
+	opts = append([]storage.Option{
+		storage.WithMaxCommentLength(cfg.Comments.MaxLength),
+		storage.WithPoolSize(cfg.Storage.PoolMinConns, cfg.Storage.PoolMaxConns),
+	}, opts...)
+
+	if cfg.Storage.Type == config.StoragePostgres {
+		pgStore, err := storage.NewPostgresStorage(ctx, cfg.Storage.DatabaseURL, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return pgStore, nil
 	}
+	return storage.NewMemoryStorage(opts...), nil
+}
 
+// newGraphQLServer wires the schema to every supported transport:
+// websockets (graphql-transport-ws and the legacy graphql-ws protocol),
+// Server-Sent Events for clients whose proxies break websockets, and plain
+// GET/POST. manifest is the persisted query allow-list, used in allow-list
+// mode only.
+func newGraphQLServer(cfg *config.Config, resolver *graph.Resolver, origins *cors.Policy, manifest *persisted.Manifest) (*handler.Server, error) {
 	srv := handler.New(graph.NewExecutableSchema(graph.Config{Resolvers: resolver}))
 
+	subs := limits.New(limits.Config{
+		MaxSubscriptions: cfg.Subscriptions.Max,
+		MaxPerConnection: cfg.Subscriptions.MaxPerConnection,
+		MaxPerPost:       cfg.Subscriptions.MaxPerPost,
+		MaxLifetime:      cfg.Subscriptions.MaxLifetime,
+		IdleTimeout:      cfg.Websocket.IdleTimeout,
+	})
 	srv.AddTransport(transport.Websocket{
 		Upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool {
-				return true
-			},
+			CheckOrigin: origins.CheckOrigin,
 		},
-		KeepAlivePingInterval: 10 * time.Second,
+		KeepAlivePingInterval: cfg.Websocket.KeepAlive,
+		PongOnlyInterval:      cfg.Websocket.KeepAlive,
+		// A client that never sends connection_init is as idle as one
+		// without subscriptions.
+		InitTimeout: cfg.Websocket.IdleTimeout,
+		InitFunc:    subs.InitFunc,
+		CloseFunc:   subs.CloseFunc,
 	})
-	srv.AddTransport(transport.Options{})
+	srv.AddTransport(transport.Options{AllowedMethods: cors.AllowedMethods()})
 	srv.AddTransport(transport.GET{})
+	// SSE must come before POST: both accept JSON POSTs and SSE only differs
+	// by the text/event-stream Accept header.
+	srv.AddTransport(transport.SSE{KeepAlivePingInterval: cfg.SSE.KeepAlive})
+	srv.AddTransport(transport.MultipartForm{
+		// Room for every file of a comment plus the operations and map
+		// fields; each file is checked against its own limit later.
+		MaxUploadSize: cfg.Attachments.MaxSize*int64(cfg.Attachments.MaxPerComment) + 1<<20,
+		MaxMemory:     cfg.Attachments.MaxSize,
+	})
 	srv.AddTransport(transport.POST{})
 
-	srv.SetQueryCache(lru.New[*ast.QueryDocument](1000))
+	srv.SetQueryCache(lru.New[*ast.QueryDocument](cfg.GraphQL.QueryCacheSize))
 
 	srv.Use(extension.Introspection{})
-	srv.Use(extension.AutomaticPersistedQuery{
-		Cache: lru.New[string](100),
-	})
+	srv.Use(subs)
 
-	http.Handle("/", playground.Handler("GraphQL playground", "/query"))
-	http.Handle("/query", srv)
+	switch cfg.GraphQL.PersistedQueries {
+	case config.PersistedQueriesAllowList:
+		if manifest == nil {
+			return nil, errors.New("allow-list mode needs a manifest")
+		}
+		srv.Use(persisted.AllowList{Manifest: manifest})
+	default:
+		var cache graphql.Cache[string] = lru.New[string](cfg.GraphQL.APQCacheSize)
+		if cfg.GraphQL.SharedAPQ {
+			cache = persisted.SharedCache{Local: cache, Store: resolver.Store}
+		}
+		srv.Use(extension.AutomaticPersistedQuery{Cache: cache})
+	}
 
-	log.Printf("connect to http://localhost:%s/ for GraphQL playground", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	return srv, nil
 }
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ozon-comments-graphql/graph"
+	"ozon-comments-graphql/internal/blob"
+	"ozon-comments-graphql/internal/config"
+	"ozon-comments-graphql/internal/cors"
+	"ozon-comments-graphql/internal/storage"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const commentAddedSubscription = `subscription($postID: ID!) { commentAdded(postID: $postID) { id content } }`
+
+type testServer struct {
+	*httptest.Server
+	broker *graph.Broker
+	postID string
+}
+
+func newTestServer(t *testing.T, opts ...func(*config.Config)) *testServer {
+	t.Helper()
+
+	store := storage.NewMemoryStorage()
+	broker := graph.NewBroker()
+	resolver := &graph.Resolver{Store: store, Broker: broker}
+
+	cfg := config.Default()
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	srv, err := newGraphQLServer(cfg, resolver, cors.New(nil), nil)
+	require.NoError(t, err)
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+
+	post := store.CreatePost(context.Background(), "Title", "Content")
+	return &testServer{Server: ts, broker: broker, postID: post.ID}
+}
+
+// addComment waits for the subscription to register with the broker, then
+// creates a comment through a regular POST mutation. It only uses assert so
+// it may run on a separate goroutine.
+func (s *testServer) addComment(t *testing.T, content string) {
+	t.Helper()
+
+	subscribed := assert.Eventually(t, func() bool {
+		return s.broker.SubscriberCounts()[s.postID] > 0
+	}, time.Second, 5*time.Millisecond)
+	if !subscribed {
+		return
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"query":     `mutation($postID: ID!, $content: String!) { createComment(postID: $postID, content: $content) { id } }`,
+		"variables": map[string]interface{}{"postID": s.postID, "content": content},
+	})
+	resp, err := http.Post(s.URL, "application/json", bytes.NewReader(body))
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+type wsMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+func dialWS(t *testing.T, url, subprotocol string) *websocket.Conn {
+	t.Helper()
+
+	d := websocket.Dialer{Subprotocols: []string{subprotocol}}
+	conn, _, err := d.Dial("ws"+strings.TrimPrefix(url, "http"), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	require.Equal(t, subprotocol, conn.Subprotocol())
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	return conn
+}
+
+// readUntil skips keep-alive traffic and returns the first message of type want.
+func readUntil(t *testing.T, conn *websocket.Conn, want string) wsMessage {
+	t.Helper()
+	for {
+		var msg wsMessage
+		require.NoError(t, conn.ReadJSON(&msg))
+		if msg.Type == want {
+			return msg
+		}
+	}
+}
+
+func subscribePayload(postID string) json.RawMessage {
+	p, _ := json.Marshal(map[string]interface{}{
+		"query":     commentAddedSubscription,
+		"variables": map[string]interface{}{"postID": postID},
+	})
+	return p
+}
+
+func TestSubscriptionGraphQLTransportWS(t *testing.T) {
+	s := newTestServer(t)
+	conn := dialWS(t, s.URL, "graphql-transport-ws")
+
+	require.NoError(t, conn.WriteJSON(wsMessage{Type: "connection_init"}))
+	readUntil(t, conn, "connection_ack")
+	require.NoError(t, conn.WriteJSON(wsMessage{ID: "1", Type: "subscribe", Payload: subscribePayload(s.postID)}))
+
+	s.addComment(t, "over graphql-transport-ws")
+
+	msg := readUntil(t, conn, "next")
+	assert.Equal(t, "1", msg.ID)
+	assert.Contains(t, string(msg.Payload), "over graphql-transport-ws")
+
+	require.NoError(t, conn.WriteJSON(wsMessage{ID: "1", Type: "complete"}))
+	assert.Eventually(t, func() bool {
+		return s.broker.SubscriberCounts()[s.postID] == 0
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestSubscriptionLegacyGraphQLWS(t *testing.T) {
+	s := newTestServer(t)
+	conn := dialWS(t, s.URL, "graphql-ws")
+
+	require.NoError(t, conn.WriteJSON(wsMessage{Type: "connection_init"}))
+	readUntil(t, conn, "connection_ack")
+	require.NoError(t, conn.WriteJSON(wsMessage{ID: "1", Type: "start", Payload: subscribePayload(s.postID)}))
+
+	s.addComment(t, "over graphql-ws")
+
+	msg := readUntil(t, conn, "data")
+	assert.Equal(t, "1", msg.ID)
+	assert.Contains(t, string(msg.Payload), "over graphql-ws")
+}
+
+func TestSubscriptionSSE(t *testing.T) {
+	s := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(subscribePayload(s.postID)))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	go s.addComment(t, "over sse")
+
+	sc := bufio.NewScanner(resp.Body)
+	var event string
+	for sc.Scan() {
+		line := sc.Text()
+		if name, ok := strings.CutPrefix(line, "event: "); ok {
+			event = name
+			continue
+		}
+		if data, ok := strings.CutPrefix(line, "data: "); ok && event == "next" {
+			assert.Contains(t, data, "over sse")
+			return
+		}
+	}
+	t.Fatalf("stream ended without a next event: %v", sc.Err())
+}
+
+func TestShutdownCompletesSSE(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	broker := graph.NewBroker()
+	resolver := &graph.Resolver{Store: store, Broker: broker}
+	gqlSrv, err := newGraphQLServer(config.Default(), resolver, cors.New(nil), nil)
+	require.NoError(t, err)
+	post := store.CreatePost(context.Background(), "Title", "Content")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	httpSrv := newHTTPServer(context.Background(), ln.Addr().String(), gqlSrv, broker)
+	go func() { _ = httpSrv.Serve(ln) }()
+
+	req, err := http.NewRequest(http.MethodPost, "http://"+ln.Addr().String(), bytes.NewReader(subscribePayload(post.ID)))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Eventually(t, func() bool {
+		return broker.SubscriberCounts()[post.ID] > 0
+	}, time.Second, 5*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	require.NoError(t, httpSrv.Shutdown(ctx))
+	assert.Less(t, time.Since(start), 2*time.Second, "shutdown waited for the SSE stream")
+
+	body, _ := io.ReadAll(resp.Body)
+	assert.Contains(t, string(body), "event: complete")
+}
+
+func TestSubscriptionLimits(t *testing.T) {
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.Subscriptions.MaxPerConnection = 2
+		cfg.Subscriptions.MaxPerPost = 1
+		cfg.Websocket.IdleTimeout = 100 * time.Millisecond
+	})
+	conn := dialWS(t, s.URL, "graphql-transport-ws")
+	require.NoError(t, conn.WriteJSON(wsMessage{Type: "connection_init"}))
+	readUntil(t, conn, "connection_ack")
+
+	require.NoError(t, conn.WriteJSON(wsMessage{ID: "1", Type: "subscribe", Payload: subscribePayload(s.postID)}))
+	assert.Eventually(t, func() bool {
+		return s.broker.SubscriberCounts()[s.postID] == 1
+	}, time.Second, 5*time.Millisecond)
+
+	// The post is full; the error names the limit.
+	require.NoError(t, conn.WriteJSON(wsMessage{ID: "2", Type: "subscribe", Payload: subscribePayload(s.postID)}))
+	msg := readUntil(t, conn, "next")
+	assert.Equal(t, "2", msg.ID)
+	assert.Contains(t, string(msg.Payload), "too many subscribers")
+	assert.Contains(t, string(msg.Payload), "SUBSCRIPTION_LIMIT_EXCEEDED")
+
+	// The two subscriptions are set up concurrently, so either may be the
+	// one over the connection limit.
+	postAdded, _ := json.Marshal(map[string]interface{}{"query": `subscription { postAdded { id } }`})
+	require.NoError(t, conn.WriteJSON(wsMessage{ID: "3", Type: "subscribe", Payload: postAdded}))
+	require.NoError(t, conn.WriteJSON(wsMessage{ID: "4", Type: "subscribe", Payload: postAdded}))
+	msg = readUntil(t, conn, "next")
+	assert.Contains(t, []string{"3", "4"}, msg.ID)
+	assert.Contains(t, string(msg.Payload), "too many subscriptions on this connection")
+
+	// Without subscriptions the connection is closed after the idle timeout.
+	for _, id := range []string{"1", "3", "4"} {
+		require.NoError(t, conn.WriteJSON(wsMessage{ID: id, Type: "complete"}))
+	}
+	start := time.Now()
+	for {
+		var m wsMessage
+		if err := conn.ReadJSON(&m); err != nil {
+			assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure), err)
+			break
+		}
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestMultipartUpload(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	blobs, err := blob.NewFS(t.TempDir(), "/attachments/")
+	require.NoError(t, err)
+	resolver := &graph.Resolver{Store: store, Broker: graph.NewBroker(), Blobs: blobs}
+	srv, err := newGraphQLServer(config.Default(), resolver, cors.New(nil), nil)
+	require.NoError(t, err)
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	post := store.CreatePost(context.Background(), "Title", "Content")
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	operations, _ := json.Marshal(map[string]interface{}{
+		"query": `mutation($postID: ID!, $files: [Upload!]) {
+			createComment(postID: $postID, content: "with file", attachments: $files) {
+				attachments { filename contentType size url }
+			}
+		}`,
+		"variables": map[string]interface{}{"postID": post.ID, "files": []interface{}{nil}},
+	})
+	require.NoError(t, form.WriteField("operations", string(operations)))
+	require.NoError(t, form.WriteField("map", `{"0": ["variables.files.0"]}`))
+	part, err := form.CreateFormFile("0", "notes.txt")
+	require.NoError(t, err)
+	_, _ = part.Write([]byte("hello"))
+	require.NoError(t, form.Close())
+
+	resp, err := http.Post(ts.URL, form.FormDataContentType(), &body)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var res struct {
+		Data struct {
+			CreateComment struct {
+				Attachments []struct {
+					Filename    string `json:"filename"`
+					ContentType string `json:"contentType"`
+					Size        int    `json:"size"`
+					URL         string `json:"url"`
+				} `json:"attachments"`
+			} `json:"createComment"`
+		} `json:"data"`
+		Errors []interface{} `json:"errors"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+	require.Empty(t, res.Errors)
+	require.Len(t, res.Data.CreateComment.Attachments, 1)
+	a := res.Data.CreateComment.Attachments[0]
+	assert.Equal(t, "notes.txt", a.Filename)
+	assert.Equal(t, "text/plain", a.ContentType)
+	assert.Equal(t, 5, a.Size)
+
+	rec := httptest.NewRecorder()
+	http.StripPrefix("/attachments", blob.Handler(blobs)).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, a.URL, nil))
+	assert.Equal(t, "hello", rec.Body.String())
+}